@@ -0,0 +1,25 @@
+package api_client
+
+import (
+	"log"
+	"net/http"
+	"time"
+)
+
+// debugTransport logs each call to Kratos. Unlike the client's own debug mode it never dumps
+// request or response bodies, as those carry secrets such as recovery codes and TOTP keys.
+type debugTransport struct {
+	next http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t debugTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		log.Printf("Kratos %s %s failed after %v: %v", req.Method, req.URL.Path, time.Since(start), err)
+		return resp, err
+	}
+	log.Printf("Kratos %s %s returned %d in %v", req.Method, req.URL.Path, resp.StatusCode, time.Since(start))
+	return resp, nil
+}
@@ -1,12 +1,11 @@
 package api_client
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"context"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"net/http/cookiejar"
+	"net/url"
 
 	"github.com/davidoram/kratos-selfservice-ui-go/options"
 	kratos "github.com/ory/kratos-client-go"
@@ -30,7 +29,7 @@ func AdminClient() *kratos.APIClient {
 
 // Initializes the public client
 func InitPublicClient(opt *options.Options) (*kratos.APIClient, error) {
-	cfg, err := NewKratosConfig(opt)
+	cfg, err := NewKratosConfig(opt, opt.KratosPublicURL, opt.KratosPublicTLS)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +41,7 @@ func InitPublicClient(opt *options.Options) (*kratos.APIClient, error) {
 
 // Initializes the admin client
 func InitAdminClient(opt *options.Options) (*kratos.APIClient, error) {
-	cfg, err := NewKratosConfig(opt)
+	cfg, err := NewKratosConfig(opt, opt.KratosAdminURL, opt.KratosAdminTLS)
 	if err != nil {
 		return nil, err
 	}
@@ -52,56 +51,46 @@ func InitAdminClient(opt *options.Options) (*kratos.APIClient, error) {
 	return adminClientInstance, nil
 }
 
-// Creates a kratos client config from options
-func NewKratosConfig(opt *options.Options) (cfg *kratos.Configuration, err error) {
-	url := opt.KratosPublicURL
+// Creates a kratos client config for the API at url from options, connecting with tlsOpt
+func NewKratosConfig(opt *options.Options, url *url.URL, tlsOpt options.UpstreamTLSOptions) (cfg *kratos.Configuration, err error) {
 	cfg = kratos.NewConfiguration()
 
 	cfg.Host = url.Host
 	cfg.Scheme = url.Scheme
 	cfg.Servers = []kratos.ServerConfiguration{{URL: url.Path}}
 	cfg.UserAgent = "Public self service UI"
-	cj, err := cookiejar.New(nil) // TODO: don't know if this is actually necessary
+
+	var transport http.RoundTripper = http.DefaultTransport
+	tlsConfig, err := tlsOpt.Config()
 	if err != nil {
 		return nil, err
 	}
-	cfg.HTTPClient = &http.Client{Jar: cj}
-
-	if opt.TLSCertPath != "" {
-		tlsConfig, err := NewTLSConfig(opt.TLSCertPath, opt.TLSKeyPath, opt.TLSCaPath)
-		if err != nil {
-			return nil, err
-		}
-		cfg.HTTPClient.Transport = &http.Transport{
-			TLSClientConfig: tlsConfig,
+	if tlsConfig != nil {
+		if tlsOpt.InsecureSkipVerify {
+			log.Printf("WARNING: the %s API's certificate is not verified, do not use in production", tlsOpt.Name)
 		}
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		transport = t
 	}
 
-	return cfg, nil
-}
+	if opt.Debug {
+		transport = debugTransport{next: transport}
+	}
 
-// Creates a TLS config from certificate/key paths
-func NewTLSConfig(clientCertFile, clientKeyFile, caCertFile string) (*tls.Config, error) {
-	cfg := tls.Config{}
+	// Calls are retried and timed out by the transport, so a hung Kratos doesn't hang every handler
+	resilient := newResilientTransport(transport, opt.KratosTimeout, opt.KratosRetries)
+	cfg.HTTPClient = &http.Client{Transport: resilient}
 
-	// Load client cert
-	cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
-	if err != nil {
-		log.Printf("here")
-		return &cfg, err
-	}
-	cfg.Certificates = []tls.Certificate{cert}
+	return cfg, nil
+}
 
-	// Load CA cert
-	caCert, err := ioutil.ReadFile(caCertFile)
+// FetchIdentitySchema returns the raw JSON document of the identity schema with id.
+// The raw document is used rather than the decoded map, so the order of the properties is preserved.
+func FetchIdentitySchema(ctx context.Context, id string) ([]byte, error) {
+	_, rawResp, err := PublicClient().V0alpha2Api.GetJsonSchema(ctx, id).Execute()
 	if err != nil {
-		log.Printf("no here")
-		return &cfg, err
+		return nil, err
 	}
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(caCert)
-	cfg.RootCAs = caCertPool
-
-	cfg.BuildNameToCertificate()
-	return &cfg, err
+	return ioutil.ReadAll(rawResp.Body)
 }
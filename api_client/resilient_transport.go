@@ -0,0 +1,235 @@
+package api_client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// retryBaseDelay and retryMaxDelay bound the backoff between retries
+	retryBaseDelay = 100 * time.Millisecond
+	retryMaxDelay  = 2 * time.Second
+
+	// breakerThreshold is the number of consecutive failures that open the circuit breaker,
+	// and breakerCooldown how long it stays open before a trial request is let through
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without calling Kratos while the circuit breaker is open
+var ErrCircuitOpen = errors.New("kratos is unavailable, circuit breaker is open")
+
+// contextKey is the type of the keys of values stored in a request context
+type contextKey string
+
+// timeoutKey holds the timeout of calls made with a context, see WithTimeout
+const timeoutKey = contextKey("timeout")
+
+// WithTimeout returns a context whose calls to Kratos each may take d, rather than the configured timeout. It is for
+// calls that are expected to take longer, e.g. listing identities to export them, that no page waits for.
+func WithTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, timeoutKey, d)
+}
+
+// resilientTransport limits how long each call to Kratos may take, retries idempotent requests that fail,
+// and stops calling Kratos for a while once it has failed repeatedly
+type resilientTransport struct {
+	next http.RoundTripper
+
+	// timeout limits each attempt, including reading the response body, unless the request context gives
+	// another, see WithTimeout
+	timeout time.Duration
+
+	// retries is the number of times a failed GET is retried
+	retries int
+
+	breaker *circuitBreaker
+}
+
+// newResilientTransport wraps next
+func newResilientTransport(next http.RoundTripper, timeout time.Duration, retries int) *resilientTransport {
+	return &resilientTransport{
+		next:    next,
+		timeout: timeout,
+		retries: retries,
+		breaker: &circuitBreaker{threshold: breakerThreshold, cooldown: breakerCooldown},
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *resilientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(req) {
+		attempts += t.retries
+	}
+	var resp *http.Response
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			if err := sleep(req.Context(), backoff(attempt)); err != nil {
+				return nil, err
+			}
+			log.Printf("Retrying Kratos %s %s, attempt %d of %d", req.Method, req.URL.Path, attempt+1, attempts)
+		}
+		if !t.breaker.allow() {
+			return nil, ErrCircuitOpen
+		}
+		resp, err = t.attempt(req)
+		if req.Context().Err() != nil {
+			// The caller gave up, e.g. the browser disconnected, which says nothing about Kratos
+			t.breaker.abandon()
+			break
+		}
+		failed := err != nil || isServerFailure(resp.StatusCode)
+		t.breaker.record(!failed)
+		if !failed {
+			break
+		}
+		if attempt < attempts-1 && resp != nil {
+			// Discard the failed response before retrying
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+	return resp, err
+}
+
+// timeoutOf returns how long req may take
+func (t *resilientTransport) timeoutOf(req *http.Request) time.Duration {
+	if d, ok := req.Context().Value(timeoutKey).(time.Duration); ok {
+		return d
+	}
+	return t.timeout
+}
+
+// attempt makes a single call, cancelled after the timeout
+func (t *resilientTransport) attempt(req *http.Request) (*http.Response, error) {
+	timeout := t.timeoutOf(req)
+	if timeout <= 0 {
+		return t.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	resp, err := t.next.RoundTrip(req.Clone(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout also covers reading the body, so it is cancelled once the body is closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose cancels a context when the body it wraps is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close implements io.Closer
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// isIdempotent reports if req can be safely retried. Only requests without a body are retried,
+// as a consumed body cannot be sent again.
+func isIdempotent(req *http.Request) bool {
+	return (req.Method == http.MethodGet || req.Method == http.MethodHead) && req.Body == nil
+}
+
+// isServerFailure reports if the status shows Kratos, or a proxy in front of it, is failing
+func isServerFailure(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// backoff returns the delay before retry attempt, exponential with full jitter
+func backoff(attempt int) time.Duration {
+	max := retryBaseDelay << uint(attempt-1)
+	if max > retryMaxDelay {
+		max = retryMaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(max)) + 1)
+}
+
+// sleep waits for d, or until ctx is done
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// circuitBreaker opens after threshold consecutive failures. Once cooldown has passed a single
+// trial request is allowed, which closes the breaker if it succeeds.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// allow reports if a request may be made
+func (b *circuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	if b.trial || time.Since(b.openedAt) < b.cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// record notes the outcome of a request
+func (b *circuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := b.failures >= b.threshold
+	b.trial = false
+	if success {
+		if wasOpen {
+			log.Printf("Kratos is available again, closing circuit breaker")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		if !wasOpen {
+			log.Printf("Kratos failed %d times in a row, opening circuit breaker for %v", b.failures, b.cooldown)
+		}
+		b.openedAt = time.Now()
+	}
+}
+
+// abandon notes a request that ended without an outcome, so another can be tried if it was the trial
+func (b *circuitBreaker) abandon() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.trial = false
+}
+
+// IsUnavailable reports if a call to Kratos failed because Kratos could not be reached, timed out
+// or is failing, rather than rejecting the request
+func IsUnavailable(resp *http.Response, err error) bool {
+	if err == nil {
+		return false
+	}
+	return resp == nil || resp.StatusCode >= http.StatusInternalServerError
+}
@@ -0,0 +1,136 @@
+package api_client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResilientTransportRetriesGets(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: newResilientTransport(http.DefaultTransport, time.Second, 2)}
+
+	resp, err := client.Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// Requests with a body are not retried
+	atomic.StoreInt32(&calls, 0)
+	resp, err = client.Post(srv.URL, "application/json", strings.NewReader("{}"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestResilientTransportTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: newResilientTransport(http.DefaultTransport, 20*time.Millisecond, 0)}
+
+	start := time.Now()
+	_, err := client.Get(srv.URL)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(start)), int64(500*time.Millisecond))
+}
+
+func TestResilientTransportContextTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}))
+	defer srv.Close()
+	client := &http.Client{Transport: newResilientTransport(http.DefaultTransport, 20*time.Millisecond, 0)}
+
+	tests := []struct {
+		name string
+		path string
+		ctx  context.Context
+		ok   bool
+	}{
+		{"whoami", "/sessions/whoami", context.Background(), false},
+		// Pages list a user's sessions, so they have the same timeout as other calls
+		{"identity sessions", "/admin/identities/1/sessions", context.Background(), false},
+		{"identity list", "/admin/identities", context.Background(), false},
+		{"export", "/admin/identities", WithTimeout(context.Background(), time.Second), true},
+		{"shorter", "/admin/identities", WithTimeout(context.Background(), 10*time.Millisecond), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequestWithContext(tt.ctx, http.MethodGet, srv.URL+tt.path, nil)
+			require.NoError(t, err)
+			resp, err := client.Do(req)
+			if !tt.ok {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			resp.Body.Close()
+		})
+	}
+}
+
+func TestResilientTransportCallerCancelled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer srv.Close()
+	transport := newResilientTransport(http.DefaultTransport, time.Second, 2)
+	client := &http.Client{Transport: transport}
+
+	// Callers giving up, e.g. browsers disconnecting, don't count as Kratos failing
+	for i := 0; i < breakerThreshold+1; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		cancel()
+		assert.Error(t, err)
+	}
+	assert.Zero(t, transport.breaker.failures)
+	assert.True(t, transport.breaker.allow())
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := &circuitBreaker{threshold: 2, cooldown: 50 * time.Millisecond}
+	assert.True(t, b.allow())
+	b.record(false)
+	assert.True(t, b.allow())
+	b.record(false)
+	assert.False(t, b.allow(), "open after threshold failures")
+
+	time.Sleep(60 * time.Millisecond)
+	assert.True(t, b.allow(), "trial request after cooldown")
+	assert.False(t, b.allow(), "only one trial at a time")
+	b.abandon()
+	assert.True(t, b.allow(), "another trial once one is abandoned")
+	b.record(true)
+	assert.True(t, b.allow(), "closed after the trial succeeds")
+}
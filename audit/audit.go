@@ -0,0 +1,148 @@
+// Package audit records authentication events, such as sign ins and settings changes, to one or more sinks
+package audit
+
+import (
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
+)
+
+// Event types
+const (
+	SessionEstablished   = "session.established"
+	SessionCleared       = "session.cleared"
+	SecondFactorRequired = "session.2fa_required"
+	SettingsUpdated      = "settings.updated"
+	RecoveryUsed         = "recovery.used"
+	IdentitiesImported   = "identities.imported"
+	IdentitiesExported   = "identities.exported"
+	InviteCreated        = "invite.created"
+	InviteResent         = "invite.resent"
+	InviteRevoked        = "invite.revoked"
+	InviteAccepted       = "invite.accepted"
+	RegistrationRejected = "registration.rejected"
+)
+
+// Event outcomes
+const (
+	Success  = "success"
+	Failure  = "failure"
+	Required = "required"
+)
+
+// defaultMemorySize is the number of events kept in memory when no sink can be queried
+const defaultMemorySize = 1000
+
+// Event is an audit record
+type Event struct {
+	Time       time.Time `json:"time"`
+	Type       string    `json:"type"`
+	IdentityID string    `json:"identity_id,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	Outcome    string    `json:"outcome"`
+	Detail     string    `json:"detail,omitempty"`
+}
+
+// Sink stores events
+type Sink interface {
+	Write(e Event) error
+	Close() error
+}
+
+// Querier is a Sink that can read back the events it has stored
+type Querier interface {
+	Query(q Query) ([]Event, error)
+}
+
+// Query selects events, empty fields match all events
+type Query struct {
+	IdentityID string
+	Type       string
+
+	// Limit is the maximum number of events to return, newest first
+	Limit int
+}
+
+// Matches reports if e is selected by the query, ignoring the Limit
+func (q Query) Matches(e Event) bool {
+	return (q.IdentityID == "" || q.IdentityID == e.IdentityID) && (q.Type == "" || q.Type == e.Type)
+}
+
+// Audit sink instances
+var (
+	mu      sync.RWMutex
+	sinks   []Sink
+	querier Querier
+)
+
+// Init sets the sinks that events are written to. The first sink that can be queried serves Recent,
+// if there is none the most recent events are also kept in memory.
+func Init(s ...Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sinks = s
+	querier = nil
+	for _, sink := range s {
+		if q, ok := sink.(Querier); ok {
+			querier = q
+			break
+		}
+	}
+	if querier == nil {
+		m := NewMemorySink(defaultMemorySize)
+		sinks = append(sinks, m)
+		querier = m
+	}
+}
+
+// Close closes all the sinks
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	var firstErr error
+	for _, s := range sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	sinks, querier = nil, nil
+	return firstErr
+}
+
+// Record writes an event observed while handling r to the sinks
+func Record(r *http.Request, eventType, identityID, outcome, detail string) {
+	Log(Event{
+		Time:       time.Now().UTC(),
+		Type:       eventType,
+		IdentityID: identityID,
+		IP:         clientip.Of(r),
+		UserAgent:  r.UserAgent(),
+		Outcome:    outcome,
+		Detail:     detail,
+	})
+}
+
+// Log writes e to the sinks. Failures are logged, they don't stop the request being handled.
+func Log(e Event) {
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, s := range sinks {
+		if err := s.Write(e); err != nil {
+			log.Printf("Error writing audit event '%s': %v", e.Type, err)
+		}
+	}
+}
+
+// Recent returns the most recent events matching q, newest first
+func Recent(q Query) ([]Event, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if querier == nil {
+		return nil, nil
+	}
+	return querier.Query(q)
+}
@@ -0,0 +1,125 @@
+package audit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testEvents() []Event {
+	start := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	return []Event{
+		{Time: start, Type: SessionEstablished, IdentityID: "a", Outcome: Success},
+		{Time: start.Add(time.Minute), Type: SettingsUpdated, IdentityID: "b", Outcome: Failure},
+		{Time: start.Add(2 * time.Minute), Type: SettingsUpdated, IdentityID: "a", Outcome: Success},
+		{Time: start.Add(3 * time.Minute), Type: SessionCleared, IdentityID: "a", Outcome: Success},
+	}
+}
+
+func testQuerier(t *testing.T, s interface {
+	Sink
+	Querier
+}) {
+	for _, e := range testEvents() {
+		require.NoError(t, s.Write(e))
+	}
+
+	all, err := s.Query(Query{})
+	require.NoError(t, err)
+	assert.Len(t, all, 4)
+	assert.Equal(t, SessionCleared, all[0].Type, "newest first")
+
+	forA, err := s.Query(Query{IdentityID: "a", Limit: 2})
+	require.NoError(t, err)
+	if assert.Len(t, forA, 2) {
+		assert.Equal(t, SessionCleared, forA[0].Type)
+		assert.Equal(t, SettingsUpdated, forA[1].Type)
+	}
+
+	settings, err := s.Query(Query{Type: SettingsUpdated})
+	require.NoError(t, err)
+	assert.Len(t, settings, 2)
+}
+
+func TestMemorySink(t *testing.T) {
+	testQuerier(t, NewMemorySink(10))
+
+	// Once full the oldest events are dropped
+	m := NewMemorySink(2)
+	for _, e := range testEvents() {
+		require.NoError(t, m.Write(e))
+	}
+	events, err := m.Query(Query{})
+	require.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.Equal(t, SessionCleared, events[0].Type)
+		assert.Equal(t, SettingsUpdated, events[1].Type)
+	}
+}
+
+func TestFileSink(t *testing.T) {
+	s, err := NewFileSink(filepath.Join(t.TempDir(), "audit.jsonl"))
+	require.NoError(t, err)
+	defer s.Close()
+	testQuerier(t, s)
+}
+
+func TestFileSinkReadsBackwards(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	s, err := NewFileSink(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Enough events that lines span the chunks the file is read in
+	const n = 2000
+	for i := 0; i < n; i++ {
+		require.NoError(t, s.Write(Event{Type: SessionEstablished, IdentityID: fmt.Sprintf("i%d", i%10), Detail: fmt.Sprintf("%04d %s", i, strings.Repeat("x", 50))}))
+	}
+	// A line being written is skipped
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	f.WriteString(`{"type":"sign`)
+	f.Close()
+
+	events, err := s.Query(Query{})
+	require.NoError(t, err)
+	require.Len(t, events, n)
+	for i, e := range events {
+		assert.True(t, strings.HasPrefix(e.Detail, fmt.Sprintf("%04d ", n-1-i)), "newest first")
+	}
+
+	events, err = s.Query(Query{IdentityID: "i3", Limit: 2})
+	require.NoError(t, err)
+	if assert.Len(t, events, 2) {
+		assert.True(t, strings.HasPrefix(events[0].Detail, "1993 "))
+		assert.True(t, strings.HasPrefix(events[1].Detail, "1983 "))
+	}
+}
+
+func TestSQLSink(t *testing.T) {
+	s, err := NewSQLSink("sqlite", filepath.Join(t.TempDir(), "audit.db"))
+	require.NoError(t, err)
+	defer s.Close()
+	testQuerier(t, s)
+
+	// Times are read back as written
+	events, err := s.Query(Query{Limit: 1})
+	require.NoError(t, err)
+	if assert.Len(t, events, 1) {
+		assert.True(t, testEvents()[3].Time.Equal(events[0].Time))
+	}
+
+	_, err = NewSQLSink("mysql", "user@/audit")
+	assert.Error(t, err)
+}
+
+func TestSQLRebind(t *testing.T) {
+	assert.Equal(t, "a = ? AND b = ?", (&SQLSink{}).rebind("a = ? AND b = ?"))
+	assert.Equal(t, "a = $1 AND b = $2", (&SQLSink{dollarPlaceholders: true}).rebind("a = ? AND b = ?"))
+}
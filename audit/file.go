@@ -0,0 +1,110 @@
+package audit
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileSink appends events to a file as JSON lines
+type FileSink struct {
+	path string
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens, creating if required, the JSON lines file at path
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{path: path, f: f}, nil
+}
+
+// Write appends e to the file
+func (s *FileSink) Write(e Event) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+// Query reads the file backwards, returning the events matching q, newest first. Reading stops once q.Limit
+// events are found, so recent events are found without reading the whole file.
+// Lines that are not valid events are skipped.
+func (s *FileSink) Query(q Query) ([]Event, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var found []Event
+	err = readLinesBackwards(f, func(line []byte) bool {
+		var e Event
+		if err := json.Unmarshal(line, &e); err == nil && q.Matches(e) {
+			found = append(found, e)
+		}
+		return q.Limit <= 0 || len(found) < q.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return found, nil
+}
+
+// fileChunkSize is how much of the file is read at a time, reading it backwards
+const fileChunkSize = 64 << 10
+
+// readLinesBackwards calls fn with each line of f, the last first, until fn returns false. The line is only
+// valid during the call.
+func readLinesBackwards(f *os.File, fn func(line []byte) bool) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	pos := info.Size()
+	buf := make([]byte, fileChunkSize)
+	// partial is the start of a line whose end has been read, before the chunk read next
+	var partial []byte
+	for pos > 0 {
+		n := int64(len(buf))
+		if pos < n {
+			n = pos
+		}
+		pos -= n
+		if _, err := f.ReadAt(buf[:n], pos); err != nil {
+			return err
+		}
+		chunk := append(buf[:n:n], partial...)
+		for {
+			i := bytes.LastIndexByte(chunk, '\n')
+			if i < 0 {
+				break
+			}
+			if line := chunk[i+1:]; len(line) > 0 && !fn(line) {
+				return nil
+			}
+			chunk = chunk[:i]
+		}
+		partial = append(partial[:0:0], chunk...)
+	}
+	if len(partial) > 0 {
+		fn(partial)
+	}
+	return nil
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
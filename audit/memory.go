@@ -0,0 +1,55 @@
+package audit
+
+import "sync"
+
+// MemorySink keeps the most recent events in memory
+type MemorySink struct {
+	mu     sync.Mutex
+	events []Event
+	next   int
+	full   bool
+}
+
+// NewMemorySink returns a sink that keeps the last size events
+func NewMemorySink(size int) *MemorySink {
+	return &MemorySink{events: make([]Event, size)}
+}
+
+// Write stores e, replacing the oldest event once full
+func (m *MemorySink) Write(e Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[m.next] = e
+	m.next = (m.next + 1) % len(m.events)
+	if m.next == 0 {
+		m.full = true
+	}
+	return nil
+}
+
+// Query returns the events matching q, newest first
+func (m *MemorySink) Query(q Query) ([]Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	count := m.next
+	if m.full {
+		count = len(m.events)
+	}
+	var found []Event
+	for i := 1; i <= count; i++ {
+		e := m.events[(m.next-i+len(m.events))%len(m.events)]
+		if !q.Matches(e) {
+			continue
+		}
+		found = append(found, e)
+		if q.Limit > 0 && len(found) == q.Limit {
+			break
+		}
+	}
+	return found, nil
+}
+
+// Close does nothing
+func (m *MemorySink) Close() error {
+	return nil
+}
@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	// The supported SQL drivers, see SQLDrivers
+	_ "github.com/jackc/pgx/v4/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// SQLDrivers are the names of the SQL drivers audit events can be stored with, PostgreSQL and SQLite
+var SQLDrivers = []string{"pgx", "sqlite"}
+
+// sqlTimeFormat stores times as text that sorts in time order, whatever the database
+const sqlTimeFormat = "2006-01-02T15:04:05.000000Z"
+
+// SQLSink stores events in the 'audit_events' table of a SQL database
+type SQLSink struct {
+	db *sql.DB
+
+	// dollarPlaceholders is set for databases that use $1, $2.. rather than ? as placeholders
+	dollarPlaceholders bool
+}
+
+// NewSQLSink opens the database with driverName, one of SQLDrivers, and dsn, and creates the
+// 'audit_events' table if it does not exist.
+func NewSQLSink(driverName, dsn string) (*SQLSink, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLSink{
+		db:                 db,
+		dollarPlaceholders: driverName == "pgx",
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS audit_events (
+		time VARCHAR(32) NOT NULL,
+		type VARCHAR(64) NOT NULL,
+		identity_id VARCHAR(64) NOT NULL,
+		ip VARCHAR(64) NOT NULL,
+		user_agent TEXT NOT NULL,
+		outcome VARCHAR(16) NOT NULL,
+		detail TEXT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("creating audit_events table: %w", err)
+	}
+	return s, nil
+}
+
+// Write inserts e
+func (s *SQLSink) Write(e Event) error {
+	_, err := s.db.Exec(
+		s.rebind("INSERT INTO audit_events (time, type, identity_id, ip, user_agent, outcome, detail) VALUES (?, ?, ?, ?, ?, ?, ?)"),
+		e.Time.UTC().Format(sqlTimeFormat), e.Type, e.IdentityID, e.IP, e.UserAgent, e.Outcome, e.Detail)
+	return err
+}
+
+// Query selects the events matching q, newest first
+func (s *SQLSink) Query(q Query) ([]Event, error) {
+	query := "SELECT time, type, identity_id, ip, user_agent, outcome, detail FROM audit_events"
+	var where []string
+	var args []interface{}
+	if q.IdentityID != "" {
+		where = append(where, "identity_id = ?")
+		args = append(args, q.IdentityID)
+	}
+	if q.Type != "" {
+		where = append(where, "type = ?")
+		args = append(args, q.Type)
+	}
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time DESC"
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+
+	rows, err := s.db.Query(s.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var found []Event
+	for rows.Next() {
+		var e Event
+		var t string
+		if err := rows.Scan(&t, &e.Type, &e.IdentityID, &e.IP, &e.UserAgent, &e.Outcome, &e.Detail); err != nil {
+			return nil, err
+		}
+		e.Time, _ = time.Parse(sqlTimeFormat, t)
+		found = append(found, e)
+	}
+	return found, rows.Err()
+}
+
+// Close closes the database
+func (s *SQLSink) Close() error {
+	return s.db.Close()
+}
+
+// rebind replaces the ? placeholders in query with $1, $2.. if the database requires them
+func (s *SQLSink) rebind(query string) string {
+	if !s.dollarPlaceholders {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			fmt.Fprintf(&b, "$%d", n)
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
@@ -0,0 +1,40 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// SyslogSink sends events, as JSON, to the local syslog daemon
+type SyslogSink struct {
+	w *syslog.Writer
+}
+
+// NewSyslogSink connects to the local syslog daemon, tagging messages with tag
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{w: w}, nil
+}
+
+// Write sends e, failures are sent with warning severity
+func (s *SyslogSink) Write(e Event) error {
+	msg, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if e.Outcome == Failure {
+		return s.w.Warning(string(msg))
+	}
+	return s.w.Info(string(msg))
+}
+
+// Close disconnects from the syslog daemon
+func (s *SyslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,24 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package audit
+
+import "errors"
+
+// SyslogSink is not available on this platform
+type SyslogSink struct{}
+
+// NewSyslogSink returns an error, syslog is not available on this platform
+func NewSyslogSink(tag string) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on this platform")
+}
+
+// Write does nothing
+func (s *SyslogSink) Write(e Event) error {
+	return nil
+}
+
+// Close does nothing
+func (s *SyslogSink) Close() error {
+	return nil
+}
@@ -0,0 +1,156 @@
+// Package challenge asks browsers to prove they are used by a human, or at least to spend some effort, before
+// forms are submitted to Kratos. Providers verify CAPTCHA style tokens, or a proof of work, and the Guard only
+// asks for the challenge after repeated failed attempts from a client.
+package challenge
+
+import (
+	"context"
+	"errors"
+	"html/template"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
+)
+
+// MessageID is the id of the message shown when a challenge isn't completed
+const MessageID = 4190101
+
+// DefaultWindow is how long failed attempts are counted for, unless configured otherwise
+const DefaultWindow = time.Hour
+
+// ErrFailed is wrapped by the errors of challenges that were not completed
+var ErrFailed = errors.New("challenge not completed")
+
+// Widget is what a provider renders in the form
+type Widget struct {
+	// ScriptURL is an external script the page loads. Optional.
+	ScriptURL string
+
+	// Asset is the path of a static file of this app that the page loads as a script. Optional.
+	Asset string
+
+	// HTML is inserted in the form
+	HTML template.HTML
+}
+
+// Provider issues and verifies challenges
+type Provider interface {
+	// Widget returns the challenge to render in a form
+	Widget(r *http.Request) (Widget, error)
+
+	// Verify checks the challenge was completed, from the form posted in r. Errors that aren't the
+	// user's fault don't wrap ErrFailed.
+	Verify(ctx context.Context, r *http.Request) error
+}
+
+// Guard decides when a challenge is required, and verifies it. It is safe for concurrent use.
+type Guard struct {
+	Provider Provider
+
+	// After is the number of failed attempts a client can make within Window before the challenge is
+	// required, 0 always requires it
+	After int
+
+	// Window is how long failed attempts are counted for, DefaultWindow if not set
+	Window time.Duration
+
+	// Now returns the current time, time.Now if not set
+	Now func() time.Time
+
+	mu       sync.Mutex
+	failures map[string][]time.Time
+	pending  map[string]attempt
+}
+
+// attempt is a flow submitted by a client, whose outcome is not known yet
+type attempt struct {
+	client string
+	at     time.Time
+}
+
+// Required reports if the client making r must complete the challenge
+func (g *Guard) Required(r *http.Request) bool {
+	if g.After <= 0 {
+		return true
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.recentFailures(clientip.Of(r))) >= g.After
+}
+
+// Check verifies the challenge posted in r, if the client must complete it
+func (g *Guard) Check(ctx context.Context, r *http.Request) error {
+	if !g.Required(r) {
+		return nil
+	}
+	return g.Provider.Verify(ctx, r)
+}
+
+// Submitted records that the client making r submitted the flow, its outcome is given to Returned when
+// Kratos shows the flow again
+func (g *Guard) Submitted(r *http.Request, flowID string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if g.pending == nil {
+		g.pending = make(map[string]attempt)
+	}
+	for id, a := range g.pending {
+		if now.Sub(a.at) > g.window() {
+			delete(g.pending, id)
+		}
+	}
+	g.pending[flowID] = attempt{client: clientip.Of(r), at: now}
+}
+
+// Returned records the outcome of a submitted flow, once Kratos shows it again. Failures count towards
+// requiring the challenge, flows that weren't submitted through the guard are ignored.
+func (g *Guard) Returned(flowID string, failed bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	a, ok := g.pending[flowID]
+	if !ok {
+		return
+	}
+	delete(g.pending, flowID)
+	if !failed {
+		return
+	}
+	if g.failures == nil {
+		g.failures = make(map[string][]time.Time)
+	}
+	g.failures[a.client] = append(g.recentFailures(a.client), g.now())
+}
+
+// recentFailures returns the client's failures within the window, forgetting older ones
+func (g *Guard) recentFailures(client string) []time.Time {
+	cutoff := g.now().Add(-g.window())
+	recent := g.failures[client]
+	for len(recent) > 0 && recent[0].Before(cutoff) {
+		recent = recent[1:]
+	}
+	if len(recent) == 0 {
+		delete(g.failures, client)
+		return nil
+	}
+	g.failures[client] = recent
+	return recent
+}
+
+// window returns how long failures are counted for
+func (g *Guard) window() time.Duration {
+	if g.Window > 0 {
+		return g.Window
+	}
+	return DefaultWindow
+}
+
+// now returns the current time
+func (g *Guard) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
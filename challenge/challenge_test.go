@@ -0,0 +1,156 @@
+package challenge
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// postForm returns a request posting form from addr
+func postForm(addr string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, "/registration", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.RemoteAddr = addr
+	return r
+}
+
+// alwaysFails is a Provider that fails every challenge
+type alwaysFails struct{}
+
+func (alwaysFails) Widget(r *http.Request) (Widget, error) { return Widget{}, nil }
+
+func (alwaysFails) Verify(ctx context.Context, r *http.Request) error { return ErrFailed }
+
+func TestGuardRequiredAfterFailures(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	g := &Guard{Provider: alwaysFails{}, After: 2, Now: func() time.Time { return now }}
+	r := postForm("10.0.0.1:1234", nil)
+	other := postForm("10.0.0.2:1234", nil)
+
+	for i, flow := range []string{"f1", "f2"} {
+		require.False(t, g.Required(r), "challenge required after %d failures", i)
+		require.NoError(t, g.Check(context.Background(), r))
+		g.Submitted(r, flow)
+		g.Returned(flow, true)
+	}
+	assert.True(t, g.Required(r), "challenge not required after 2 failures")
+	assert.True(t, errors.Is(g.Check(context.Background(), r), ErrFailed))
+	assert.False(t, g.Required(other), "challenge required for another client")
+
+	// Flows that succeed, or weren't submitted through the guard, don't count
+	g.Submitted(other, "f3")
+	g.Returned("f3", false)
+	g.Returned("unknown", true)
+	assert.False(t, g.Required(other), "challenge required for a client without failures")
+
+	now = now.Add(DefaultWindow + time.Second)
+	assert.False(t, g.Required(r), "challenge still required after the window")
+}
+
+func TestGuardAlwaysRequired(t *testing.T) {
+	g := &Guard{Provider: alwaysFails{}}
+	assert.True(t, g.Required(postForm("10.0.0.1:1234", nil)))
+}
+
+// challengePattern matches the challenge in the proof of work widget
+var challengePattern = regexp.MustCompile(`name="pow_challenge" value="([^"]+)"`)
+
+// solve finds the solution to a proof of work challenge
+func solve(c string, difficulty int) string {
+	for n := 0; ; n++ {
+		s := strconv.Itoa(n)
+		if LeadingZeroBits(sha256.Sum256([]byte(c+":"+s))) >= difficulty {
+			return s
+		}
+	}
+}
+
+func TestProofOfWork(t *testing.T) {
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := &ProofOfWork{Secret: []byte("secret"), Difficulty: 8, Now: func() time.Time { return now }}
+	w, err := p.Widget(httptest.NewRequest(http.MethodGet, "/registration", nil))
+	require.NoError(t, err)
+	assert.Equal(t, "static/js/pow.js", w.Asset)
+	m := challengePattern.FindStringSubmatch(string(w.HTML))
+	require.NotNil(t, m, "no challenge in %s", w.HTML)
+	c := m[1]
+	solution := solve(c, 8)
+
+	tests := []struct {
+		name     string
+		form     url.Values
+		wantFail bool
+	}{
+		{"no solution", url.Values{"pow_challenge": {c}}, true},
+		{"wrong solution", url.Values{"pow_challenge": {c}, "pow_solution": {solution + "x"}}, true},
+		{"forged challenge", url.Values{"pow_challenge": {c + "x"}, "pow_solution": {solution}}, true},
+		{"solved", url.Values{"pow_challenge": {c}, "pow_solution": {solution}}, false},
+		{"replayed", url.Values{"pow_challenge": {c}, "pow_solution": {solution}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := p.Verify(context.Background(), postForm("10.0.0.1:1234", tt.form))
+			if tt.wantFail {
+				assert.True(t, errors.Is(err, ErrFailed), "expected failure, got %v", err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	// Challenges expire
+	w, _ = p.Widget(httptest.NewRequest(http.MethodGet, "/registration", nil))
+	c = challengePattern.FindStringSubmatch(string(w.HTML))[1]
+	now = now.Add(powTTL)
+	err = p.Verify(context.Background(), postForm("10.0.0.1:1234", url.Values{"pow_challenge": {c}, "pow_solution": {solve(c, 8)}}))
+	assert.True(t, errors.Is(err, ErrFailed), "expected expired challenge to fail, got %v", err)
+}
+
+func TestSiteVerify(t *testing.T) {
+	var got url.Values
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		got = r.PostForm
+		if r.PostForm.Get("response") == "good" {
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		w.Write([]byte(`{"success": false, "error-codes": ["invalid-input-response"]}`))
+	}))
+	defer srv.Close()
+
+	s := HCaptcha("site-key", "secret")
+	s.VerifyURL = srv.URL
+
+	w, err := s.Widget(httptest.NewRequest(http.MethodGet, "/registration", nil))
+	require.NoError(t, err)
+	assert.Contains(t, string(w.HTML), `data-sitekey="site-key"`)
+	assert.Equal(t, "https://js.hcaptcha.com/1/api.js", w.ScriptURL)
+
+	require.NoError(t, s.Verify(context.Background(), postForm("10.0.0.1:1234", url.Values{"h-captcha-response": {"good"}})))
+	assert.Equal(t, "secret", got.Get("secret"))
+	assert.Equal(t, "10.0.0.1", got.Get("remoteip"))
+
+	err = s.Verify(context.Background(), postForm("10.0.0.1:1234", url.Values{"h-captcha-response": {"bad"}}))
+	assert.True(t, errors.Is(err, ErrFailed), "expected failure, got %v", err)
+	assert.Contains(t, err.Error(), "invalid-input-response")
+	err = s.Verify(context.Background(), postForm("10.0.0.1:1234", nil))
+	assert.True(t, errors.Is(err, ErrFailed), "expected failure without a token, got %v", err)
+
+	// Errors reaching the service aren't the user's fault
+	srv.Close()
+	err = s.Verify(context.Background(), postForm("10.0.0.1:1234", url.Values{"h-captcha-response": {"good"}}))
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrFailed))
+}
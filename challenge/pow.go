@@ -0,0 +1,138 @@
+package challenge
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDifficulty is the number of leading zero bits a proof of work hash needs, unless configured otherwise.
+// Browsers take around a second to find one.
+const DefaultDifficulty = 16
+
+// powTTL is how long a proof of work challenge can be solved for
+const powTTL = 10 * time.Minute
+
+// ProofOfWork is a Provider that needs no external service. The browser finds a number that, appended to a
+// signed challenge, has a SHA-256 hash starting with Difficulty zero bits, see static/js/pow.js. Each challenge
+// can only be used once.
+type ProofOfWork struct {
+	// Secret signs the challenges
+	Secret []byte
+
+	// Difficulty is the number of leading zero bits the hash needs, DefaultDifficulty if not set
+	Difficulty int
+
+	// Now returns the current time, time.Now if not set
+	Now func() time.Time
+
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+// Widget returns the hidden inputs holding a new challenge, and its solution once the script finds it
+func (p *ProofOfWork) Widget(r *http.Request) (Widget, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Widget{}, err
+	}
+	payload := fmt.Sprintf("%d.%d.%s", p.now().Add(powTTL).Unix(), p.difficulty(), base64.RawURLEncoding.EncodeToString(nonce))
+	c := payload + "." + p.sign(payload)
+	return Widget{
+		Asset: "static/js/pow.js",
+		HTML: template.HTML(fmt.Sprintf(`<input type="hidden" name="pow_challenge" value="%s" data-pow-difficulty="%d" />`+
+			`<input type="hidden" name="pow_solution" value="" />`+
+			`<p class="typography-caption" data-pow-status>Checking your browser before the form is sent.</p>`,
+			template.HTMLEscapeString(c), p.difficulty())),
+	}, nil
+}
+
+// Verify checks the posted solution solves the posted challenge, which this provider issued
+func (p *ProofOfWork) Verify(ctx context.Context, r *http.Request) error {
+	c, solution := r.PostFormValue("pow_challenge"), r.PostFormValue("pow_solution")
+	if c == "" || solution == "" {
+		return fmt.Errorf("%w: no solution", ErrFailed)
+	}
+	i := strings.LastIndex(c, ".")
+	if i < 0 || !hmac.Equal([]byte(c[i+1:]), []byte(p.sign(c[:i]))) {
+		return fmt.Errorf("%w: invalid challenge", ErrFailed)
+	}
+	parts := strings.SplitN(c[:i], ".", 3)
+	expires, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil || len(parts) != 3 {
+		return fmt.Errorf("%w: invalid challenge", ErrFailed)
+	}
+	difficulty, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("%w: invalid challenge", ErrFailed)
+	}
+	expiresAt := time.Unix(expires, 0)
+	now := p.now()
+	if !now.Before(expiresAt) {
+		return fmt.Errorf("%w: challenge expired", ErrFailed)
+	}
+	if LeadingZeroBits(sha256.Sum256([]byte(c+":"+solution))) < difficulty {
+		return fmt.Errorf("%w: wrong solution", ErrFailed)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.used == nil {
+		p.used = make(map[string]time.Time)
+	}
+	for used, at := range p.used {
+		if !now.Before(at) {
+			delete(p.used, used)
+		}
+	}
+	if _, ok := p.used[c]; ok {
+		return fmt.Errorf("%w: challenge already used", ErrFailed)
+	}
+	p.used[c] = expiresAt
+	return nil
+}
+
+// LeadingZeroBits returns the number of zero bits a hash starts with
+func LeadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// sign returns the base64 HMAC of a challenge payload
+func (p *ProofOfWork) sign(payload string) string {
+	mac := hmac.New(sha256.New, p.Secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// difficulty returns the number of leading zero bits needed
+func (p *ProofOfWork) difficulty() int {
+	if p.Difficulty > 0 {
+		return p.Difficulty
+	}
+	return DefaultDifficulty
+}
+
+// now returns the current time
+func (p *ProofOfWork) now() time.Time {
+	if p.Now != nil {
+		return p.Now()
+	}
+	return time.Now()
+}
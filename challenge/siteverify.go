@@ -0,0 +1,105 @@
+package challenge
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
+)
+
+// SiteVerify is a Provider for CAPTCHA services that render a widget with a site key, which puts a token in
+// the form that is verified with a secret, such as hCaptcha and Cloudflare Turnstile
+type SiteVerify struct {
+	// ScriptURL is the service's script that renders the widget
+	ScriptURL string
+
+	// VerifyURL is the service's endpoint that verifies tokens
+	VerifyURL string
+
+	// WidgetClass is the class of the element the script renders the widget in
+	WidgetClass string
+
+	// ResponseField is the form field the script puts the token in
+	ResponseField string
+
+	SiteKey string
+	Secret  string
+
+	// HTTPClient calls the service, http.DefaultClient if nil
+	HTTPClient *http.Client
+}
+
+// HCaptcha returns a provider verifying hCaptcha tokens
+func HCaptcha(siteKey, secret string) *SiteVerify {
+	return &SiteVerify{
+		ScriptURL:     "https://js.hcaptcha.com/1/api.js",
+		VerifyURL:     "https://api.hcaptcha.com/siteverify",
+		WidgetClass:   "h-captcha",
+		ResponseField: "h-captcha-response",
+		SiteKey:       siteKey,
+		Secret:        secret,
+	}
+}
+
+// Turnstile returns a provider verifying Cloudflare Turnstile tokens
+func Turnstile(siteKey, secret string) *SiteVerify {
+	return &SiteVerify{
+		ScriptURL:     "https://challenges.cloudflare.com/turnstile/v0/api.js",
+		VerifyURL:     "https://challenges.cloudflare.com/turnstile/v0/siteverify",
+		WidgetClass:   "cf-turnstile",
+		ResponseField: "cf-turnstile-response",
+		SiteKey:       siteKey,
+		Secret:        secret,
+	}
+}
+
+// Widget returns the element the service's script renders the widget in
+func (s *SiteVerify) Widget(r *http.Request) (Widget, error) {
+	return Widget{
+		ScriptURL: s.ScriptURL,
+		HTML: template.HTML(fmt.Sprintf(`<div class="%s" data-sitekey="%s"></div>`,
+			template.HTMLEscapeString(s.WidgetClass), template.HTMLEscapeString(s.SiteKey))),
+	}, nil
+}
+
+// Verify checks the token in the form with the service
+func (s *SiteVerify) Verify(ctx context.Context, r *http.Request) error {
+	token := r.PostFormValue(s.ResponseField)
+	if token == "" {
+		return fmt.Errorf("%w: no token", ErrFailed)
+	}
+	form := url.Values{"secret": {s.Secret}, "response": {token}, "remoteip": {clientip.Of(r)}, "sitekey": {s.SiteKey}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.VerifyURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	client := s.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("verifying token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("verifying token: %s", resp.Status)
+	}
+	var result struct {
+		Success    bool     `json:"success"`
+		ErrorCodes []string `json:"error-codes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("verifying token: %w", err)
+	}
+	if !result.Success {
+		return fmt.Errorf("%w: %s", ErrFailed, strings.Join(result.ErrorCodes, ", "))
+	}
+	return nil
+}
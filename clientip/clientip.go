@@ -0,0 +1,110 @@
+// Package clientip finds the IP address of the client that made a request. When the app runs behind proxies
+// the request comes from the proxy, so the client is read from the X-Forwarded-For header, but only when the
+// request came from a trusted proxy, as clients can send the header themselves.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	mu      sync.RWMutex
+	trusted []*net.IPNet
+)
+
+// ParseProxies parses a list of proxy IP addresses or CIDR ranges, e.g. "10.0.0.1" or "10.0.0.0/8"
+func ParseProxies(list []string) ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(list))
+	for _, s := range list {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid proxy IP address '%s'", s)
+			}
+			bits := 8 * net.IPv4len
+			if ip.To4() == nil {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy CIDR range '%s'", s)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// SetTrustedProxies sets the proxies whose X-Forwarded-For header is believed, none until it is set
+func SetTrustedProxies(proxies []*net.IPNet) {
+	mu.Lock()
+	defer mu.Unlock()
+	trusted = proxies
+}
+
+// Of returns the IP address of the client that made r. The X-Forwarded-For header is read from right to left,
+// while the address it came from is a trusted proxy, so clients can't choose the address they are seen as.
+func Of(r *http.Request) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	ip := hostOf(r.RemoteAddr)
+	if !isTrusted(ip) {
+		return ip
+	}
+	return forwarded(r.Header, ip)
+}
+
+// Forwarded returns the IP address of the client from the X-Forwarded-For header of a request made to another
+// service, e.g. the request headers Kratos passes to hooks, or "" if there is no header. The service's peer
+// isn't known, so it is taken to be the trusted proxy that added the last hop.
+func Forwarded(h http.Header) string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return forwarded(h, "")
+}
+
+// forwarded reads the X-Forwarded-For header from right to left, returning the first hop that isn't a trusted
+// proxy, or the last one read if all are. ip is returned if the header has no hops.
+func forwarded(h http.Header, ip string) string {
+	hops := strings.Split(strings.Join(h.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hostOf(hop)
+		if !isTrusted(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// isTrusted reports if ip is one of the trusted proxies
+func isTrusted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf returns the address without its port, if it has one
+func hostOf(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
@@ -0,0 +1,67 @@
+package clientip
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOf(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.1", "192.168.0.0/16", "fd00::1"})
+	require.NoError(t, err)
+	SetTrustedProxies(proxies)
+	defer SetTrustedProxies(nil)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.5:1234", nil, "203.0.113.5"},
+		{"untrusted peer's header ignored", "203.0.113.5:1234", []string{"198.51.100.1"}, "203.0.113.5"},
+		{"trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of trusted proxies", "10.0.0.1:1234", []string{"198.51.100.1, 192.168.1.2"}, "198.51.100.1"},
+		{"spoofed hops before the client", "10.0.0.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", "10.0.0.1:1234", []string{"198.51.100.1", "192.168.1.2"}, "198.51.100.1"},
+		{"ipv6 proxy", "[fd00::1]:1234", []string{"2001:db8::7"}, "2001:db8::7"},
+		{"trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"only trusted hops", "10.0.0.1:1234", []string{"192.168.1.2"}, "192.168.1.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, v := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			assert.Equal(t, tt.want, Of(r))
+		})
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	proxies, err := ParseProxies([]string{"10.0.0.1"})
+	require.NoError(t, err)
+	SetTrustedProxies(proxies)
+	defer SetTrustedProxies(nil)
+
+	h := http.Header{}
+	assert.Equal(t, "", Forwarded(h))
+	h.Set("X-Forwarded-For", "198.51.100.1")
+	assert.Equal(t, "198.51.100.1", Forwarded(h))
+	h.Set("X-Forwarded-For", "1.2.3.4, 198.51.100.1, 10.0.0.1")
+	assert.Equal(t, "198.51.100.1", Forwarded(h), "spoofed hops before the client and trusted hops after it are skipped")
+}
+
+func TestParseProxies(t *testing.T) {
+	_, err := ParseProxies([]string{"10.0.0.1", "10.0.0.0/8", "::1"})
+	assert.NoError(t, err)
+	_, err = ParseProxies([]string{"proxy.local"})
+	assert.Error(t, err)
+	_, err = ParseProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+}
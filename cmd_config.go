@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"github.com/davidoram/kratos-selfservice-ui-go/registration"
+)
+
+// redactedFlags hold secrets, so their values are not printed
+var redactedFlags = map[string]bool{
+	"cookie-store-key-pairs": true,
+	"hook-secret":            true,
+	"audit-sql-dsn":          true,
+	"challenge-secret":       true,
+	"incident-webhook-url":   true,
+}
+
+// runConfigValidate is the config validate command
+func runConfigValidate(name string, args []string) int {
+	opt, _, code, ok := parseOptions(name, args, "Validate the configuration given by flags and envars, including loading the TLS certificates, as serve would.")
+	if !ok {
+		return code
+	}
+	if err := validateConfig(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	fmt.Println("Configuration is valid")
+	return exitOK
+}
+
+// validateConfig checks the options, and that the TLS certificates can be loaded
+func validateConfig(opt *options.Options) error {
+	if err := opt.Validate(); err != nil {
+		return err
+	}
+	if _, err := opt.ServerTLS.Config(); err != nil {
+		return err
+	}
+	for _, u := range []options.UpstreamTLSOptions{opt.KratosPublicTLS, opt.KratosAdminTLS} {
+		if _, err := u.Config(); err != nil {
+			return err
+		}
+	}
+	if opt.RegistrationPolicy != "" {
+		if _, err := registration.LoadConfig(opt.RegistrationPolicy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runConfigPrint is the config print command
+func runConfigPrint(name string, args []string) int {
+	_, fs, code, ok := parseOptions(name, args, "Print the configuration given by flags and envars, with secrets redacted.")
+	if !ok {
+		return code
+	}
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if redactedFlags[f.Name] && value != "" {
+			value = "[redacted]"
+		}
+		fmt.Printf("%s=%s\n", f.Name, value)
+	})
+	return exitOK
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// doctorTimeout limits how long the doctor command waits for Kratos
+const doctorTimeout = 10 * time.Second
+
+// doctorResult is the outcome of one of the doctor's checks
+type doctorResult struct {
+	name   string
+	ok     bool
+	detail string
+
+	// skip is set if the check couldn't be made, it is not a failure
+	skip bool
+}
+
+// doctorFlow is a Kratos flow with a page in this UI
+type doctorFlow struct {
+	name string
+
+	// route is the path of the flow's page, as registered in main
+	route string
+
+	// browser is set if the flow can be started in a browser without a session, so its ui_url can be seen live
+	browser bool
+}
+
+// doctorFlows are the flows whose ui_url must point at this UI
+var doctorFlows = []doctorFlow{
+	{"login", "/login", true},
+	{"registration", "/registration", true},
+	{"settings", "/settings", false},
+	{"recovery", "/recovery", true},
+	{"verification", "/verification", true},
+	{"error", "/error", false},
+}
+
+// supportedMethods are the Kratos self service methods this UI has pages for
+var supportedMethods = map[string]bool{
+	"password":      true,
+	"oidc":          true,
+	"totp":          true,
+	"lookup_secret": true,
+	"webauthn":      true,
+	"profile":       true,
+	"link":          true,
+}
+
+// runDoctor is the doctor command
+func runDoctor(name string, args []string) int {
+	fs := newFlagSet(name, "[flags]", "Check that the Kratos public and admin APIs can be reached and are ready, and that Kratos is configured\n"+
+		"for this UI, using the same configuration as serve. Kratos is asked to start flows to see where it sends the browser,\n"+
+		"and its config file is checked too if 'kratos-config' is given.")
+	kratosConfig := fs.String("kratos-config", "", "Path of the Kratos config file, e.g. kratos.yml, to cross-check with this UI's configuration")
+	opt, code, ok := parseOptionsWith(fs, args)
+	if ok {
+		code, ok = checkArgs(fs, 0)
+	}
+	if !ok {
+		return code
+	}
+	if err := validateConfig(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), doctorTimeout)
+	defer cancel()
+	results := doctorChecks(ctx, opt)
+	if *kratosConfig != "" {
+		cfg, err := loadKratosConfig(*kratosConfig)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		results = append(results, kratosConfigChecks(opt, cfg)...)
+	}
+
+	failed, skipped := 0, 0
+	for _, r := range results {
+		status := "PASS"
+		switch {
+		case r.skip:
+			status = "SKIP"
+			skipped++
+		case !r.ok:
+			status = "FAIL"
+			failed++
+		}
+		fmt.Printf("%s  %s: %s\n", status, r.name, r.detail)
+	}
+	if failed > 0 {
+		fmt.Printf("\n%d of %d checks failed\n", failed, len(results)-skipped)
+		return exitFailure
+	}
+	fmt.Printf("\nAll %d checks passed\n", len(results)-skipped)
+	return exitOK
+}
+
+// doctorChecks runs the checks of the Kratos APIs, and of the flows Kratos starts
+func doctorChecks(ctx context.Context, opt *options.Options) []doctorResult {
+	var results []doctorResult
+	apis := []struct {
+		name string
+		init func(*options.Options) (*kratos.APIClient, error)
+	}{
+		{"kratos public API", api_client.InitPublicClient},
+		{"kratos admin API", api_client.InitAdminClient},
+	}
+	var public *kratos.APIClient
+	for _, api := range apis {
+		client, err := api.init(opt)
+		if err != nil {
+			results = append(results, doctorResult{name: api.name, detail: fmt.Sprintf("can't create client: %v", err)})
+			continue
+		}
+		r := checkKratosReady(ctx, api.name, client)
+		results = append(results, r)
+		if r.ok && api.name == "kratos public API" {
+			public = client
+		}
+	}
+	if public == nil {
+		// Without the public API the flows can't be checked
+		return results
+	}
+
+	// Flows are started without following redirects, to see where Kratos sends the browser
+	hc := *public.GetConfig().HTTPClient
+	hc.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	for _, f := range doctorFlows {
+		if f.browser {
+			results = append(results, checkFlowUIURL(ctx, opt, &hc, f))
+		}
+	}
+	results = append(results, checkReturnTo(ctx, opt, &hc))
+	results = append(results, checkCSRFCookieDomain(ctx, opt, &hc))
+	results = append(results, checkLoginMethods(ctx, public))
+	return results
+}
+
+// checkKratosReady checks that a Kratos API is ready, and reports its version
+func checkKratosReady(ctx context.Context, name string, client *kratos.APIClient) doctorResult {
+	if _, rawResp, err := client.MetadataApi.IsReady(ctx).Execute(); err != nil {
+		if rawResp != nil {
+			return doctorResult{name: name, detail: fmt.Sprintf("not ready, status %d: %v", rawResp.StatusCode, err)}
+		}
+		return doctorResult{name: name, detail: fmt.Sprintf("can't be reached: %v", err)}
+	}
+	v, _, err := client.MetadataApi.GetVersion(ctx).Execute()
+	if err != nil {
+		return doctorResult{name: name, ok: true, detail: "ready, version unknown"}
+	}
+	return doctorResult{name: name, ok: true, detail: fmt.Sprintf("ready, version %s", v.GetVersion())}
+}
+
+// startBrowserFlow starts a browser flow on the public API, returning the response without following its redirect
+func startBrowserFlow(ctx context.Context, opt *options.Options, hc *http.Client, flow string, returnTo string) (*http.Response, error) {
+	u := options.NewURLBuilder(opt.KratosPublicURL).JoinPath("self-service", flow, "browser")
+	if returnTo != "" {
+		u = u.ReturnTo(returnTo)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+// checkFlowUIURL checks that Kratos sends the browser to this UI's page when the flow starts
+func checkFlowUIURL(ctx context.Context, opt *options.Options, hc *http.Client, f doctorFlow) doctorResult {
+	name := fmt.Sprintf("%s flow ui_url", f.name)
+	resp, err := startBrowserFlow(ctx, opt, hc, f.name, "")
+	if err != nil {
+		return doctorResult{name: name, detail: fmt.Sprintf("can't start the flow: %v", err)}
+	}
+	loc, err := resp.Location()
+	if err != nil {
+		return doctorResult{name: name, detail: fmt.Sprintf("starting the flow returned status %d without a redirect", resp.StatusCode)}
+	}
+	want := opt.App().JoinPath(f.route)
+	if isAppRoute(opt, loc, "/error") && f.route != "/error" {
+		return doctorResult{name: name, detail: fmt.Sprintf("Kratos sent the browser to the error page %s, is the %s flow enabled in Kratos?", loc, f.name)}
+	}
+	if !isAppRoute(opt, loc, f.route) {
+		return doctorResult{name: name, detail: fmt.Sprintf("Kratos sends the browser to %s, but this UI serves the page at %s, set selfservice.flows.%s.ui_url to it", withoutQuery(loc), want, f.name)}
+	}
+	return doctorResult{name: name, ok: true, detail: withoutQuery(loc)}
+}
+
+// checkReturnTo checks that Kratos accepts this UI as a URL to return to after a flow
+func checkReturnTo(ctx context.Context, opt *options.Options, hc *http.Client) doctorResult {
+	name := "allowed return URLs"
+	app := opt.App().JoinPath("/")
+	if !app.URL().IsAbs() {
+		return doctorResult{name: name, skip: true, detail: fmt.Sprintf("'base-url' %s is relative, set an absolute URL to check it", app)}
+	}
+	resp, err := startBrowserFlow(ctx, opt, hc, "login", app.String())
+	if err != nil {
+		return doctorResult{name: name, detail: fmt.Sprintf("can't start the login flow: %v", err)}
+	}
+	loc, err := resp.Location()
+	if err != nil || !isAppRoute(opt, loc, "/login") {
+		return doctorResult{name: name, detail: fmt.Sprintf("Kratos refused return_to=%s, add it to selfservice.allowed_return_urls", app)}
+	}
+	return doctorResult{name: name, ok: true, detail: fmt.Sprintf("Kratos accepts return_to=%s", app)}
+}
+
+// checkCSRFCookieDomain checks that the cookies Kratos sets in the browser are sent to this UI too
+func checkCSRFCookieDomain(ctx context.Context, opt *options.Options, hc *http.Client) doctorResult {
+	name := "cookie domain"
+	resp, err := startBrowserFlow(ctx, opt, hc, "login", "")
+	if err != nil {
+		return doctorResult{name: name, detail: fmt.Sprintf("can't start the login flow: %v", err)}
+	}
+	for _, c := range resp.Cookies() {
+		if strings.HasPrefix(c.Name, "csrf_token") {
+			return checkCookieShared(opt, c.Domain)
+		}
+	}
+	return doctorResult{name: name, skip: true, detail: "Kratos didn't set a CSRF cookie when starting the login flow"}
+}
+
+// checkCookieShared checks that a cookie Kratos sets for domain, or host only if domain is empty,
+// reaches this UI as well as Kratos
+func checkCookieShared(opt *options.Options, domain string) doctorResult {
+	name := "cookie domain"
+	app := opt.App().URL()
+	if !app.IsAbs() {
+		return doctorResult{name: name, skip: true, detail: fmt.Sprintf("'base-url' %s is relative, set an absolute URL to check it", app)}
+	}
+	appHost, kratosHost := app.Hostname(), opt.KratosBrowserURL.Hostname()
+	if domain == "" {
+		if appHost != kratosHost {
+			return doctorResult{name: name, detail: fmt.Sprintf("Kratos' cookies are only sent to %s but this UI is on %s, set cookies.domain to a domain both are in", kratosHost, appHost)}
+		}
+		return doctorResult{name: name, ok: true, detail: fmt.Sprintf("this UI and Kratos share the host %s", appHost)}
+	}
+	for _, h := range []string{appHost, kratosHost} {
+		if !domainMatch(h, domain) {
+			return doctorResult{name: name, detail: fmt.Sprintf("Kratos' cookies are set for %s, which doesn't include %s", domain, h)}
+		}
+	}
+	return doctorResult{name: name, ok: true, detail: fmt.Sprintf("cookies for %s reach this UI and Kratos", domain)}
+}
+
+// checkLoginMethods checks that users can sign in with a method this UI supports
+func checkLoginMethods(ctx context.Context, public *kratos.APIClient) doctorResult {
+	name := "login methods"
+	flow, _, err := public.V0alpha2Api.InitializeSelfServiceLoginFlowWithoutBrowser(ctx).Execute()
+	if err != nil {
+		return doctorResult{name: name, detail: fmt.Sprintf("can't start a login flow: %v", err)}
+	}
+	seen := make(map[string]bool)
+	for _, n := range flow.Ui.Nodes {
+		if n.Group != "default" {
+			seen[n.Group] = true
+		}
+	}
+	return checkMethods(name, seen)
+}
+
+// checkMethods checks that the enabled methods are supported, and some can be used to sign in
+func checkMethods(name string, enabled map[string]bool) doctorResult {
+	var methods, unsupported []string
+	for m := range enabled {
+		methods = append(methods, m)
+		if !supportedMethods[m] {
+			unsupported = append(unsupported, m)
+		}
+	}
+	sort.Strings(methods)
+	sort.Strings(unsupported)
+	if len(unsupported) > 0 {
+		return doctorResult{name: name, detail: fmt.Sprintf("this UI has no pages for %s, disable them in selfservice.methods", strings.Join(unsupported, ", "))}
+	}
+	if !enabled["password"] && !enabled["oidc"] && !enabled["webauthn"] {
+		return doctorResult{name: name, detail: "users can't sign in, enable password, oidc or webauthn in selfservice.methods"}
+	}
+	return doctorResult{name: name, ok: true, detail: strings.Join(methods, ", ")}
+}
+
+// isAppRoute reports if u is the page of this UI at route. If the base URL is relative only the path is compared.
+func isAppRoute(opt *options.Options, u *url.URL, route string) bool {
+	want := opt.App().JoinPath(route).URL()
+	if want.IsAbs() && (u.Scheme != want.Scheme || u.Host != want.Host) {
+		return false
+	}
+	return strings.TrimSuffix(u.Path, "/") == strings.TrimSuffix(want.Path, "/")
+}
+
+// withoutQuery returns u without its query, which holds the flow id
+func withoutQuery(u *url.URL) string {
+	c := *u
+	c.RawQuery = ""
+	return c.String()
+}
+
+// domainMatch reports if cookies for domain are sent to host
+func domainMatch(host, domain string) bool {
+	domain = strings.ToLower(strings.TrimPrefix(domain, "."))
+	host = strings.ToLower(host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/identities"
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+)
+
+// runIdentitiesImport is the identities import command
+func runIdentitiesImport(name string, args []string) int {
+	fs := newFlagSet(name, "[flags] [file]", "Create identities through the Kratos admin API from a file, or stdin, of JSON lines or CSV records.\n"+
+		"Records are validated against their identity schema first, those without a schema_id use 'identity-schema-id'.\n"+
+		"Records that fail are listed in the error report, and the command exits with 1.")
+	format := fs.String("format", "", "The format of the file, 'jsonl' or 'csv'. Defaults to csv if the file name ends with '.csv', otherwise jsonl")
+	concurrency := fs.Int("concurrency", identities.DefaultConcurrency, "The number of identities created at once")
+	dryRun := fs.Bool("dry-run", false, "Validate the records without creating any identities")
+	progressPath := fs.String("progress", "", "File recording the records imported. Records already in it are skipped, so an import that stopped can be run again")
+	reportPath := fs.String("report", "", "File the CSV error report is written to. Defaults to stderr")
+	opt, code, ok := parseOptionsWith(fs, args)
+	if ok {
+		code, ok = checkArgs(fs, 1)
+	}
+	if !ok {
+		return code
+	}
+	if err := validateConfig(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if *format == "" {
+		*format = identities.FormatOf(fs.Arg(0))
+	}
+
+	in, err := openInput(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	defer in.Close()
+	records, err := identities.NewReader(in, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+
+	reportOut := io.Writer(os.Stderr)
+	if *reportPath != "" {
+		f, err := os.Create(*reportPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		defer f.Close()
+		reportOut = f
+	}
+	report := identities.NewErrorReport(reportOut)
+
+	importer := identities.Importer{
+		DefaultSchemaID: opt.IdentitySchemaID,
+		Concurrency:     *concurrency,
+		DryRun:          *dryRun,
+	}
+	if *progressPath != "" && !*dryRun {
+		progress, err := identities.OpenProgress(*progressPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening the progress file: %v\n", err)
+			return exitUsage
+		}
+		defer progress.Close()
+		importer.Progress = progress
+	}
+	if importer.Create, importer.Schemas, err = importClients(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+
+	// Interrupting stops the import after the identities being created, the progress then records all those created
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	summary, err := importer.Import(ctx, records, report.Add)
+	if flushErr := report.Flush(); flushErr != nil && err == nil {
+		err = flushErr
+	}
+	if *dryRun {
+		fmt.Fprintf(os.Stderr, "Dry run: %d valid, %d invalid\n", summary.Valid, summary.Failed)
+	} else {
+		fmt.Fprintf(os.Stderr, "Created %d identities, %d failed, %d skipped as already imported\n", summary.Created, summary.Failed, summary.Skipped)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	if summary.Failed > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// importClients returns the functions an import uses to create identities, and load their schemas
+func importClients(opt *options.Options) (identities.CreateFunc, identities.SchemaFunc, error) {
+	if _, err := api_client.InitPublicClient(opt); err != nil {
+		return nil, nil, err
+	}
+	admin, err := api_client.InitAdminClient(opt)
+	if err != nil {
+		return nil, nil, err
+	}
+	schemas := schema.NewLoader(api_client.FetchIdentitySchema, identitySchemaCacheTTL)
+	return identities.KratosCreate(admin), schemas.Get, nil
+}
+
+// runIdentitiesExport is the identities export command
+func runIdentitiesExport(name string, args []string) int {
+	fs := newFlagSet(name, "[flags]", "Write all identities from the Kratos admin API as JSON lines or CSV records, with their traits and verified\n"+
+		"addresses. Passwords are not exported.")
+	format := fs.String("format", "", "The format to write, 'jsonl' or 'csv'. Defaults to csv if the 'out' file name ends with '.csv', otherwise jsonl")
+	out := fs.String("out", "", "File to write. Defaults to stdout")
+	traits := fs.String("traits", "", "Comma separated dotted paths of the traits to export, e.g. 'email,name.first'. Defaults to all traits,\n"+
+		"or for CSV, the traits in the 'identity-schema-id' schema")
+	opt, code, ok := parseOptionsWith(fs, args)
+	if ok {
+		code, ok = checkArgs(fs, 0)
+	}
+	if !ok {
+		return code
+	}
+	if err := validateConfig(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	if *format == "" {
+		*format = identities.FormatOf(*out)
+	}
+
+	exporter := identities.Exporter{Traits: identities.ParseTraits(*traits)}
+	if _, err := api_client.InitPublicClient(opt); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	admin, err := api_client.InitAdminClient(opt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitFailure
+	}
+	exporter.List = identities.KratosList(admin)
+
+	ctx := context.Background()
+	if *format == identities.CSV && len(exporter.Traits) == 0 {
+		raw, err := api_client.FetchIdentitySchema(ctx, opt.IdentitySchemaID)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error fetching identity schema '%s': %v\n", opt.IdentitySchemaID, err)
+			return exitFailure
+		}
+		s, err := schema.Parse(opt.IdentitySchemaID, raw)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitFailure
+		}
+		exporter.Traits = identities.SchemaTraits(s)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitUsage
+		}
+		defer f.Close()
+		w = f
+	}
+	records, err := identities.NewWriter(w, *format, exporter.Traits)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	n, err := exporter.Export(api_client.WithTimeout(ctx, opt.KratosListTimeout), records)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error after exporting %d identities: %v\n", n, err)
+		return exitFailure
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d identities\n", n)
+	return exitOK
+}
+
+// openInput opens the named file, or stdin if the name is empty or "-"
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "" || name == "-" {
+		return io.NopCloser(os.Stdin), nil
+	}
+	return os.Open(name)
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+)
+
+// runKeysGenerate is the keys generate command
+func runKeysGenerate(name string, args []string) int {
+	fs := newFlagSet(name, "", "Print a new pair of authentication and encryption keys, suitable for the 'cookie-store-key-pairs' flag.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	fmt.Println(options.EncodeCookieStoreKeyPairs(options.GenerateCookieStoreKeyPair()))
+	return exitOK
+}
+
+// runKeysRotate is the keys rotate command
+func runKeysRotate(name string, args []string) int {
+	fs := newFlagSet(name, "[flags]", "Print the 'cookie-store-key-pairs' value with a new pair of keys first, followed by the current pairs.\n"+
+		"Cookies are written with the new pair, those written with the current pairs can still be read.")
+	keep := fs.Int("keep", 1, "The number of current key pairs to keep")
+	opt, code, ok := parseOptionsWith(fs, args)
+	if ok {
+		code, ok = checkArgs(fs, 0)
+	}
+	if !ok {
+		return code
+	}
+	if len(opt.CookieStoreKeyPairs) == 0 {
+		fmt.Fprintln(os.Stderr, "Error: no keys to rotate, provide 'cookie-store-key-pairs' or use the 'keys generate' command")
+		return exitUsage
+	}
+	keys, err := options.RotateCookieStoreKeyPairs(opt.CookieStoreKeyPairs, *keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return exitUsage
+	}
+	fmt.Println(options.EncodeCookieStoreKeyPairs(keys))
+	return exitOK
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// version is set when building a release, with -ldflags "-X main.version=v1.2.3"
+var version = "dev"
+
+// runVersion is the version command
+func runVersion(name string, args []string) int {
+	fs := newFlagSet(name, "", "Print the version of this app, and the Go version it was built with.")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	v := version
+	if info, ok := debug.ReadBuildInfo(); ok && v == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		v = info.Main.Version
+	}
+	fmt.Printf("%s %s %s %s/%s\n", appName, v, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	return exitOK
+}
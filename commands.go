@@ -0,0 +1,171 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+)
+
+// appName is the name of the binary in help output
+const appName = "kratos-selfservice-ui-go"
+
+// Exit codes, shared by all commands
+const (
+	// exitOK is returned when the command succeeds, or help was asked for
+	exitOK = 0
+
+	// exitFailure is returned when the command fails, or finds problems
+	exitFailure = 1
+
+	// exitUsage is returned when the command line or configuration is invalid
+	exitUsage = 2
+)
+
+// command is a subcommand of the binary. It either runs, or has subcommands of its own.
+type command struct {
+	name    string
+	summary string
+
+	// run runs the command with its args, name is the full command name e.g. "kratos-selfservice-ui-go keys generate".
+	// It returns the exit code.
+	run func(name string, args []string) int
+
+	subcommands []*command
+}
+
+// commands returns the commands of the binary
+func commands() []*command {
+	return []*command{
+		{name: "serve", summary: "Serve the self service UI, the default if no command is given", run: runServe},
+		{name: "keys", summary: "Manage the cookie store keys", subcommands: []*command{
+			{name: "generate", summary: "Print a new pair of cookie store keys", run: runKeysGenerate},
+			{name: "rotate", summary: "Print the cookie store keys with a new pair added", run: runKeysRotate},
+		}},
+		{name: "config", summary: "Check the configuration", subcommands: []*command{
+			{name: "validate", summary: "Validate the configuration from flags and envars", run: runConfigValidate},
+			{name: "print", summary: "Print the configuration from flags and envars, with secrets redacted", run: runConfigPrint},
+		}},
+		{name: "doctor", summary: "Check that Kratos can be reached and is ready", run: runDoctor},
+		{name: "identities", summary: "Import and export Kratos identities", subcommands: []*command{
+			{name: "import", summary: "Create identities from JSON lines or CSV", run: runIdentitiesImport},
+			{name: "export", summary: "Write all identities as JSON lines or CSV", run: runIdentitiesExport},
+		}},
+		{name: "version", summary: "Print the version", run: runVersion},
+	}
+}
+
+// run runs the command named by args, and returns the exit code
+func run(args []string) int {
+	// Without a command the app is served, as it was before commands were added
+	if len(args) == 0 || (strings.HasPrefix(args[0], "-") && !isHelp(args[0])) {
+		return runServe(appName+" serve", args)
+	}
+	return dispatch(appName, commands(), args)
+}
+
+// dispatch runs the command in cmds named by args[0]
+func dispatch(name string, cmds []*command, args []string) int {
+	if len(args) == 0 {
+		printCommands(os.Stderr, name, cmds)
+		return exitUsage
+	}
+	if isHelp(args[0]) {
+		printCommands(os.Stdout, name, cmds)
+		return exitOK
+	}
+	for _, c := range cmds {
+		if c.name != args[0] {
+			continue
+		}
+		if c.subcommands != nil {
+			return dispatch(name+" "+c.name, c.subcommands, args[1:])
+		}
+		return c.run(name+" "+c.name, args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", args[0])
+	printCommands(os.Stderr, name, cmds)
+	return exitUsage
+}
+
+// printCommands writes the usage of a command with subcommands
+func printCommands(w io.Writer, name string, cmds []*command) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", name)
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %-12s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for help with a command.\n", name)
+}
+
+// isHelp reports if arg asks for help
+func isHelp(arg string) bool {
+	return arg == "help" || arg == "-h" || arg == "-help" || arg == "--help"
+}
+
+// newFlagSet returns the flag set of a command. Errors and usage are written to stderr.
+func newFlagSet(name, synopsis, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s\n\n%s\n", name, synopsis, description)
+		hasFlags := false
+		fs.VisitAll(func(*flag.Flag) { hasFlags = true })
+		if hasFlags {
+			fmt.Fprintf(fs.Output(), "\nFlags:\n")
+			fs.PrintDefaults()
+		}
+	}
+	return fs
+}
+
+// parseFlags parses args, which must only be flags, returning false and the exit code if the command should stop
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	if code, ok := flagsResult(fs.Parse(args)); !ok {
+		return code, false
+	}
+	return checkArgs(fs, 0)
+}
+
+// parseOptions parses args with the options' flags, which must only be flags, returning false and the exit code
+// if the command should stop
+func parseOptions(name string, args []string, description string) (*options.Options, *flag.FlagSet, int, bool) {
+	fs := newFlagSet(name, "[flags]", description)
+	opt, code, ok := parseOptionsWith(fs, args)
+	if ok {
+		code, ok = checkArgs(fs, 0)
+	}
+	return opt, fs, code, ok
+}
+
+// checkArgs reports if more than max arguments follow the flags, returning false and the exit code if so
+func checkArgs(fs *flag.FlagSet, max int) (int, bool) {
+	if fs.NArg() <= max {
+		return exitOK, true
+	}
+	fmt.Fprintf(fs.Output(), "Unexpected argument '%s'\n\n", fs.Arg(max))
+	fs.Usage()
+	return exitUsage, false
+}
+
+// parseOptionsWith parses args with the options' flags added to fs, which may define flags of its own. Commands
+// check the arguments after the flags themselves, see checkArgs.
+func parseOptionsWith(fs *flag.FlagSet, args []string) (*options.Options, int, bool) {
+	opt := options.NewOptions()
+	code, ok := flagsResult(opt.Parse(fs, args))
+	return opt, code, ok
+}
+
+// flagsResult returns the exit code for an error parsing flags, which has already been reported
+func flagsResult(err error) (int, bool) {
+	switch {
+	case err == nil:
+		return exitOK, true
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK, false
+	default:
+		return exitUsage, false
+	}
+}
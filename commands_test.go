@@ -0,0 +1,81 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runCaptured runs the command line args, returning the exit code and what was written to stdout and stderr
+func runCaptured(t *testing.T, args ...string) (int, string, string) {
+	capture := func(f **os.File) func() string {
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		orig := *f
+		*f = w
+		out := make(chan string)
+		go func() {
+			b, _ := ioutil.ReadAll(r)
+			out <- string(b)
+		}()
+		return func() string {
+			*f = orig
+			w.Close()
+			return <-out
+		}
+	}
+	stdout, stderr := capture(&os.Stdout), capture(&os.Stderr)
+	code := run(args)
+	return code, stdout(), stderr()
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name   string
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{"leading flags serve", []string{"-no-such-flag"}, exitUsage, "", "Usage: kratos-selfservice-ui-go serve [flags]"},
+		{"serve rejects arguments", []string{"serve", "-port", "4455", "extra"}, exitUsage, "", "Unexpected argument 'extra'"},
+		{"unknown command", []string{"bogus"}, exitUsage, "", "Unknown command 'bogus'"},
+		{"help", []string{"-h"}, exitOK, "Commands:\n  serve", ""},
+		{"help command", []string{"help"}, exitOK, "Commands:\n  serve", ""},
+		{"nested help", []string{"keys", "-h"}, exitOK, "Usage: kratos-selfservice-ui-go keys <command>", ""},
+		{"command help", []string{"keys", "generate", "-h"}, exitOK, "", "Usage: kratos-selfservice-ui-go keys generate"},
+		{"missing subcommand", []string{"keys"}, exitUsage, "", "Usage: kratos-selfservice-ui-go keys <command>"},
+		{"unknown subcommand", []string{"keys", "bogus"}, exitUsage, "", "Unknown command 'bogus'"},
+		{"subcommand rejects arguments", []string{"keys", "generate", "extra"}, exitUsage, "", "Unexpected argument 'extra'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, stdout, stderr := runCaptured(t, tt.args...)
+			assert.Equal(t, tt.code, code)
+			if tt.stdout != "" {
+				assert.Contains(t, stdout, tt.stdout)
+			}
+			if tt.stderr != "" {
+				assert.Contains(t, stderr, tt.stderr)
+			}
+		})
+	}
+}
+
+func TestRunServeFailure(t *testing.T) {
+	// Errors starting up exit with a failure, rather than stopping the process
+	code, _, _ := runCaptured(t, "serve",
+		"-kratos-admin-url", "http://admin",
+		"-kratos-public-url", "http://public",
+		"-kratos-browser-url", "http://browser",
+		"-base-url", "http://app",
+		"-cookie-store-key-pairs", options.EncodeCookieStoreKeyPairs(options.GenerateCookieStoreKeyPair()),
+		"-audit-file", filepath.Join(t.TempDir(), "missing", "audit.jsonl"),
+	)
+	assert.Equal(t, exitFailure, code)
+}
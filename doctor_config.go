@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"gopkg.in/yaml.v3"
+)
+
+// kratosConfig is the part of the Kratos config file the doctor checks. Kratos' own
+// defaults and envar overrides are not applied.
+type kratosConfig struct {
+	Serve struct {
+		Public struct {
+			BaseURL string `yaml:"base_url"`
+		} `yaml:"public"`
+	} `yaml:"serve"`
+
+	Selfservice struct {
+		DefaultBrowserReturnURL string `yaml:"default_browser_return_url"`
+
+		// AllowedReturnURLs was called WhitelistedReturnURLs before Kratos v0.8
+		AllowedReturnURLs     []string `yaml:"allowed_return_urls"`
+		WhitelistedReturnURLs []string `yaml:"whitelisted_return_urls"`
+
+		Methods map[string]struct {
+			Enabled bool `yaml:"enabled"`
+		} `yaml:"methods"`
+
+		Flows map[string]struct {
+			UIURL   string `yaml:"ui_url"`
+			Enabled *bool  `yaml:"enabled"`
+		} `yaml:"flows"`
+	} `yaml:"selfservice"`
+
+	Cookies struct {
+		Domain string `yaml:"domain"`
+	} `yaml:"cookies"`
+
+	Session struct {
+		Cookie struct {
+			Name   string `yaml:"name"`
+			Domain string `yaml:"domain"`
+		} `yaml:"cookie"`
+	} `yaml:"session"`
+}
+
+// loadKratosConfig reads a Kratos config file, YAML or JSON
+func loadKratosConfig(path string) (*kratosConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading 'kratos-config': %w", err)
+	}
+	var cfg kratosConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing 'kratos-config' file '%s': %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// kratosConfigChecks cross-checks the Kratos config file with this UI's options
+func kratosConfigChecks(opt *options.Options, cfg *kratosConfig) []doctorResult {
+	var results []doctorResult
+	for _, f := range doctorFlows {
+		results = append(results, checkConfigUIURL(opt, cfg, f))
+	}
+	results = append(results,
+		checkConfigPublicURL(opt, cfg),
+		checkConfigReturnURLs(opt, cfg),
+		checkConfigCookies(opt, cfg),
+		checkConfigMethods(cfg),
+	)
+	return results
+}
+
+// checkConfigUIURL checks that a flow's ui_url is this UI's page
+func checkConfigUIURL(opt *options.Options, cfg *kratosConfig, f doctorFlow) doctorResult {
+	name := fmt.Sprintf("kratos config selfservice.flows.%s.ui_url", f.name)
+	flow := cfg.Selfservice.Flows[f.name]
+	if flow.Enabled != nil && !*flow.Enabled {
+		return doctorResult{name: name, skip: true, detail: "the flow is disabled"}
+	}
+	want := opt.App().JoinPath(f.route)
+	if flow.UIURL == "" {
+		return doctorResult{name: name, detail: fmt.Sprintf("not set, set it to %s", want)}
+	}
+	u, err := url.Parse(flow.UIURL)
+	if err != nil {
+		return doctorResult{name: name, detail: fmt.Sprintf("'%s' is not a URL: %v", flow.UIURL, err)}
+	}
+	if !isAppRoute(opt, u, f.route) {
+		return doctorResult{name: name, detail: fmt.Sprintf("is %s, but this UI serves the page at %s", flow.UIURL, want)}
+	}
+	return doctorResult{name: name, ok: true, detail: flow.UIURL}
+}
+
+// checkConfigPublicURL checks that the browser is sent to the Kratos public URL Kratos expects
+func checkConfigPublicURL(opt *options.Options, cfg *kratosConfig) doctorResult {
+	name := "kratos config serve.public.base_url"
+	if cfg.Serve.Public.BaseURL == "" {
+		return doctorResult{name: name, skip: true, detail: "not set, Kratos guesses it from each request"}
+	}
+	if strings.TrimSuffix(cfg.Serve.Public.BaseURL, "/") != strings.TrimSuffix(opt.KratosBrowserURL.String(), "/") {
+		return doctorResult{name: name, detail: fmt.Sprintf("is %s, but 'kratos-browser-url' is %s, they must be the same", cfg.Serve.Public.BaseURL, opt.KratosBrowserURL)}
+	}
+	return doctorResult{name: name, ok: true, detail: cfg.Serve.Public.BaseURL}
+}
+
+// checkConfigReturnURLs checks that Kratos may return the browser to this UI
+func checkConfigReturnURLs(opt *options.Options, cfg *kratosConfig) doctorResult {
+	name := "kratos config selfservice.allowed_return_urls"
+	app := opt.App().JoinPath("/").URL()
+	if !app.IsAbs() {
+		return doctorResult{name: name, skip: true, detail: fmt.Sprintf("'base-url' %s is relative, set an absolute URL to check it", app)}
+	}
+	allowed := append(cfg.Selfservice.AllowedReturnURLs, cfg.Selfservice.WhitelistedReturnURLs...)
+	for _, a := range allowed {
+		u, err := url.Parse(a)
+		if err != nil {
+			continue
+		}
+		// Kratos allows a return URL with the same scheme and host, under the allowed path
+		if u.Scheme == app.Scheme && u.Host == app.Host && strings.HasPrefix(app.Path, u.Path) {
+			return doctorResult{name: name, ok: true, detail: fmt.Sprintf("%s allows %s", a, app)}
+		}
+	}
+	return doctorResult{name: name, detail: fmt.Sprintf("none allow %s, add it so users return to this UI", app)}
+}
+
+// checkConfigCookies checks the session cookie's name, and that Kratos' cookies reach this UI
+func checkConfigCookies(opt *options.Options, cfg *kratosConfig) doctorResult {
+	sessionCookie := cfg.Session.Cookie.Name
+	if sessionCookie == "" {
+		sessionCookie = "ory_kratos_session"
+	}
+	if sessionCookie != opt.KratosSessionCookie {
+		return doctorResult{name: "kratos config session.cookie.name", detail: fmt.Sprintf("is %s, but 'kratos-session-cookie' is %s", sessionCookie, opt.KratosSessionCookie)}
+	}
+	domain := cfg.Session.Cookie.Domain
+	if domain == "" {
+		domain = cfg.Cookies.Domain
+	}
+	r := checkCookieShared(opt, domain)
+	r.name = "kratos config cookies.domain"
+	return r
+}
+
+// checkConfigMethods checks the enabled self service methods
+func checkConfigMethods(cfg *kratosConfig) doctorResult {
+	enabled := make(map[string]bool)
+	for m, c := range cfg.Selfservice.Methods {
+		if c.Enabled {
+			enabled[m] = true
+		}
+	}
+	return checkMethods("kratos config selfservice.methods", enabled)
+}
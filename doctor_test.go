@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startDoctorKratos starts a fake Kratos public API, which sends browser flows to uiURL
+func startDoctorKratos(t *testing.T, uiURL string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health/ready", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"ok"}`))
+	})
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"version":"v0.10.1"}`))
+	})
+	for _, flow := range []string{"login", "registration", "recovery", "verification"} {
+		flow := flow
+		mux.HandleFunc("/self-service/"+flow+"/browser", func(w http.ResponseWriter, r *http.Request) {
+			if flow == "verification" {
+				// Disabled
+				http.Redirect(w, r, uiURL+"/error?id=1", http.StatusSeeOther)
+				return
+			}
+			if r.URL.Query().Get("return_to") == "https://evil.example.com/" {
+				http.Redirect(w, r, uiURL+"/error?id=2", http.StatusSeeOther)
+				return
+			}
+			http.SetCookie(w, &http.Cookie{Name: "csrf_token_1", Value: "x", Domain: "example.com"})
+			http.Redirect(w, r, uiURL+"/"+flow+"?flow=1", http.StatusSeeOther)
+		})
+	}
+	mux.HandleFunc("/self-service/login/api", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":"1","type":"api","expires_at":"2030-01-01T00:00:00Z","issued_at":"2020-01-01T00:00:00Z","request_url":"http://kratos",
+			"ui":{"action":"http://kratos","method":"POST","nodes":[
+				{"type":"input","group":"default","attributes":{"node_type":"input","name":"csrf_token","type":"hidden","disabled":false},"messages":[],"meta":{}},
+				{"type":"input","group":"password","attributes":{"node_type":"input","name":"password","type":"password","disabled":false},"messages":[],"meta":{}}
+			]}}`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// doctorOptions returns the options of an app at baseURL, using the fake Kratos
+func doctorOptions(t *testing.T, kratosURL, baseURL string) *options.Options {
+	opt, code, ok := parseOptionsWith(newFlagSet("doctor", "", ""), []string{
+		"--kratos-public-url", kratosURL,
+		"--kratos-admin-url", kratosURL,
+		"--kratos-browser-url", "https://auth.example.com/",
+		"--base-url", baseURL,
+		"--cookie-store-key-pairs", "a2V5",
+	})
+	require.True(t, ok, "exit code %d", code)
+	return opt
+}
+
+func resultsByName(results []doctorResult) map[string]doctorResult {
+	byName := make(map[string]doctorResult)
+	for _, r := range results {
+		byName[r.name] = r
+	}
+	return byName
+}
+
+func TestDoctorChecks(t *testing.T) {
+	kratos := startDoctorKratos(t, "https://app.example.com/account")
+	opt := doctorOptions(t, kratos.URL, "https://app.example.com/account/")
+
+	results := resultsByName(doctorChecks(context.Background(), opt))
+	for _, name := range []string{"kratos public API", "kratos admin API", "login flow ui_url", "registration flow ui_url", "recovery flow ui_url", "allowed return URLs", "cookie domain", "login methods"} {
+		assert.True(t, results[name].ok, "%s: %s", name, results[name].detail)
+	}
+	assert.False(t, results["verification flow ui_url"].ok)
+	assert.Contains(t, results["verification flow ui_url"].detail, "is the verification flow enabled in Kratos?")
+	assert.Equal(t, "password", results["login methods"].detail)
+
+	// The UI served somewhere Kratos doesn't send the browser
+	opt = doctorOptions(t, kratos.URL, "https://app.example.com/")
+	results = resultsByName(doctorChecks(context.Background(), opt))
+	assert.False(t, results["login flow ui_url"].ok)
+	assert.Contains(t, results["login flow ui_url"].detail, "set selfservice.flows.login.ui_url to it")
+}
+
+func TestKratosConfigChecks(t *testing.T) {
+	cfg, err := loadKratosConfig("contrib/quickstart/kratos/email-password/kratos.yml")
+	require.NoError(t, err)
+
+	// The quickstart's UI at http://127.0.0.1/
+	opt := doctorOptions(t, "http://kratos:4433/", "http://127.0.0.1/")
+	opt.KratosBrowserURL = mustParseURL(t, "http://127.0.0.1/")
+	results := resultsByName(kratosConfigChecks(opt, cfg))
+
+	login := results["kratos config selfservice.flows.login.ui_url"]
+	assert.False(t, login.ok)
+	assert.Equal(t, "is http://127.0.0.1/auth/login, but this UI serves the page at http://127.0.0.1/login", login.detail)
+	assert.True(t, results["kratos config selfservice.flows.error.ui_url"].ok)
+	for _, name := range []string{"kratos config serve.public.base_url", "kratos config selfservice.allowed_return_urls", "kratos config cookies.domain", "kratos config selfservice.methods"} {
+		assert.True(t, results[name].ok, "%s: %s", name, results[name].detail)
+	}
+
+	// Kratos' cookies aren't sent to a UI on another host
+	opt.BaseURL = mustParseURL(t, "http://ui.example.com/")
+	results = resultsByName(kratosConfigChecks(opt, cfg))
+	assert.False(t, results["kratos config cookies.domain"].ok)
+	assert.False(t, results["kratos config selfservice.allowed_return_urls"].ok)
+}
+
+func TestDomainMatch(t *testing.T) {
+	assert.True(t, domainMatch("example.com", "example.com"))
+	assert.True(t, domainMatch("auth.example.com", ".example.com"))
+	assert.False(t, domainMatch("badexample.com", "example.com"))
+	assert.False(t, domainMatch("example.com", "auth.example.com"))
+}
+
+func mustParseURL(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	return u
+}
@@ -9,6 +9,7 @@ require (
 	github.com/gorilla/mux v1.8.0
 	github.com/gorilla/securecookie v1.1.1
 	github.com/gorilla/sessions v1.2.1
+	github.com/jackc/pgx/v4 v4.17.2
 	github.com/kr/text v0.2.0 // indirect
 	github.com/niemeyer/pretty v0.0.0-20200227124842-a10e7caefd8e // indirect
 	github.com/ory/kratos-client-go v0.10.1
@@ -16,4 +17,6 @@ require (
 	github.com/tidwall/gjson v1.6.8
 	golang.org/x/net v0.0.0-20220822230855-b0a4917ee28c // indirect
 	gopkg.in/check.v1 v1.0.0-20200227125254-8fa46927fb4f // indirect
+	gopkg.in/yaml.v3 v3.0.1
+	modernc.org/sqlite v1.18.2
 )
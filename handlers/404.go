@@ -15,14 +15,10 @@ type PageNotFoundParams struct {
 	HomeURL string
 }
 
-// Login handler displays the login screen
+// PageNotFound handler displays the page not found screen, with a 404 status
 func (pp PageNotFoundParams) PageNotFound(w http.ResponseWriter, r *http.Request) {
-	dataMap := map[string]interface{}{
-		"homeURL": pp.HomeURL,
-		"message": "The requested page could not be found (404)",
-		"fs":      pp.FS,
-	}
-	if err := GetTemplate(errorPage).Render("layout", w, r, dataMap); err != nil {
-		TemplateErrorHandler(w, r, err)
-	}
+	page := classifyError(map[string]interface{}{"code": float64(http.StatusNotFound)})
+	page.Title = "Page not found"
+	page.Explanation = "The page you asked for doesn't exist. Check the address, or go back to the start."
+	KratosErrorParams{FS: pp.FS, HomeURL: pp.HomeURL}.render(w, r, page)
 }
@@ -5,8 +5,8 @@ import (
 	"fmt"
 	"html/template"
 	"log"
-	"reflect"
 	"strings"
+	"time"
 
 	"github.com/benbjohnson/hashfs"
 	kratos "github.com/ory/kratos-client-go"
@@ -36,10 +36,14 @@ var (
 	uiNodeInputHiddenTemplate string
 	//go:embed partials/ui_node_input_default.html
 	uiNodeInputDefaultTemplate string
+	//go:embed partials/ui_node_input_trait.html
+	uiNodeInputTraitTemplate string
 	//go:embed partials/ui_node_input_checkbox.html
 	uiNodeInputCheckboxTemplate string
 	//go:embed partials/ui_node_input_button.html
 	uiNodeInputButtonTemplate string
+	//go:embed partials/ui_node_input_oidc.html
+	uiNodeInputOidcTemplate string
 	//go:embed partials/ui_node_image.html
 	uiNodeImageTemplate string
 	//go:embed partials/ui_node_anchor.html
@@ -50,6 +54,18 @@ var (
 	uiScreenButtonTemplate string
 	//go:embed partials/fork_me.html
 	forkMeTemplate string
+	//go:embed partials/totp.html
+	totpTemplate string
+	//go:embed partials/lookup_secret.html
+	lookupSecretTemplate string
+	//go:embed partials/webauthn.html
+	webauthnTemplate string
+	//go:embed partials/oidc.html
+	oidcTemplate string
+	//go:embed partials/oidc_icon.html
+	oidcIconTemplate string
+	//go:embed partials/challenge.html
+	challengeTemplate string
 
 	// Template per page
 	//
@@ -65,8 +81,22 @@ var (
 	verificationTemplate string
 	//go:embed welcome.html
 	welcomeTemplate string
+	//go:embed dashboard.html
+	dashboardTemplate string
 	//go:embed error.html
 	errorTemplate string
+	//go:embed lookup_secrets_print.html
+	lookupSecretsPrintTemplate string
+	//go:embed audit.html
+	auditTemplate string
+	//go:embed identities.html
+	identitiesTemplate string
+	//go:embed invites.html
+	invitesTemplate string
+	//go:embed invitation.html
+	invitationTemplate string
+	//go:embed unavailable.html
+	unavailableTemplate string
 
 	emptyFuncMap         = template.FuncMap{}
 	emptyStmulusTemplate = `
@@ -85,7 +115,15 @@ const (
 	settingsPage     = TemplateName("settings")
 	verificationPage = TemplateName("verification")
 	welcomePage      = TemplateName("welcome")
+	dashboardPage    = TemplateName("dashboard")
 	errorPage        = TemplateName("error")
+	unavailablePage  = TemplateName("unavailable")
+
+	lookupSecretsPrintPage = TemplateName("lookup_secrets_print")
+	auditPage              = TemplateName("audit")
+	identitiesPage         = TemplateName("identities")
+	invitesPage            = TemplateName("invites")
+	invitationPage         = TemplateName("invitation")
 )
 
 // Register all the Templates during initialisation
@@ -106,13 +144,21 @@ func init() {
 		uiNodeScriptTemplate,
 		uiNodeInputHiddenTemplate,
 		uiNodeInputDefaultTemplate,
+		uiNodeInputTraitTemplate,
 		uiNodeInputCheckboxTemplate,
 		uiNodeInputButtonTemplate,
+		uiNodeInputOidcTemplate,
 		uiNodeImageTemplate,
 		uiNodeAnchorTemplate,
 		uiDocsButtonTemplate,
 		uiScreenButtonTemplate,
 		forkMeTemplate,
+		totpTemplate,
+		lookupSecretTemplate,
+		webauthnTemplate,
+		oidcTemplate,
+		oidcIconTemplate,
+		challengeTemplate,
 	}
 
 	// The templates and their associated functions to include etc
@@ -123,7 +169,14 @@ func init() {
 		{name: settingsPage, fmap: emptyFuncMap, templates: []string{settingsTemplate}},
 		{name: verificationPage, fmap: emptyFuncMap, templates: []string{verificationTemplate}},
 		{name: welcomePage, fmap: emptyFuncMap, templates: []string{welcomeTemplate}},
+		{name: dashboardPage, fmap: emptyFuncMap, templates: []string{dashboardTemplate}},
 		{name: errorPage, fmap: emptyFuncMap, templates: []string{errorTemplate}},
+		{name: unavailablePage, fmap: emptyFuncMap, templates: []string{unavailableTemplate}},
+		{name: lookupSecretsPrintPage, fmap: emptyFuncMap, templates: []string{lookupSecretsPrintTemplate}},
+		{name: auditPage, fmap: emptyFuncMap, templates: []string{auditTemplate}},
+		{name: identitiesPage, fmap: emptyFuncMap, templates: []string{identitiesTemplate}},
+		{name: invitesPage, fmap: emptyFuncMap, templates: []string{invitesTemplate}},
+		{name: invitationPage, fmap: emptyFuncMap, templates: []string{invitationTemplate}},
 	}
 	for _, t := range templates {
 		stimulusTemplate := emptyStmulusTemplate
@@ -164,11 +217,16 @@ func globalFuncMap() template.FuncMap {
 	return template.FuncMap{
 		// Functions for returning safe HTML elements (URL, HTML attributes, JavaScript)
 		// See https://pkg.go.dev/html/template#hdr-Contexts for more information
-		"safeURL": func(s *string) template.URL {
-			if s == nil {
-				return ""
+		"safeURL": func(s interface{}) template.URL {
+			switch v := s.(type) {
+			case string:
+				return template.URL(v)
+			case *string:
+				if v != nil {
+					return template.URL(*v)
+				}
 			}
-			return template.URL(*s)
+			return ""
 		},
 		"safeAttr": func(s *string) template.HTMLAttr {
 			if s == nil {
@@ -184,36 +242,64 @@ func globalFuncMap() template.FuncMap {
 		},
 
 		// Returns a hashed path of the asset being used
-		"assetPath": func(fs hashfs.FS, name string) string {
+		"assetPath": func(fs *hashfs.FS, name string) string {
 			if strings.HasPrefix(name, "/") {
 				log.Printf("assetPath: called with name '%s', should not start with '/'", name)
 			}
-			path := fs.HashName(name)
-			if strings.HasPrefix(path, "/") {
-				return path
+			return AppPath(fs.HashName(name))
+		},
+
+		// Returns the path of an app page, under the base path, e.g. {{appPath "dashboard"}}
+		"appPath": AppPath,
+
+		// Formats an optional timestamp for display
+		"formatTime": func(v interface{}) string {
+			var t time.Time
+			switch tv := v.(type) {
+			case time.Time:
+				t = tv
+			case *time.Time:
+				if tv != nil {
+					t = *tv
+				}
+			}
+			if t.IsZero() {
+				return ""
 			}
-			return fmt.Sprintf("/%s", path)
+			return t.Local().Format("2 Jan 2006 15:04 MST")
 		},
 
-		// Attempts to parse UI node text context into text secrets suitable for templates
-		// See the type textSecret above for field names
-		"getTextSecrets": func(node kratos.UiNode) []textSecret {
-			secrets := node.Attributes.UiNodeTextAttributes.Text.Context["secrets"]
-			v := reflect.ValueOf(secrets)
-			ts := []textSecret{}
-
-			if v.Kind() == reflect.Slice {
-				for i := 0; i < v.Len(); i++ {
-					m := v.Index(i).Interface().(map[string]interface{})
-					newTs := textSecret{}
-					newTs.marshal(m)
-					ts = append(ts, newTs)
+		// Returns the hidden input holding the CSRF token, for forms that post to this app.
+		// Pass the page's data, e.g. {{csrfField $}}, or the token itself
+		"csrfField": csrfField,
+
+		// Describes an audit event type, e.g. "Signed in"
+		"auditLabel": auditLabel,
+
+		// Formats a trait value for display, lists are comma separated and missing values shown as a dash
+		"displayValue": func(v interface{}) string {
+			switch val := v.(type) {
+			case nil:
+				return "-"
+			case string:
+				if val == "" {
+					return "-"
+				}
+				return val
+			case []interface{}:
+				parts := make([]string, 0, len(val))
+				for _, e := range val {
+					parts = append(parts, fmt.Sprint(e))
 				}
-				return ts
+				return strings.Join(parts, ", ")
 			}
-			return nil
+			return fmt.Sprint(v)
 		},
 
+		// Attempts to parse UI node text context into text secrets suitable for templates
+		// See the type textSecret above for field names
+		"getTextSecrets": textSecretsFromNode,
+
 		// Combines x number of keys and values into a map for template access
 		//
 		// Example: {{template "xyz" dict "Foo" "a" "Bar" .SomeValue}}
@@ -244,6 +330,9 @@ func globalFuncMap() template.FuncMap {
 				case "img":
 					return "ui_node_image"
 				case "input":
+					if isOIDCButton(node) {
+						return "ui_node_input_oidc"
+					}
 					switch node.Attributes.UiNodeInputAttributes.Type {
 					case "hidden":
 						return "ui_node_input_hidden"
@@ -263,6 +352,28 @@ func globalFuncMap() template.FuncMap {
 			return "ui_node_input_default"
 		},
 
+		// Social sign in provider branding for oidc group buttons, see oidc_providers.go
+		"oidcProvider":    oidcNodeProvider,
+		"oidcButtonLabel": oidcButtonLabel,
+
+		// Arranges nodes into form items, ordering and grouping the traits as the identity schema describes
+		// See trait_form.go
+		"arrangeNodes": arrangeNodes,
+
+		// Dereferences an optional value, returning the zero value for nil
+		"deref": func(v interface{}) interface{} {
+			switch p := v.(type) {
+			case *bool:
+				return p != nil && *p
+			case *string:
+				if p == nil {
+					return ""
+				}
+				return *p
+			}
+			return v
+		},
+
 		// Returns a node label based on the type of node passed
 		"getNodeLabel": func(node kratos.UiNode) string {
 			if _, ok := node.GetTypeOk(); ok {
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+)
+
+const (
+	// auditPageSize is the number of events shown on the admin audit page
+	auditPageSize = 200
+
+	// recentActivityCount is the number of events shown in the dashboard's 'recent activity' list
+	recentActivityCount = 10
+)
+
+// AuditParams configure the Audit http handler
+type AuditParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// AdminIdentityIDs are the identities allowed to view the audit log
+	AdminIdentityIDs []string
+
+	session.SessionStore
+}
+
+// Audit handler displays the most recent audit events, optionally filtered by the
+// 'identity' and 'type' query params. Only admins may view it.
+func (ap AuditParams) Audit(w http.ResponseWriter, r *http.Request) {
+	ks := ap.GetKratosSession(r)
+	if ks == nil || !isAdmin(ap.AdminIdentityIDs, ks.Identity.Id) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	q := audit.Query{
+		IdentityID: r.URL.Query().Get("identity"),
+		Type:       r.URL.Query().Get("type"),
+		Limit:      auditPageSize,
+	}
+	events, err := audit.Recent(q)
+	if err != nil {
+		log.Printf("Error reading audit events: %v", err)
+	}
+
+	dataMap := map[string]interface{}{
+		"title":  "Audit log",
+		"events": events,
+		"query":  q,
+		"types": []string{
+			audit.SessionEstablished, audit.SessionCleared, audit.SecondFactorRequired,
+			audit.SettingsUpdated, audit.RecoveryUsed,
+			audit.IdentitiesImported, audit.IdentitiesExported,
+			audit.InviteCreated, audit.InviteResent, audit.InviteRevoked, audit.InviteAccepted,
+			audit.RegistrationRejected,
+		},
+		"fs": ap.FS,
+	}
+	if err := GetTemplate(auditPage).Render("layout", w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// isAdmin reports if identityID is one of the admins, who may use the admin pages
+func isAdmin(adminIdentityIDs []string, identityID string) bool {
+	for _, id := range adminIdentityIDs {
+		if id == identityID {
+			return true
+		}
+	}
+	return false
+}
+
+// auditLabels describe the audit event types to users
+var auditLabels = map[string]string{
+	audit.SessionEstablished:   "Signed in",
+	audit.SessionCleared:       "Signed out",
+	audit.SecondFactorRequired: "Asked for a second factor",
+	audit.SettingsUpdated:      "Changed account settings",
+	audit.RecoveryUsed:         "Recovered account",
+	audit.IdentitiesImported:   "Imported identities",
+	audit.IdentitiesExported:   "Exported identities",
+	audit.InviteCreated:        "Invited a user",
+	audit.InviteResent:         "Resent an invitation",
+	audit.InviteRevoked:        "Revoked an invitation",
+	audit.InviteAccepted:       "Accepted an invitation",
+	audit.RegistrationRejected: "Registration rejected",
+}
+
+// auditLabel describes an audit event type, types without a description are returned as is
+func auditLabel(eventType string) string {
+	if l, ok := auditLabels[eventType]; ok {
+		return l
+	}
+	return eventType
+}
+
+// recentActivity returns the most recent audit events for the identity
+func recentActivity(identityID string) []audit.Event {
+	events, err := audit.Recent(audit.Query{IdentityID: identityID, Limit: recentActivityCount})
+	if err != nil {
+		log.Printf("Error reading audit events: %v", err)
+	}
+	return events
+}
@@ -0,0 +1,17 @@
+package handlers
+
+import "strings"
+
+// basePath is the path the app is served under, e.g. "/account", or "" if it is served at the root
+var basePath string
+
+// SetBasePath sets the path the app is served under, which prefixes the links and redirects to its pages
+func SetBasePath(p string) {
+	basePath = strings.TrimSuffix(p, "/")
+}
+
+// AppPath returns the path of the app's page p, e.g. "dashboard" is "/account/dashboard" when the
+// app is served under "/account"
+func AppPath(p string) string {
+	return basePath + "/" + strings.TrimPrefix(p, "/")
+}
@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var hrefAttr = regexp.MustCompile(`href="([^"]*)"`)
+
+func TestPageLinksUnderBasePath(t *testing.T) {
+	SetBasePath("/account")
+	defer SetBasePath("")
+	assert.Equal(t, "/account/settings", AppPath("settings"))
+	assert.Equal(t, "/account/settings", AppPath("/settings"))
+
+	for _, page := range []TemplateName{welcomePage, dashboardPage} {
+		var b bytes.Buffer
+		require.NoError(t, GetTemplate(page).tmpl.ExecuteTemplate(&b, "body", map[string]interface{}{}), page)
+		links := hrefAttr.FindAllStringSubmatch(b.String(), -1)
+		require.NotEmpty(t, links, page)
+		for _, m := range links {
+			assert.Regexp(t, `^(/account/|https://|#|$)`, m[1], "link on %s", page)
+		}
+	}
+}
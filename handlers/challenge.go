@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/challenge"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// checkChallenge verifies the challenge posted in r, if the client must complete one, returning the message
+// to show if it wasn't completed
+func checkChallenge(r *http.Request, guard *challenge.Guard) *kratos.UiText {
+	err := guard.Check(r.Context(), r)
+	if err == nil {
+		return nil
+	}
+	text := "Complete the check that you're human, then send the form again."
+	if !errors.Is(err, challenge.ErrFailed) {
+		log.Printf("Error verifying challenge: %v", err)
+		text = "The check that you're human couldn't be verified just now, please try again."
+	}
+	return &kratos.UiText{Id: challenge.MessageID, Text: text, Type: "error"}
+}
+
+// addChallenge adds the challenge widget to dataMap if the client must complete one. If it can't be created the
+// form is shown without it, and posting it fails the check.
+func addChallenge(r *http.Request, guard *challenge.Guard, dataMap map[string]interface{}) {
+	if !guard.Required(r) {
+		return
+	}
+	widget, err := guard.Provider.Widget(r)
+	if err != nil {
+		log.Printf("Error creating challenge: %v", err)
+		return
+	}
+	dataMap["challenge"] = widget
+}
+
+// flowErrors returns the flow's error messages, prefixed with the group of the node they relate to
+func flowErrors(ui kratos.UiContainer) string {
+	var errs []string
+	for _, m := range ui.Messages {
+		if m.Type == "error" {
+			errs = append(errs, m.Text)
+		}
+	}
+	for _, n := range ui.Nodes {
+		for _, m := range n.Messages {
+			if m.Type == "error" {
+				errs = append(errs, n.Group+": "+m.Text)
+			}
+		}
+	}
+	return strings.Join(errs, "; ")
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
+)
+
+// CSRFFailureParams configure the CSRFFailure http handler
+type CSRFFailureParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// HomeURL is the URL for returning home
+	HomeURL string
+}
+
+// CSRFFailure handler displays the error page when a form is posted without a valid CSRF token
+func (cp CSRFFailureParams) CSRFFailure(w http.ResponseWriter, r *http.Request) {
+	dataMap := map[string]interface{}{
+		"title":   "Form expired",
+		"homeURL": cp.HomeURL,
+		"message": "The form has expired, or was not submitted from this site. Go back, reload the page and try again.",
+		"fs":      cp.FS,
+	}
+	if err := GetTemplate(errorPage).RenderStatus("layout", http.StatusForbidden, w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// csrfField returns the hidden input holding the CSRF token. data is either a page's
+// data map or the token itself.
+func csrfField(data interface{}) template.HTML {
+	token := ""
+	switch d := data.(type) {
+	case string:
+		token = d
+	case map[string]interface{}:
+		token, _ = d["csrfToken"].(string)
+	}
+	return template.HTML(`<input type="hidden" name="` + middleware.CSRFFieldName + `" value="` + template.HTMLEscapeString(token) + `">`)
+}
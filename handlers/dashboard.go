@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// recentSignInCount is the number of sessions shown in the 'recent sign-ins' list
+	recentSignInCount = 5
+)
+
+// DashboardParams configure the Dashboard http handler
+type DashboardParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// Schemas loads the identity schema used to display the profile traits
+	Schemas *schema.Loader
+
+	// LoginURL is where we redirect to if there is no session
+	LoginURL string
+
+	session.SessionStore
+}
+
+// verifiableAddress is an address displayed on the dashboard along with an
+// optional form to resend the verification email
+type verifiableAddress struct {
+	kratos.VerifiableIdentityAddress
+	Resend *resendVerificationForm
+}
+
+// resendVerificationForm holds the values needed to submit a verification flow for an address
+type resendVerificationForm struct {
+	Action    string
+	CsrfToken string
+	Address   string
+}
+
+// secondFactor describes a second factor authentication method and whether it is enabled
+type secondFactor struct {
+	Name    string
+	Anchor  string
+	Enabled bool
+	// Count is the number of keys or unused codes, zero if unknown or not applicable
+	Count      int
+	CountLabel string
+}
+
+// signIn is a session shown in the 'recent sign-ins' list
+type signIn struct {
+	AuthenticatedAt *time.Time
+	Methods         string
+	Aal             string
+	Active          bool
+	Current         bool
+}
+
+// Dashboard handler displays a summary of the authenticated users account
+func (dp DashboardParams) Dashboard(w http.ResponseWriter, r *http.Request) {
+	ks := dp.GetKratosSession(r)
+	if ks == nil {
+		http.Redirect(w, r, dp.LoginURL, http.StatusFound)
+		return
+	}
+	identity := ks.Identity
+
+	dataMap := map[string]interface{}{
+		"title":         "Your account",
+		"profile":       dp.profileFields(r, identity),
+		"addresses":     dp.verifiableAddresses(w, r, identity),
+		"secondFactors": secondFactors(r, identity.Id),
+		"signIns":       recentSignIns(r, ks),
+		"activity":      recentActivity(identity.Id),
+		"fs":            dp.FS,
+	}
+	if err := GetTemplate(dashboardPage).Render("layout", w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// profileFields returns the identity traits, ordered and titled according to the identity schema
+func (dp DashboardParams) profileFields(r *http.Request, identity kratos.Identity) []schema.Field {
+	if s := loadSchema(r, dp.Schemas, identity.SchemaId); s != nil {
+		return s.Fields(identity.Traits)
+	}
+	return schema.FieldsWithoutSchema(identity.Traits)
+}
+
+// verifiableAddresses returns the identity's addresses. If any are unverified a verification
+// flow is started, so each of them can be given a 'resend' button that submits directly to Kratos
+func (dp DashboardParams) verifiableAddresses(w http.ResponseWriter, r *http.Request, identity kratos.Identity) []verifiableAddress {
+	addresses := make([]verifiableAddress, 0, len(identity.VerifiableAddresses))
+	var flow *kratos.SelfServiceVerificationFlow
+	for _, a := range identity.VerifiableAddresses {
+		va := verifiableAddress{VerifiableIdentityAddress: a}
+		if !a.Verified {
+			if flow == nil {
+				flow = initVerificationFlow(w, r)
+			}
+			if flow != nil {
+				va.Resend = &resendVerificationForm{
+					Action:    flow.Ui.Action,
+					CsrfToken: csrfTokenFromNodes(flow.Ui.Nodes),
+					Address:   a.Value,
+				}
+			}
+		}
+		addresses = append(addresses, va)
+	}
+	return addresses
+}
+
+// initVerificationFlow starts a browser verification flow on behalf of the browser.
+// Kratos sets its anti-CSRF cookie on the response, so that is passed on to the browser.
+func initVerificationFlow(w http.ResponseWriter, r *http.Request) *kratos.SelfServiceVerificationFlow {
+	flow, rawResp, err := api_client.PublicClient().V0alpha2Api.InitializeSelfServiceVerificationFlowForBrowsers(r.Context()).Execute()
+	if err != nil {
+		log.Printf("Error initializing verification flow: %v", err)
+		return nil
+	}
+	for _, c := range rawResp.Header.Values("Set-Cookie") {
+		w.Header().Add("Set-Cookie", c)
+	}
+	return flow
+}
+
+// csrfTokenFromNodes returns the value of the 'csrf_token' node, or "" if there is none
+func csrfTokenFromNodes(nodes []kratos.UiNode) string {
+	for _, n := range nodes {
+		if n.Attributes.UiNodeInputAttributes == nil || n.Attributes.UiNodeInputAttributes.Name != "csrf_token" {
+			continue
+		}
+		if token, ok := n.Attributes.UiNodeInputAttributes.Value.(string); ok {
+			return token
+		}
+	}
+	return ""
+}
+
+// secondFactors returns the second factor methods and whether the identity has them enabled.
+// Credentials are only visible through the admin API.
+func secondFactors(r *http.Request, identityID string) []secondFactor {
+	factors := []secondFactor{
+		{Name: "Authenticator app (TOTP)", Anchor: "totp"},
+		{Name: "Security keys and biometrics", Anchor: "webauthn", CountLabel: "registered"},
+		{Name: "Backup recovery codes", Anchor: "lookup_secret", CountLabel: "unused"},
+	}
+	identity, _, err := api_client.AdminClient().V0alpha2Api.AdminGetIdentity(r.Context(), identityID).Execute()
+	if err != nil {
+		log.Printf("Error getting identity credentials: %v", err)
+		return factors
+	}
+	if identity.Credentials == nil {
+		return factors
+	}
+	credentials := *identity.Credentials
+	for i := range factors {
+		c, ok := credentials[factors[i].Anchor]
+		if !ok {
+			continue
+		}
+		switch factors[i].Anchor {
+		case "totp":
+			factors[i].Enabled = true
+		case "webauthn":
+			factors[i].Count = len(configList(c.Config, "credentials"))
+			factors[i].Enabled = factors[i].Count > 0 || c.Config == nil
+		case "lookup_secret":
+			for _, code := range configList(c.Config, "lookup_secrets") {
+				if m, ok := code.(map[string]interface{}); ok && !isUsedLookupSecret(m) {
+					factors[i].Count++
+				}
+			}
+			factors[i].Enabled = factors[i].Count > 0 || c.Config == nil
+		}
+	}
+	return factors
+}
+
+// configList returns the list stored under key in a credentials config
+func configList(config map[string]interface{}, key string) []interface{} {
+	if config == nil {
+		return nil
+	}
+	l, _ := config[key].([]interface{})
+	return l
+}
+
+// isUsedLookupSecret reports if a lookup secret has been used, Kratos marks
+// unused codes with a zero 'used_at' timestamp
+func isUsedLookupSecret(code map[string]interface{}) bool {
+	usedAt, _ := code["used_at"].(string)
+	if usedAt == "" {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339, usedAt)
+	return err == nil && !t.IsZero()
+}
+
+// recentSignIns returns the most recent sessions for the identity, newest first
+func recentSignIns(r *http.Request, current *kratos.Session) []signIn {
+	sessions, _, err := api_client.AdminClient().V0alpha2Api.AdminListIdentitySessions(r.Context(), current.Identity.Id).Execute()
+	if err != nil {
+		log.Printf("Error listing identity sessions: %v", err)
+		sessions = []kratos.Session{*current}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		a, b := sessions[i].AuthenticatedAt, sessions[j].AuthenticatedAt
+		if a == nil || b == nil {
+			return b == nil && a != nil
+		}
+		return a.After(*b)
+	})
+	if len(sessions) > recentSignInCount {
+		sessions = sessions[:recentSignInCount]
+	}
+	signIns := make([]signIn, 0, len(sessions))
+	for _, s := range sessions {
+		var methods []string
+		for _, m := range s.AuthenticationMethods {
+			if m.Method != nil {
+				methods = append(methods, *m.Method)
+			}
+		}
+		si := signIn{
+			AuthenticatedAt: s.AuthenticatedAt,
+			Methods:         strings.Join(methods, ", "),
+			Active:          s.GetActive(),
+			Current:         s.Id == current.Id,
+		}
+		if s.AuthenticatorAssuranceLevel != nil {
+			si.Aal = string(*s.AuthenticatorAssuranceLevel)
+		}
+		signIns = append(signIns, si)
+	}
+	return signIns
+}
+
+// browserLogoutURL returns the Kratos logout URL for the browser's session, or "" if there is no session
+func browserLogoutURL(r *http.Request) string {
+	logoutResp, rawResp, err := api_client.PublicClient().V0alpha2Api.CreateSelfServiceLogoutFlowUrlForBrowsers(r.Context()).Cookie(r.Header.Get("Cookie")).Execute()
+	if rawResp != nil && rawResp.StatusCode == 401 {
+		return ""
+	} else if err != nil {
+		log.Printf("Error getting logout url: %v", err)
+		return ""
+	}
+	return logoutResp.GetLogoutUrl()
+}
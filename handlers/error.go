@@ -1,11 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	kratos "github.com/ory/kratos-client-go"
 )
 
 // ErrorParams configure the Login http handler
@@ -17,37 +22,186 @@ type KratosErrorParams struct {
 	RedirectURL string
 	// HomeURL is the URL for returning home
 	HomeURL string
+	// LoginURL is the URL to start signing in again, after errors in a flow
+	LoginURL string
+
+	// Debug shows the error Kratos returned, in a collapsed block
+	Debug bool
+}
+
+// errorKind classifies the errors Kratos shows, by what the user can do about them
+type errorKind string
+
+const (
+	errorExpired   = errorKind("expired")
+	errorSecurity  = errorKind("security")
+	errorCSRF      = errorKind("csrf")
+	errorNotFound  = errorKind("not_found")
+	errorInternal  = errorKind("internal")
+	errorForbidden = errorKind("forbidden")
+)
+
+// errorInfo describes an error to the user
+type errorInfo struct {
+	Kind        errorKind
+	Status      int
+	Title       string
+	Explanation string
+
+	// ActionLabel and ActionURL are what the user should do next
+	ActionLabel string
+	ActionURL   string
+
+	// ID and Time identify the error, for support
+	ID   string
+	Time time.Time
+
+	// Detail is the error Kratos returned, only shown in debug mode
+	Detail string
 }
 
 // Login handler displays the login screen
 func (ep KratosErrorParams) Error(w http.ResponseWriter, r *http.Request) {
 
-	// Start the error flow with Kratos if required
-	flow := r.URL.Query().Get("flow")
-	if flow == "" {
-		http.Redirect(w, r, ep.RedirectURL, http.StatusMovedPermanently)
+	// Kratos sends the browser here with the id of the error, older links name it 'flow'
+	id := r.URL.Query().Get("id")
+	if id == "" {
+		id = r.URL.Query().Get("flow")
+	}
+	if id == "" {
+		http.Redirect(w, r, ep.RedirectURL, http.StatusSeeOther)
 		return
 	}
 
-	errorResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceError(r.Context()).Id(flow).Execute()
-	if err != nil {
-		log.Printf("Error getting self service error flow: %v, redirecting to %s", err, ep.RedirectURL)
-		http.Redirect(w, r, ep.RedirectURL, http.StatusMovedPermanently)
+	errorResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceError(r.Context()).Id(id).Execute()
+	if api_client.IsUnavailable(rawResp, err) {
+		log.Printf("Error getting self service error flow: %v", err)
+		renderServiceUnavailable(w, r, ep.FS)
 		return
-	} else if rawResp != nil {
-		if rawResp.StatusCode == 404 {
-			log.Printf("Error could not be found, redirecting to %v", ep.RedirectURL)
-			http.Redirect(w, r, ep.RedirectURL, http.StatusMovedPermanently)
+	}
+
+	var page errorInfo
+	switch {
+	case err == nil:
+		page = classifyError(errorResp.Error)
+		page.ID = errorResp.Id
+		if errorResp.CreatedAt != nil {
+			page.Time = *errorResp.CreatedAt
 		}
+	case rawResp.StatusCode == http.StatusNotFound:
+		// Kratos forgets errors after a while, or the link was made up
+		log.Printf("Self service error %s could not be found", id)
+		page = classifyError(map[string]interface{}{"code": float64(http.StatusNotFound)})
+		page.ID = id
+	default:
+		log.Printf("Error getting self service error flow: %v", err)
+		page = classifyError(apiError(err))
+		page.ID = id
 	}
+	ep.render(w, r, page)
+}
 
+// render shows the error page
+func (ep KratosErrorParams) render(w http.ResponseWriter, r *http.Request, page errorInfo) {
+	if page.Time.IsZero() {
+		page.Time = time.Now()
+	}
+	switch page.Kind {
+	case errorExpired, errorCSRF, errorSecurity:
+		page.ActionURL = ep.LoginURL
+	default:
+		page.ActionURL = ep.HomeURL
+	}
+	log.Printf("Showing %s error page %s", page.Kind, page.ID)
+	if !ep.Debug {
+		page.Detail = ""
+	}
 	dataMap := map[string]interface{}{
-		"title":   "An error occurred",
+		"title":   page.Title,
 		"homeURL": ep.HomeURL,
-		"message": errorResp.GetError(),
+		"error":   page,
 		"fs":      ep.FS,
 	}
-	if err = GetTemplate(errorPage).Render("layout", w, r, dataMap); err != nil {
+	if err := GetTemplate(errorPage).RenderStatus("layout", page.Status, w, r, dataMap); err != nil {
 		TemplateErrorHandler(w, r, err)
 	}
 }
+
+// classifyError describes the error Kratos returned, e.g. {"code": 403, "id": "security_csrf_violation",
+// "reason": "..."}, by what the user can do about it
+func classifyError(e map[string]interface{}) errorInfo {
+	id, _ := e["id"].(string)
+	code, _ := e["code"].(float64)
+	text := strings.ToLower(errorString(e, "reason") + " " + errorString(e, "message"))
+
+	page := errorInfo{Detail: errorDetail(e)}
+	switch {
+	case strings.Contains(id, "csrf") || strings.Contains(text, "csrf") || strings.Contains(text, "cross-site"):
+		page.Kind = errorCSRF
+		page.Status = http.StatusForbidden
+		page.Title = "Your request couldn't be verified"
+		page.Explanation = "This usually happens when the page was open in another tab, or your browser blocked cookies. Start again, and make sure cookies are enabled for this site."
+		page.ActionLabel = "Start again"
+	case id == "self_service_flow_expired" || code == http.StatusGone || strings.Contains(text, "expired"):
+		page.Kind = errorExpired
+		page.Status = http.StatusGone
+		page.Title = "This page has expired"
+		page.Explanation = "For your security, pages for signing in and managing your account only work for a limited time. Start again to get a new one."
+		page.ActionLabel = "Start again"
+	case strings.HasPrefix(id, "security_"):
+		page.Kind = errorSecurity
+		page.Status = http.StatusForbidden
+		page.Title = "We stopped this request to protect your account"
+		page.Explanation = "Something about the request didn't look right, for example it was started by another account or browser. Sign in again to continue."
+		page.ActionLabel = "Sign in again"
+	case code == http.StatusNotFound:
+		page.Kind = errorNotFound
+		page.Status = http.StatusNotFound
+		page.Title = "We couldn't find that"
+		page.Explanation = "The link may be old, or already used. Go back to the start and try again."
+		page.ActionLabel = "Go home"
+	case code == http.StatusForbidden || code == http.StatusUnauthorized || code == http.StatusBadRequest:
+		page.Kind = errorForbidden
+		page.Status = int(code)
+		page.Title = "This request can't be completed"
+		page.Explanation = "The request wasn't allowed. Go back to the start and try again, and contact support if it keeps happening."
+		page.ActionLabel = "Go home"
+	default:
+		page.Kind = errorInternal
+		page.Status = http.StatusInternalServerError
+		page.Title = "Something went wrong on our side"
+		page.Explanation = "It's not something you did. Try again in a few minutes, and contact support if it keeps happening."
+		page.ActionLabel = "Go home"
+	}
+	return page
+}
+
+// errorString returns the string field key of a Kratos error, or ""
+func errorString(e map[string]interface{}, key string) string {
+	s, _ := e[key].(string)
+	return s
+}
+
+// errorDetail returns a Kratos error as indented JSON
+func errorDetail(e map[string]interface{}) string {
+	if len(e) == 0 {
+		return ""
+	}
+	b, err := json.MarshalIndent(e, "", "  ")
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// apiError returns the error in the body of a failed Kratos API call, or an internal error if there isn't one
+func apiError(err error) map[string]interface{} {
+	var body struct {
+		Error map[string]interface{} `json:"error"`
+	}
+	var apiErr *kratos.GenericOpenAPIError
+	if errors.As(err, &apiErr) && json.Unmarshal(apiErr.Body(), &body) == nil && body.Error != nil {
+		return body.Error
+	}
+	return map[string]interface{}{"code": float64(http.StatusInternalServerError), "reason": err.Error()}
+}
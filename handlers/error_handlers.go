@@ -1,23 +1,106 @@
 package handlers
 
 import (
+	"bytes"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/incident"
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
 )
 
-// ErrorHandler renders a response when an error occurs
+// errorPageFS provides the static files the internal error page links to
+var errorPageFS *hashfs.FS
+
+// SetErrorPageFS sets the static files the internal error page links to, it is shown without them until set
+func SetErrorPageFS(fs *hashfs.FS) {
+	errorPageFS = fs
+}
+
+// fallbackErrorPage is shown if the error page itself can't be rendered, e.g. the layout is broken
+const fallbackErrorPage = `<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>%s</title></head>
+<body style="font-family: sans-serif; max-width: 640px; margin: 48px auto; padding: 0 16px;">
+<h1>Something went wrong on our side</h1>
+<p>It's not something you did. Try again in a few minutes, and contact support if it keeps happening.</p>
+<p>Error ID <code>%s</code></p>
+<p><a href="%s">Go home</a></p>
+</body>
+</html>
+`
+
+// TemplateErrorHandler renders a response when a page can't be rendered. The error is reported as an incident,
+// and the user sees an error page with its ID rather than the error.
 func TemplateErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	log.Printf("Template error handler returning 500")
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.WriteHeader(500)
-	fmt.Fprintln(w, err.Error())
+	renderInternalError(w, r, incident.Report(r, incident.Render, err, nil))
 }
 
-func KratosErrorHandler(w http.ResponseWriter, r *http.Request, response *http.Response, err error, redirect string) {
-	log.Printf("Kratos error handler redirecting to %v", redirect)
+// PanicHandler renders a response when a handler panics, for the recovery middleware. The panic is reported
+// as an incident, and the user sees an error page with its ID rather than the stack.
+func PanicHandler(w http.ResponseWriter, r *http.Request, err error, stack []byte) {
+	renderInternalError(w, r, incident.Report(r, incident.Panic, err, stack))
+}
+
+// renderInternalError shows the error page for inc. If the page can't be rendered a static page is shown instead.
+// If the response has already started, e.g. an export being streamed, the incident is only reported.
+func renderInternalError(w http.ResponseWriter, r *http.Request, inc incident.Incident) {
+	if middleware.ResponseStarted(w) {
+		log.Printf("Incident %s: the response has already started, not showing the error page", inc.ID)
+		return
+	}
+	page := classifyError(nil)
+	page.ActionURL = AppPath("")
+	page.ID, page.Time = inc.ID, inc.Time
+	dataMap := map[string]interface{}{
+		"title":   page.Title,
+		"homeURL": page.ActionURL,
+		"error":   page,
+		"fs":      errorPageFS,
+	}
+
+	var b bytes.Buffer
+	if err := executeSafely(GetTemplate(errorPage), &b, "layout", dataMap); err != nil {
+		log.Printf("Incident %s: error rendering the error page, showing the fallback: %v", inc.ID, err)
+		b.Reset()
+		fmt.Fprintf(&b, fallbackErrorPage, ErrRenderingPage, html.EscapeString(inc.ID), html.EscapeString(AppPath("")))
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	b.WriteTo(w)
+}
+
+// executeSafely executes the template t, returning an error rather than panicking, e.g. if it isn't registered
+func executeSafely(t Template, b *bytes.Buffer, name string, dataMap map[string]interface{}) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	if t.tmpl == nil {
+		return fmt.Errorf("template not registered")
+	}
+	return t.tmpl.ExecuteTemplate(b, name, dataMap)
+}
+
+// KratosErrorHandler handles an error returned by Kratos. Expired or unknown flows are restarted by redirecting
+// to redirect, if Kratos is unavailable a page saying so is shown, and other errors are described on the error page.
+func KratosErrorHandler(w http.ResponseWriter, r *http.Request, fs *hashfs.FS, response *http.Response, err error, redirect string) {
+	if api_client.IsUnavailable(response, err) {
+		log.Printf("Kratos unavailable: %v", err)
+		renderServiceUnavailable(w, r, fs)
+		return
+	}
 	if response.StatusCode == 404 || response.StatusCode == 410 || response.StatusCode == 403 {
-		http.Redirect(w, r, redirect, http.StatusMovedPermanently)
+		log.Printf("Kratos error handler redirecting to %v", redirect)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
 		return
 	}
+	log.Printf("Kratos error: %v", err)
+	ep := KratosErrorParams{FS: fs, HomeURL: AppPath(""), LoginURL: AppPath("login")}
+	ep.render(w, r, classifyError(apiError(err)))
 }
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPanicHandler(t *testing.T) {
+	recoverP := middleware.RecoverParams{PanicHandler: PanicHandler}
+
+	// Before the response starts the error page is shown
+	w := httptest.NewRecorder()
+	recoverP.RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(errors.New("boom"))
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Body.String(), "Error ID")
+
+	// After, e.g. part way through an export, only the incident is reported
+	w = httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		recoverP.RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/csv")
+			w.Write([]byte("id,email\n"))
+			panic(errors.New("boom"))
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/identities/export", nil))
+	})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/csv", w.Header().Get("Content-Type"))
+	assert.Equal(t, "id,email\n", w.Body.String())
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    map[string]interface{}
+		kind   errorKind
+		status int
+	}{
+		{"csrf id", map[string]interface{}{"code": float64(403), "id": "security_csrf_violation"}, errorCSRF, http.StatusForbidden},
+		{"csrf reason", map[string]interface{}{"code": float64(400), "reason": "The request was rejected to protect you from Cross-Site-Request-Forgery"}, errorCSRF, http.StatusForbidden},
+		{"expired id", map[string]interface{}{"code": float64(403), "id": "self_service_flow_expired"}, errorExpired, http.StatusGone},
+		{"gone", map[string]interface{}{"code": float64(410)}, errorExpired, http.StatusGone},
+		{"expired message", map[string]interface{}{"code": float64(400), "message": "The flow has Expired"}, errorExpired, http.StatusGone},
+		{"security", map[string]interface{}{"code": float64(403), "id": "security_identity_mismatch"}, errorSecurity, http.StatusForbidden},
+		{"not found", map[string]interface{}{"code": float64(404)}, errorNotFound, http.StatusNotFound},
+		{"bad request", map[string]interface{}{"code": float64(400)}, errorForbidden, http.StatusBadRequest},
+		{"unauthorized", map[string]interface{}{"code": float64(401)}, errorForbidden, http.StatusUnauthorized},
+		{"forbidden", map[string]interface{}{"code": float64(403)}, errorForbidden, http.StatusForbidden},
+		{"server error", map[string]interface{}{"code": float64(500), "reason": "database down"}, errorInternal, http.StatusInternalServerError},
+		{"empty", map[string]interface{}{}, errorInternal, http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := classifyError(tt.err)
+			assert.Equal(t, tt.kind, page.Kind)
+			assert.Equal(t, tt.status, page.Status)
+			assert.NotEmpty(t, page.Title)
+			assert.NotEmpty(t, page.Explanation)
+			assert.NotEmpty(t, page.ActionLabel)
+			assert.Equal(t, errorDetail(tt.err), page.Detail)
+		})
+	}
+}
+
+// kratosError returns the error of a Kratos API call answered with status and body
+func kratosError(t *testing.T, status int, body string) error {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+	cfg := kratos.NewConfiguration()
+	cfg.Servers = kratos.ServerConfigurations{{URL: srv.URL}}
+	_, _, err := kratos.NewAPIClient(cfg).V0alpha2Api.GetSelfServiceError(context.Background()).Id("e1").Execute()
+	require.Error(t, err)
+	return err
+}
+
+func TestAPIError(t *testing.T) {
+	// The error in the body is returned
+	err := kratosError(t, http.StatusGone, `{"error":{"code":410,"status":"Gone","reason":"The flow has expired"}}`)
+	assert.Equal(t, map[string]interface{}{"code": float64(410), "status": "Gone", "reason": "The flow has expired"}, apiError(err))
+
+	// Without one it is an internal error
+	err = kratosError(t, http.StatusBadGateway, `<html>Bad gateway</html>`)
+	e := apiError(err)
+	assert.Equal(t, float64(http.StatusInternalServerError), e["code"])
+	assert.Equal(t, err.Error(), e["reason"])
+
+	e = apiError(errors.New("connection refused"))
+	assert.Equal(t, map[string]interface{}{"code": float64(http.StatusInternalServerError), "reason": "connection refused"}, e)
+	assert.Equal(t, errorInternal, classifyError(e).Kind)
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// Flash message ids, so pages and tests can tell them apart from the Kratos messages
+const (
+	flashInfoID    = 1190201
+	flashWarningID = 1190202
+	flashErrorID   = 4190201
+)
+
+// flashParams are the query params that show a flash message of their type
+var flashParams = []struct {
+	name string
+	t    session.FlashType
+}{
+	{"flash_info", session.FlashInfo},
+	{"flash_warning", session.FlashWarning},
+	{"flash_error", session.FlashError},
+}
+
+// queryFlashes are the messages that links can show with a flash query param, e.g. ?flash_info=signed_out.
+// The params only name a message, so other sites can't craft links that show their own text on our pages.
+var queryFlashes = map[string]string{
+	"signed_out":       "You have signed out.",
+	"session_expired":  "Your session has expired, sign in again to continue.",
+	"settings_saved":   "Your settings have been saved.",
+	"account_verified": "Your email address has been verified.",
+	"try_again":        "Something went wrong, please try again.",
+}
+
+// flashMessages returns the flash messages to show on the page rendered for r: those stored in the session,
+// and those named in the query
+func flashMessages(w http.ResponseWriter, r *http.Request) []kratos.UiText {
+	var messages []kratos.UiText
+	for _, f := range middleware.Flashes(w, r) {
+		messages = append(messages, flashText(f))
+	}
+	query := r.URL.Query()
+	for _, param := range flashParams {
+		key := query.Get(param.name)
+		if key == "" {
+			continue
+		}
+		text, ok := queryFlashes[key]
+		if !ok {
+			log.Printf("Ignoring unknown flash message %s=%q", param.name, key)
+			continue
+		}
+		messages = append(messages, flashText(session.Flash{Type: param.t, Text: text}))
+	}
+	return messages
+}
+
+// flashText returns a flash as a message for the messages partial
+func flashText(f session.Flash) kratos.UiText {
+	id := int64(flashInfoID)
+	switch f.Type {
+	case session.FlashWarning:
+		id = flashWarningID
+	case session.FlashError:
+		id = flashErrorID
+	}
+	return kratos.UiText{Id: id, Text: f.Text, Type: string(f.Type)}
+}
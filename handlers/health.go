@@ -1,7 +1,200 @@
 package handlers
 
-import "net/http"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// readinessCheckTimeout limits how long each readiness check may take
+	readinessCheckTimeout = 2 * time.Second
+
+	// Readiness statuses
+	readinessOK           = "ok"
+	readinessError        = "error"
+	readinessUnavailable  = "unavailable"
+	readinessShuttingDown = "shutting_down"
+)
+
+// Health handler reports that the app is alive
 func Health(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
+
+// ReadinessCheck checks that a dependency is ready
+type ReadinessCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+// Readiness is the http handler for the readiness probe. The checks are run at most once per cache TTL,
+// concurrent probes wait for and share the same result.
+type Readiness struct {
+	checks   []ReadinessCheck
+	cacheTTL time.Duration
+
+	shuttingDown int32
+
+	mu        sync.Mutex
+	last      readinessReport
+	lastAt    time.Time
+	lastReady bool
+}
+
+// readinessReport is the JSON body of the readiness probe
+type readinessReport struct {
+	Status    string                     `json:"status"`
+	CheckedAt time.Time                  `json:"checked_at"`
+	Checks    map[string]readinessResult `json:"checks,omitempty"`
+}
+
+// readinessResult is the outcome of a single check
+type readinessResult struct {
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// NewReadiness returns a readiness probe running checks, caching the result for cacheTTL
+func NewReadiness(cacheTTL time.Duration, checks ...ReadinessCheck) *Readiness {
+	return &Readiness{checks: checks, cacheTTL: cacheTTL}
+}
+
+// SetShuttingDown makes the probe report not ready, so no new traffic is sent while we shut down
+func (rd *Readiness) SetShuttingDown() {
+	atomic.StoreInt32(&rd.shuttingDown, 1)
+}
+
+// Ready handler reports whether the app and its dependencies are ready to serve traffic
+func (rd *Readiness) Ready(w http.ResponseWriter, r *http.Request) {
+	var report readinessReport
+	ready := false
+	if atomic.LoadInt32(&rd.shuttingDown) == 1 {
+		report = readinessReport{Status: readinessShuttingDown, CheckedAt: time.Now().UTC()}
+	} else {
+		report, ready = rd.check()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if ready {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		log.Printf("Error writing readiness report: %v", err)
+	}
+}
+
+// check returns the cached report, or runs the checks in parallel if it has expired
+func (rd *Readiness) check() (readinessReport, bool) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if !rd.lastAt.IsZero() && time.Since(rd.lastAt) < rd.cacheTTL {
+		return rd.last, rd.lastReady
+	}
+
+	// The checks are not cancelled if this probe goes away, as their result is shared
+	ctx, cancel := context.WithTimeout(context.Background(), readinessCheckTimeout)
+	defer cancel()
+
+	results := make([]readinessResult, len(rd.checks))
+	var wg sync.WaitGroup
+	for i, c := range rd.checks {
+		wg.Add(1)
+		go func(i int, c ReadinessCheck) {
+			defer wg.Done()
+			start := time.Now()
+			err := c.Check(ctx)
+			results[i] = readinessResult{Status: readinessOK, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				results[i].Status = readinessError
+				results[i].Error = err.Error()
+			}
+		}(i, c)
+	}
+	wg.Wait()
+
+	report := readinessReport{
+		Status:    readinessOK,
+		CheckedAt: time.Now().UTC(),
+		Checks:    make(map[string]readinessResult, len(rd.checks)),
+	}
+	ready := true
+	for i, c := range rd.checks {
+		report.Checks[c.Name] = results[i]
+		if results[i].Status != readinessOK {
+			ready = false
+			report.Status = readinessUnavailable
+		}
+	}
+	rd.last, rd.lastAt, rd.lastReady = report, time.Now(), ready
+	return report, ready
+}
+
+// KratosReadinessCheck checks the readiness endpoint of the Kratos API that client calls
+func KratosReadinessCheck(name string, client func() *kratos.APIClient) ReadinessCheck {
+	return ReadinessCheck{
+		Name: name,
+		Check: func(ctx context.Context) error {
+			_, rawResp, err := client().MetadataApi.IsReady(ctx).Execute()
+			if err != nil {
+				if rawResp != nil {
+					return fmt.Errorf("status %d: %w", rawResp.StatusCode, err)
+				}
+				return err
+			}
+			return nil
+		},
+	}
+}
+
+// SessionStoreReadinessCheck checks that values can be stored in, and read back from, the session store
+func SessionStoreReadinessCheck(store *sessions.CookieStore) ReadinessCheck {
+	return ReadinessCheck{
+		Name: "session_store",
+		Check: func(ctx context.Context) error {
+			encoded, err := securecookie.EncodeMulti("readiness", "ok", store.Codecs...)
+			if err != nil {
+				return err
+			}
+			var decoded string
+			if err := securecookie.DecodeMulti("readiness", encoded, &decoded, store.Codecs...); err != nil {
+				return err
+			}
+			if decoded != "ok" {
+				return errors.New("session value changed by round trip")
+			}
+			return nil
+		},
+	}
+}
+
+// TemplatesReadinessCheck checks that the page templates have been registered
+func TemplatesReadinessCheck() ReadinessCheck {
+	return ReadinessCheck{
+		Name: "templates",
+		Check: func(ctx context.Context) error {
+			if len(templateMap) == 0 {
+				return errors.New("no templates registered")
+			}
+			for name, t := range templateMap {
+				if t.tmpl == nil || t.tmpl.Lookup("layout") == nil {
+					return fmt.Errorf("template '%s' has no layout", name)
+				}
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// probe calls the readiness handler, returning its status and report
+func probe(t *testing.T, rd *Readiness) (int, readinessReport) {
+	w := httptest.NewRecorder()
+	rd.Ready(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+	var report readinessReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	return w.Code, report
+}
+
+func TestReadiness(t *testing.T) {
+	var calls int32
+	var failing atomic.Value
+	failing.Store(false)
+	rd := NewReadiness(time.Hour,
+		ReadinessCheck{Name: "ok", Check: func(ctx context.Context) error { return nil }},
+		ReadinessCheck{Name: "flaky", Check: func(ctx context.Context) error {
+			atomic.AddInt32(&calls, 1)
+			if failing.Load().(bool) {
+				return errors.New("down")
+			}
+			return nil
+		}},
+	)
+
+	status, report := probe(t, rd)
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, readinessOK, report.Status)
+	assert.Equal(t, readinessOK, report.Checks["flaky"].Status)
+
+	// The result is reused until the cache expires
+	failing.Store(true)
+	status, _ = probe(t, rd)
+	assert.Equal(t, http.StatusOK, status)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	rd.cacheTTL = 0
+	status, report = probe(t, rd)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, readinessUnavailable, report.Status)
+	assert.Equal(t, readinessError, report.Checks["flaky"].Status)
+	assert.Equal(t, "down", report.Checks["flaky"].Error)
+	assert.Equal(t, readinessOK, report.Checks["ok"].Status)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+func TestReadinessSharesChecks(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	rd := NewReadiness(time.Hour, ReadinessCheck{Name: "slow", Check: func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return nil
+	}})
+
+	// Concurrent probes wait for the check in progress rather than starting their own
+	var wg sync.WaitGroup
+	statuses := make([]int, 5)
+	for i := range statuses {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			rd.Ready(w, httptest.NewRequest(http.MethodGet, "/health/ready", nil))
+			statuses[i] = w.Code
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+	for _, s := range statuses {
+		assert.Equal(t, http.StatusOK, s)
+	}
+}
+
+func TestReadinessShuttingDown(t *testing.T) {
+	var calls int32
+	rd := NewReadiness(0, ReadinessCheck{Name: "ok", Check: func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}})
+	status, _ := probe(t, rd)
+	assert.Equal(t, http.StatusOK, status)
+
+	// Once shutting down the probe fails, without running the checks
+	rd.SetShuttingDown()
+	status, report := probe(t, rd)
+	assert.Equal(t, http.StatusServiceUnavailable, status)
+	assert.Equal(t, readinessShuttingDown, report.Status)
+	assert.Empty(t, report.Checks)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+
+	// Liveness is unaffected
+	w := httptest.NewRecorder()
+	Health(w, httptest.NewRequest(http.MethodGet, "/health/alive", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
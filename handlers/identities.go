@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/identities"
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+)
+
+const (
+	// identityImportMaxErrors is the number of failed records listed on the page after an import
+	identityImportMaxErrors = 500
+
+	// IdentityUploadMaxBytes and identityUploadMaxRecords limit the files imported by upload, so the import
+	// finishes within the server's write timeout. Larger files are imported with the 'identities import' command.
+	// The size is enforced by the BodyLimitMiddleware, before the CSRF middleware reads the upload.
+	IdentityUploadMaxBytes   = 1 << 20
+	identityUploadMaxRecords = 200
+
+	// identityUploadTimeout stops an upload's import in time to show what was imported before the server's
+	// write timeout
+	identityUploadTimeout = 10 * time.Second
+)
+
+// IdentitiesParams configure the Identities and IdentitiesExport http handlers
+type IdentitiesParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// AdminIdentityIDs are the identities allowed to import and export identities
+	AdminIdentityIDs []string
+
+	// Importer creates the uploaded identities, DryRun is set from the form
+	Importer identities.Importer
+
+	// Exporter lists the identities downloaded, Traits are set from the query
+	Exporter identities.Exporter
+
+	// ListTimeout limits each call to Kratos listing identities to export, rather than the shorter timeout
+	// of calls made for pages
+	ListTimeout time.Duration
+
+	session.SessionStore
+}
+
+// Identities handler displays the form to upload identities to import, and posting it imports them. Only
+// admins may use it.
+func (ip IdentitiesParams) Identities(w http.ResponseWriter, r *http.Request) {
+	ks := ip.GetKratosSession(r)
+	if ks == nil || !isAdmin(ip.AdminIdentityIDs, ks.Identity.Id) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	dataMap := map[string]interface{}{
+		"title": "Identities",
+		"fs":    ip.FS,
+	}
+	if r.Method == http.MethodPost {
+		ip.importUpload(r, ks.Identity.Id, dataMap)
+	}
+	if err := GetTemplate(identitiesPage).Render("layout", w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// importUpload imports the uploaded file, adding the outcome to dataMap
+func (ip IdentitiesParams) importUpload(r *http.Request, adminID string, dataMap map[string]interface{}) {
+	file, header, err := r.FormFile("file")
+	if err == http.ErrMissingFile {
+		dataMap["uploadError"] = "Choose a file to import."
+		return
+	}
+	if errors.Is(err, middleware.ErrBodyTooLarge) {
+		dataMap["uploadError"] = fmt.Sprintf("Uploads are limited to %d KB, use the 'identities import' command for larger files.", IdentityUploadMaxBytes/1024)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading uploaded identities: %v", err)
+		dataMap["uploadError"] = "The upload could not be read, try again."
+		return
+	}
+	defer file.Close()
+	reader, err := identities.NewReader(file, identities.FormatOf(header.Filename))
+	if err != nil {
+		dataMap["uploadError"] = err.Error()
+		return
+	}
+	records, err := readUpload(reader)
+	if err != nil {
+		dataMap["uploadError"] = err.Error()
+		return
+	}
+
+	importer := ip.Importer
+	importer.DryRun = r.PostFormValue("dry_run") == "true"
+	ctx, cancel := context.WithTimeout(r.Context(), identityUploadTimeout)
+	defer cancel()
+	var failed []identities.Result
+	summary, err := importer.Import(ctx, records, func(res identities.Result) {
+		if res.Err != nil && len(failed) < identityImportMaxErrors {
+			failed = append(failed, res)
+		}
+	})
+	switch {
+	case errors.Is(err, context.DeadlineExceeded) && r.Context().Err() == nil:
+		log.Printf("Import of identities from '%s' timed out", header.Filename)
+		dataMap["uploadError"] = fmt.Sprintf("The import stopped after %v, use the 'identities import' command with a progress file to import the rest.", identityUploadTimeout)
+	case err != nil:
+		log.Printf("Error importing identities from '%s': %v", header.Filename, err)
+		dataMap["uploadError"] = fmt.Sprintf("The import stopped early: %v", err)
+	}
+	if !importer.DryRun {
+		outcome := audit.Success
+		if err != nil || summary.Failed > 0 {
+			outcome = audit.Failure
+		}
+		audit.Record(r, audit.IdentitiesImported, adminID, outcome,
+			fmt.Sprintf("%s: %d created, %d failed", header.Filename, summary.Created, summary.Failed))
+	}
+	dataMap["filename"] = header.Filename
+	dataMap["dryRun"] = importer.DryRun
+	dataMap["summary"] = summary
+	dataMap["failed"] = failed
+	dataMap["moreFailed"] = summary.Failed - len(failed)
+}
+
+// uploadedRecords are the records read from an upload, with the errors of those that couldn't be decoded
+type uploadedRecords struct {
+	records []identities.Record
+	errs    []error
+	next    int
+}
+
+// readUpload reads all the records in an upload, so a file that is too large is rejected before any are imported
+func readUpload(r identities.Reader) (*uploadedRecords, error) {
+	u := &uploadedRecords{}
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return u, nil
+		}
+		if _, ok := err.(*identities.RowError); err != nil && !ok {
+			return nil, err
+		}
+		if len(u.records) == identityUploadMaxRecords {
+			return nil, fmt.Errorf("uploads are limited to %d records, use the 'identities import' command for larger files", identityUploadMaxRecords)
+		}
+		u.records = append(u.records, rec)
+		u.errs = append(u.errs, err)
+	}
+}
+
+// Read returns the next record, see identities.Reader
+func (u *uploadedRecords) Read() (identities.Record, error) {
+	if u.next == len(u.records) {
+		return identities.Record{}, io.EOF
+	}
+	u.next++
+	return u.records[u.next-1], u.errs[u.next-1]
+}
+
+// IdentitiesExport handler downloads all identities, as JSON lines or CSV given by the 'format' query param,
+// with the traits in the optional comma separated 'traits' query param. Only admins may use it.
+func (ip IdentitiesParams) IdentitiesExport(w http.ResponseWriter, r *http.Request) {
+	ks := ip.GetKratosSession(r)
+	if ks == nil || !isAdmin(ip.AdminIdentityIDs, ks.Identity.Id) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = identities.JSONLines
+	}
+	exporter := ip.Exporter
+	exporter.Traits = identities.ParseTraits(r.URL.Query().Get("traits"))
+	if format == identities.CSV && len(exporter.Traits) == 0 && ip.Importer.Schemas != nil {
+		s, err := ip.Importer.Schemas(r.Context(), ip.Importer.DefaultSchemaID)
+		if err != nil {
+			log.Printf("Error loading identity schema '%s': %v", ip.Importer.DefaultSchemaID, err)
+			http.Error(w, "The identity schema could not be loaded, give the traits to export", http.StatusBadGateway)
+			return
+		}
+		exporter.Traits = identities.SchemaTraits(s)
+	}
+	records, err := identities.NewWriter(w, format, exporter.Traits)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	contentType := "application/x-ndjson"
+	if format == identities.CSV {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="identities.%s"`, format))
+	n, err := exporter.Export(api_client.WithTimeout(r.Context(), ip.ListTimeout), records)
+	outcome := audit.Success
+	if err != nil {
+		// The download has started, so it can only be cut short
+		log.Printf("Error exporting identities after %d: %v", n, err)
+		outcome = audit.Failure
+	}
+	audit.Record(r, audit.IdentitiesExported, ks.Identity.Id, outcome, fmt.Sprintf("%d identities as %s", n, format))
+}
@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/identities"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadUpload(t *testing.T) {
+	reader, err := identities.NewReader(strings.NewReader("{\"id\":\"a\"}\nnot json\n{\"id\":\"b\"}\n"), identities.JSONLines)
+	require.NoError(t, err)
+	records, err := readUpload(reader)
+	require.NoError(t, err)
+
+	// Records are read back in order, with the rows that couldn't be decoded
+	rec, err := records.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "a", rec.ID)
+	_, err = records.Read()
+	var rowErr *identities.RowError
+	assert.ErrorAs(t, err, &rowErr)
+	rec, err = records.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "b", rec.ID)
+	_, err = records.Read()
+	assert.Equal(t, io.EOF, err)
+
+	// Files with too many records are rejected
+	var b strings.Builder
+	for i := 0; i <= identityUploadMaxRecords; i++ {
+		fmt.Fprintf(&b, "{\"id\":\"%d\"}\n", i)
+	}
+	reader, err = identities.NewReader(strings.NewReader(b.String()), identities.JSONLines)
+	require.NoError(t, err)
+	_, err = readUpload(reader)
+	assert.EqualError(t, err, fmt.Sprintf("uploads are limited to %d records, use the 'identities import' command for larger files", identityUploadMaxRecords))
+}
@@ -0,0 +1,226 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/invites"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+)
+
+// InvitesParams configure the Invites http handler
+type InvitesParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// AdminIdentityIDs are the identities allowed to manage invites
+	AdminIdentityIDs []string
+
+	// Service creates and sends the invites
+	Service *invites.Service
+
+	session.SessionStore
+}
+
+// inviteRow is an invite listed on the invites page, with its current status
+type inviteRow struct {
+	invites.Invite
+	Status invites.Status
+}
+
+// Invites handler lists the invites, and posting to it creates, resends or revokes an invite, given by the
+// 'action' form value. Only admins may use it.
+func (ip InvitesParams) Invites(w http.ResponseWriter, r *http.Request) {
+	ks := ip.GetKratosSession(r)
+	if ks == nil || !isAdmin(ip.AdminIdentityIDs, ks.Identity.Id) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	ttl := ip.Service.TTL
+	if ttl <= 0 {
+		ttl = invites.DefaultTTL
+	}
+	dataMap := map[string]interface{}{
+		"title":       "Invitations",
+		"fs":          ip.FS,
+		"defaultDays": int(ttl.Hours() / 24),
+	}
+	if r.Method == http.MethodPost {
+		switch r.PostFormValue("action") {
+		case "create":
+			ip.create(r, ks.Identity.Id, dataMap)
+		case "resend":
+			ip.resend(r, ks.Identity.Id, dataMap)
+		case "revoke":
+			ip.revoke(r, ks.Identity.Id, dataMap)
+		default:
+			http.Error(w, "Unknown action", http.StatusBadRequest)
+			return
+		}
+	}
+
+	list, err := ip.Service.List()
+	if err != nil {
+		log.Printf("Error listing invites: %v", err)
+		dataMap["error"] = "The invitations could not be listed."
+	}
+	now := time.Now()
+	rows := make([]inviteRow, 0, len(list))
+	for _, inv := range list {
+		rows = append(rows, inviteRow{Invite: inv, Status: inv.Status(now)})
+	}
+	dataMap["invites"] = rows
+	if err := GetTemplate(invitesPage).Render("layout", w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// create creates the invite in the form, adding the outcome to dataMap
+func (ip InvitesParams) create(r *http.Request, adminID string, dataMap map[string]interface{}) {
+	email := strings.TrimSpace(r.PostFormValue("email"))
+	traits := map[string]interface{}{}
+	if s := strings.TrimSpace(r.PostFormValue("traits")); s != "" {
+		if err := json.Unmarshal([]byte(s), &traits); err != nil {
+			dataMap["error"] = "The traits must be a JSON object, e.g. {\"name\": {\"first\": \"Ann\"}}."
+			dataMap["form"] = r.PostForm
+			return
+		}
+	}
+	var ttl time.Duration
+	if s := r.PostFormValue("expires_in_days"); s != "" {
+		days, err := strconv.Atoi(s)
+		if err != nil || days < 1 {
+			dataMap["error"] = "The invitation must expire in a whole number of days."
+			dataMap["form"] = r.PostForm
+			return
+		}
+		ttl = time.Duration(days) * 24 * time.Hour
+	}
+
+	inv, link, err := ip.Service.Create(r.Context(), email, traits, ttl, adminID)
+	ip.outcome(r, audit.InviteCreated, adminID, inv, link, err, dataMap)
+	if err != nil && link == "" {
+		dataMap["form"] = r.PostForm
+	}
+}
+
+// resend sends a new link for the invite in the form, adding the outcome to dataMap
+func (ip InvitesParams) resend(r *http.Request, adminID string, dataMap map[string]interface{}) {
+	inv, link, err := ip.Service.Resend(r.Context(), r.PostFormValue("id"))
+	ip.outcome(r, audit.InviteResent, adminID, inv, link, err, dataMap)
+}
+
+// revoke revokes the invite in the form, adding the outcome to dataMap
+func (ip InvitesParams) revoke(r *http.Request, adminID string, dataMap map[string]interface{}) {
+	inv, err := ip.Service.Revoke(r.Context(), r.PostFormValue("id"))
+	ip.outcome(r, audit.InviteRevoked, adminID, inv, "", err, dataMap)
+	if err == nil {
+		dataMap["info"] = fmt.Sprintf("The invitation to %s has been revoked, and its identity deleted.", inv.Email)
+	}
+}
+
+// outcome records an invite action in the audit log, and adds its outcome to dataMap. The link sent is shown
+// so it can be given to the user another way, even if it was emailed.
+func (ip InvitesParams) outcome(r *http.Request, eventType, adminID string, inv invites.Invite, link string, err error, dataMap map[string]interface{}) {
+	outcome := audit.Success
+	switch {
+	case err == nil:
+		if link != "" {
+			dataMap["info"] = fmt.Sprintf("An invitation has been sent to %s.", inv.Email)
+		}
+	case errors.Is(err, invites.ErrNotSent):
+		log.Printf("Error emailing invite '%s': %v", inv.ID, err)
+		dataMap["error"] = fmt.Sprintf("The invitation to %s was saved, but the email could not be sent. Send them the link below, or resend it later.", inv.Email)
+		outcome = audit.Failure
+	case errors.Is(err, invites.ErrNotFound):
+		dataMap["error"] = "The invitation no longer exists."
+		return
+	case errors.Is(err, invites.ErrNotPending):
+		dataMap["error"] = fmt.Sprintf("The invitation to %s has already been %s.", inv.Email, inv.Status(time.Now()))
+		return
+	default:
+		log.Printf("Error managing invite for '%s': %v", r.PostFormValue("email"), err)
+		dataMap["error"] = err.Error()
+		if inv.ID == "" {
+			return
+		}
+		outcome = audit.Failure
+	}
+	if link != "" {
+		dataMap["link"] = link
+	}
+	audit.Record(r, eventType, adminID, outcome, fmt.Sprintf("invite %s for %s, identity %s", inv.ID, inv.Email, inv.IdentityID))
+}
+
+// InvitationParams configure the Invitation http handler
+type InvitationParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// Service accepts the invites
+	Service *invites.Service
+
+	// LoginURL is the page users who have already accepted are sent to
+	LoginURL string
+}
+
+// Invitation handler shows the invite with the token in the 'token' query param, and posting the token accepts
+// it, redirecting the user to a Kratos recovery link. That signs them in and takes them to their settings, to
+// choose a password.
+func (ip InvitationParams) Invitation(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if r.Method == http.MethodPost {
+		token = r.PostFormValue("token")
+	}
+	dataMap := map[string]interface{}{
+		"title":    "Accept your invitation",
+		"fs":       ip.FS,
+		"token":    token,
+		"loginURL": ip.LoginURL,
+	}
+
+	inv, err := ip.Service.Find(token)
+	switch {
+	case err != nil:
+		if err != invites.ErrNotFound {
+			log.Printf("Error finding invite: %v", err)
+		}
+		dataMap["status"] = "invalid"
+	case r.Method == http.MethodPost:
+		var link string
+		inv, link, err = ip.Service.Accept(r.Context(), token)
+		if err == nil {
+			audit.Record(r, audit.InviteAccepted, inv.IdentityID, audit.Success, "invite "+inv.ID)
+			http.Redirect(w, r, link, http.StatusSeeOther)
+			return
+		}
+		if err != invites.ErrNotPending {
+			log.Printf("Error accepting invite '%s': %v", inv.ID, err)
+			dataMap["error"] = "Your invitation could not be accepted just now, please try again."
+		}
+	}
+	dataMap["invite"] = inv
+	if _, ok := dataMap["status"]; !ok {
+		status, err := ip.Service.CurrentStatus(r.Context(), inv)
+		if err != nil {
+			log.Printf("Error checking invite '%s': %v", inv.ID, err)
+		}
+		dataMap["status"] = string(status)
+	}
+	status := http.StatusOK
+	if dataMap["status"] == "invalid" || dataMap["status"] == string(invites.Revoked) {
+		status = http.StatusNotFound
+	}
+	if err := GetTemplate(invitationPage).RenderStatus("layout", status, w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"log"
 	"net/http"
+	"net/url"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
@@ -26,8 +27,9 @@ func (lp LoginParams) Login(w http.ResponseWriter, r *http.Request) {
 	// Start the login flow with Kratos if required
 	flow := r.URL.Query().Get("flow")
 	if flow == "" {
-		log.Printf("No flow ID found in URL, initializing login flow, redirect to %s", lp.FlowRedirectURL)
-		http.Redirect(w, r, lp.FlowRedirectURL, http.StatusMovedPermanently)
+		redirect := loginFlowRedirect(lp.FlowRedirectURL, r.URL.Query())
+		log.Printf("No flow ID found in URL, initializing login flow, redirect to %s", redirect)
+		http.Redirect(w, r, redirect, http.StatusSeeOther)
 		return
 	}
 
@@ -43,19 +45,40 @@ func (lp LoginParams) Login(w http.ResponseWriter, r *http.Request) {
 
 	loginResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceLoginFlow(r.Context()).Id(flow).Cookie(r.Header.Get("Cookie")).Execute()
 	if err != nil {
-		KratosErrorHandler(w, r, rawResp, err, lp.FlowRedirectURL)
+		KratosErrorHandler(w, r, lp.FS, rawResp, err, lp.FlowRedirectURL)
 		return
 	}
 
 	dataMap := map[string]interface{}{
 		"title":           "Sign in",
 		"resp":            loginResp,
-		"isAuthenticated": *loginResp.Refresh || loginResp.RequestedAal == kratos.AUTHENTICATORASSURANCELEVEL_AAL2.Ptr(),
+		"isAuthenticated": loginResp.GetRefresh() || loginResp.GetRequestedAal() == kratos.AUTHENTICATORASSURANCELEVEL_AAL2,
 		"registrationURL": lp.RegistrationURL,
 		"logoutURL":       logoutURL,
+		"webauthnOptions": webauthnLoginOptions(loginResp.Ui.Nodes),
 		"fs":              lp.FS,
 	}
 	if err = GetTemplate(loginPage).Render("layout", w, r, dataMap); err != nil {
 		TemplateErrorHandler(w, r, err)
 	}
 }
+
+// loginFlowParams are the query params passed on to Kratos when starting a login flow
+var loginFlowParams = []string{"aal", "refresh", "return_to"}
+
+// loginFlowRedirect returns the URL that starts the login flow, passing on the params from query
+// that ask for a second factor, re-authentication or where to return to afterwards
+func loginFlowRedirect(flowURL string, query url.Values) string {
+	u, err := url.Parse(flowURL)
+	if err != nil {
+		return flowURL
+	}
+	q := u.Query()
+	for _, name := range loginFlowParams {
+		if v := query.Get(name); v != "" {
+			q.Set(name, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
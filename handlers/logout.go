@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+)
+
+// LogoutParams configure the Logout http handler
+type LogoutParams struct {
+	// LoginURL is where we redirect to if there is no Kratos session to end
+	LoginURL string
+
+	session.SessionStore
+}
+
+// Logout handler forgets the session held by this app, then sends the browser to Kratos to end it.
+// It only accepts POSTs, which are protected by the CSRF middleware, so other sites can't log users out.
+func (lp LogoutParams) Logout(w http.ResponseWriter, r *http.Request) {
+	logoutURL := browserLogoutURL(r)
+	if err := lp.ClearKratosSession(w, r); err != nil {
+		log.Printf("Error clearing kratos session: %v", err)
+	}
+	if logoutURL == "" {
+		http.Redirect(w, r, lp.LoginURL, http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, logoutURL, http.StatusSeeOther)
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"time"
+
+	"github.com/benbjohnson/hashfs"
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// Kratos identifiers in the 'lookup_secret' settings group
+	lookupSecretCodesNodeID      = "lookup_secret_codes"
+	lookupSecretConfirmNodeName  = "lookup_secret_confirm"
+	lookupSecretUsedTextID       = 1050014
+	lookupSecretsDownloadName    = "backup-recovery-codes.txt"
+	lookupSecretsSettingsSection = "lookup_secret"
+)
+
+// LookupSecretsParams configure the http handlers that let the user save their
+// lookup secrets (backup recovery codes) while they are revealed in a settings flow
+type LookupSecretsParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+
+	// FlowRedirectURL is the kratos URL to redirect the browser to,
+	// when the 'flow' query param is missing or the flow has expired
+	FlowRedirectURL string
+
+	// SettingsURL is the URL of the settings page
+	SettingsURL string
+
+	session.SessionStore
+}
+
+// lookupSecretSettings holds the nodes of the 'lookup_secret' settings group, so the
+// confirm button can be held back until the user has saved the codes
+type lookupSecretSettings struct {
+	// FlowID is the settings flow the codes were revealed in
+	FlowID string
+
+	// Codes are the revealed codes, empty unless they have just been (re)generated or revealed
+	Codes []textSecret
+
+	// Confirm is the button that saves newly generated codes
+	Confirm *kratos.UiNode
+
+	// Saved is true if the codes have been downloaded or printed
+	Saved bool
+
+	// Other holds the rest of the group's nodes
+	Other []kratos.UiNode
+}
+
+// newLookupSecretSettings picks the 'lookup_secret' group nodes out of a settings flow
+func newLookupSecretSettings(flow *kratos.SelfServiceSettingsFlow, saved bool) lookupSecretSettings {
+	l := lookupSecretSettings{FlowID: flow.Id, Saved: saved}
+	for i := range flow.Ui.Nodes {
+		n := flow.Ui.Nodes[i]
+		if n.Group != lookupSecretsSettingsSection {
+			continue
+		}
+		if n.Attributes.UiNodeInputAttributes != nil && n.Attributes.UiNodeInputAttributes.Name == lookupSecretConfirmNodeName {
+			l.Confirm = &n
+			continue
+		}
+		if n.Attributes.UiNodeTextAttributes != nil && n.Attributes.UiNodeTextAttributes.Id == lookupSecretCodesNodeID {
+			l.Codes = textSecretsFromNode(n)
+		}
+		l.Other = append(l.Other, n)
+	}
+	return l
+}
+
+// Download handler returns the revealed codes as a text file
+func (lp LookupSecretsParams) Download(w http.ResponseWriter, r *http.Request) {
+	flow, codes, ok := lp.revealedCodes(w, r)
+	if !ok {
+		return
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Backup recovery codes\r\n")
+	fmt.Fprintf(&b, "Generated %s\r\n\r\n", time.Now().Format("2 Jan 2006 15:04 MST"))
+	fmt.Fprintf(&b, "Each code can be used once to sign in if you lose access to your second factor.\r\n")
+	fmt.Fprintf(&b, "Keep them somewhere safe.\r\n\r\n")
+	for _, c := range codes {
+		fmt.Fprintf(&b, "%s\r\n", c.Text)
+	}
+
+	if err := lp.SetLookupSecretsSaved(w, r, flow.Id); err != nil {
+		log.Printf("Error recording lookup secrets saved: %v", err)
+	}
+	setSecretResponseHeaders(w)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", lookupSecretsDownloadName))
+	w.Write(b.Bytes())
+}
+
+// Print handler displays the revealed codes on a print friendly page
+func (lp LookupSecretsParams) Print(w http.ResponseWriter, r *http.Request) {
+	flow, codes, ok := lp.revealedCodes(w, r)
+	if !ok {
+		return
+	}
+	if err := lp.SetLookupSecretsSaved(w, r, flow.Id); err != nil {
+		log.Printf("Error recording lookup secrets saved: %v", err)
+	}
+	setSecretResponseHeaders(w)
+
+	dataMap := map[string]interface{}{
+		"title":       "Backup recovery codes",
+		"codes":       codes,
+		"generatedAt": time.Now(),
+		"settingsURL": lp.settingsFlowURL(flow.Id),
+		"fs":          lp.FS,
+	}
+	if err := GetTemplate(lookupSecretsPrintPage).Render("layout", w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// revealedCodes fetches the settings flow and returns its unused codes. If they are not
+// revealed the browser is sent back to the settings page, and ok is false.
+func (lp LookupSecretsParams) revealedCodes(w http.ResponseWriter, r *http.Request) (flow *kratos.SelfServiceSettingsFlow, codes []textSecret, ok bool) {
+	flowID := r.URL.Query().Get("flow")
+	if flowID == "" {
+		http.Redirect(w, r, lp.FlowRedirectURL, http.StatusSeeOther)
+		return nil, nil, false
+	}
+
+	flow, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceSettingsFlow(r.Context()).Id(flowID).Cookie(r.Header.Get("Cookie")).Execute()
+	if err != nil {
+		KratosErrorHandler(w, r, lp.FS, rawResp, err, lp.FlowRedirectURL)
+		return nil, nil, false
+	}
+
+	for _, c := range newLookupSecretSettings(flow, false).Codes {
+		if c.Id != lookupSecretUsedTextID {
+			codes = append(codes, c)
+		}
+	}
+	if len(codes) == 0 {
+		http.Redirect(w, r, lp.settingsFlowURL(flow.Id), http.StatusSeeOther)
+		return nil, nil, false
+	}
+	return flow, codes, true
+}
+
+// settingsFlowURL returns the URL of the lookup secret section of the settings page for a flow
+func (lp LookupSecretsParams) settingsFlowURL(flowID string) string {
+	return fmt.Sprintf("%s?flow=%s#%s", lp.SettingsURL, url.QueryEscape(flowID), lookupSecretsSettingsSection)
+}
+
+// setSecretResponseHeaders stops a response containing secrets from being cached, sniffed, or leaked via the referrer
+func setSecretResponseHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", "no-store, max-age=0")
+	w.Header().Set("Pragma", "no-cache")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+}
+
+// textSecretsFromNode attempts to parse UI node text context into text secrets suitable for templates
+// See the type textSecret for field names
+func textSecretsFromNode(node kratos.UiNode) []textSecret {
+	secrets := node.Attributes.UiNodeTextAttributes.Text.Context["secrets"]
+	v := reflect.ValueOf(secrets)
+	ts := []textSecret{}
+
+	if v.Kind() == reflect.Slice {
+		for i := 0; i < v.Len(); i++ {
+			m := v.Index(i).Interface().(map[string]interface{})
+			newTs := textSecret{}
+			newTs.marshal(m)
+			ts = append(ts, newTs)
+		}
+		return ts
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+
+	kratos "github.com/ory/kratos-client-go"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// Kratos node names in the 'oidc' group
+	oidcProviderNodeName = "provider"
+	oidcLinkNodeName     = "link"
+	oidcUnlinkNodeName   = "unlink"
+
+	// defaultProviderColour is used for providers that are not registered
+	defaultProviderColour = "#4a4a4a"
+)
+
+// OIDCProvider describes how a social sign in provider is presented
+type OIDCProvider struct {
+	// ID is the provider id configured in Kratos, e.g. "github"
+	ID string `yaml:"id"`
+
+	// DisplayName is shown on buttons, e.g. "GitHub"
+	DisplayName string `yaml:"display_name"`
+
+	// Icon is the URL of an icon, e.g. "https://example.com/corp.svg", or the path of one of the app's static
+	// files, e.g. "static/images/oidc/github.svg". If it is empty the first letter of the DisplayName is shown
+	// instead. Icons are shown on the brand colour, so should be white.
+	Icon string `yaml:"icon"`
+
+	// Colour is the brand colour used for the button background, as a hex colour e.g. "#24292f"
+	Colour string `yaml:"colour"`
+}
+
+// IconURL returns the URL of the icon, or "" if there isn't one. Static files are under the base path.
+func (p OIDCProvider) IconURL() string {
+	if strings.HasPrefix(p.Icon, "static/") {
+		return AppPath(p.Icon)
+	}
+	return p.Icon
+}
+
+// Initial returns the letter shown when there is no icon
+func (p OIDCProvider) Initial() string {
+	for _, r := range p.DisplayName {
+		return strings.ToUpper(string(r))
+	}
+	return "?"
+}
+
+// ColourStyle returns the CSS declaring the brand colour, for use in a style attribute
+func (p OIDCProvider) ColourStyle() template.CSS {
+	colour := p.Colour
+	if !hexColour.MatchString(colour) {
+		colour = defaultProviderColour
+	}
+	return template.CSS("--provider-colour: " + colour)
+}
+
+var (
+	hexColour = regexp.MustCompile(`^#[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+	oidcProvidersMu sync.RWMutex
+	oidcProviders   = map[string]OIDCProvider{
+		"apple": {ID: "apple", DisplayName: "Apple", Icon: "static/images/oidc/apple.svg", Colour: "#000000"},
+		// Auth0 has no icon in the Bootstrap Icons set, so shows its initial
+		"auth0":     {ID: "auth0", DisplayName: "Auth0", Colour: "#eb5424"},
+		"discord":   {ID: "discord", DisplayName: "Discord", Icon: "static/images/oidc/discord.svg", Colour: "#5865f2"},
+		"facebook":  {ID: "facebook", DisplayName: "Facebook", Icon: "static/images/oidc/facebook.svg", Colour: "#1877f2"},
+		"github":    {ID: "github", DisplayName: "GitHub", Icon: "static/images/oidc/github.svg", Colour: "#24292f"},
+		"gitlab":    {ID: "gitlab", DisplayName: "GitLab", Icon: "static/images/oidc/gitlab.svg", Colour: "#fc6d26"},
+		"google":    {ID: "google", DisplayName: "Google", Icon: "static/images/oidc/google.svg", Colour: "#4285f4"},
+		"microsoft": {ID: "microsoft", DisplayName: "Microsoft", Icon: "static/images/oidc/microsoft.svg", Colour: "#2f2f2f"},
+		"slack":     {ID: "slack", DisplayName: "Slack", Icon: "static/images/oidc/slack.svg", Colour: "#4a154b"},
+		"spotify":   {ID: "spotify", DisplayName: "Spotify", Icon: "static/images/oidc/spotify.svg", Colour: "#1db954"},
+	}
+)
+
+// LoadOIDCProviders registers the providers listed in the YAML file at path, adding to or replacing the
+// built in providers
+func LoadOIDCProviders(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var providers []OIDCProvider
+	if err := yaml.Unmarshal(b, &providers); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	for i, p := range providers {
+		if strings.TrimSpace(p.ID) == "" {
+			return fmt.Errorf("%s: provider %d has no 'id'", path, i+1)
+		}
+		if p.DisplayName == "" {
+			p.DisplayName = GetOIDCProvider(p.ID).DisplayName
+		}
+		if p.Colour != "" && !hexColour.MatchString(p.Colour) {
+			return fmt.Errorf("%s: provider '%s' has an invalid 'colour' '%s', give a hex colour e.g. #0b5394", path, p.ID, p.Colour)
+		}
+		RegisterOIDCProvider(p)
+	}
+	return nil
+}
+
+// RegisterOIDCProvider adds or replaces how a provider is presented
+func RegisterOIDCProvider(p OIDCProvider) {
+	oidcProvidersMu.Lock()
+	defer oidcProvidersMu.Unlock()
+	oidcProviders[p.ID] = p
+}
+
+// GetOIDCProvider returns how the provider with id is presented. Unregistered providers
+// get a display name derived from their id.
+func GetOIDCProvider(id string) OIDCProvider {
+	oidcProvidersMu.RLock()
+	p, ok := oidcProviders[id]
+	oidcProvidersMu.RUnlock()
+	if ok {
+		return p
+	}
+	name := strings.NewReplacer("_", " ", "-", " ").Replace(id)
+	if name != "" {
+		name = strings.ToUpper(name[:1]) + name[1:]
+	}
+	return OIDCProvider{ID: id, DisplayName: name, Colour: defaultProviderColour}
+}
+
+// isOIDCButton reports if the node is one of the oidc group's provider buttons
+func isOIDCButton(node kratos.UiNode) bool {
+	attrs := node.Attributes.UiNodeInputAttributes
+	if node.Group != "oidc" || attrs == nil || attrs.Type != "submit" {
+		return false
+	}
+	switch attrs.Name {
+	case oidcProviderNodeName, oidcLinkNodeName, oidcUnlinkNodeName:
+		return true
+	}
+	return false
+}
+
+// oidcNodeProvider returns the provider of an oidc button node
+func oidcNodeProvider(node kratos.UiNode) OIDCProvider {
+	id, _ := node.Attributes.UiNodeInputAttributes.Value.(string)
+	return GetOIDCProvider(id)
+}
+
+// oidcButtonLabel returns the Kratos label of an oidc button, e.g. "Sign in with github", with
+// the provider id replaced by its display name
+func oidcButtonLabel(node kratos.UiNode) string {
+	p := oidcNodeProvider(node)
+	label := ""
+	if node.Meta.Label != nil {
+		label = node.Meta.Label.Text
+	}
+	if label == "" {
+		return p.DisplayName
+	}
+	return strings.Replace(label, p.ID, p.DisplayName, 1)
+}
+
+// oidcSettings lists the providers that can be linked to or unlinked from an account
+type oidcSettings struct {
+	Linked    []oidcSettingsProvider
+	Available []oidcSettingsProvider
+}
+
+// oidcSettingsProvider is a provider and the button that links or unlinks it
+type oidcSettingsProvider struct {
+	OIDCProvider
+	Button kratos.UiNode
+}
+
+// newOIDCSettings derives which providers are linked from the 'oidc' group nodes of a settings flow,
+// Kratos offers to unlink linked providers and to link the others
+func newOIDCSettings(nodes []kratos.UiNode) oidcSettings {
+	var s oidcSettings
+	for _, n := range nodes {
+		if !isOIDCButton(n) {
+			continue
+		}
+		p := oidcSettingsProvider{OIDCProvider: oidcNodeProvider(n), Button: n}
+		switch n.Attributes.UiNodeInputAttributes.Name {
+		case oidcUnlinkNodeName:
+			s.Linked = append(s.Linked, p)
+		case oidcLinkNodeName:
+			s.Available = append(s.Available, p)
+		}
+	}
+	return s
+}
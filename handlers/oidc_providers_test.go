@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// oidcButton returns an oidc group button named name for the provider id
+func oidcButton(name, id string) kratos.UiNode {
+	attrs := kratos.NewUiNodeInputAttributes(false, name, "input", "submit")
+	attrs.Value = id
+	return kratos.UiNode{Type: "input", Group: "oidc", Attributes: kratos.UiNodeInputAttributesAsUiNodeAttributes(attrs)}
+}
+
+func TestOIDCSettingsIcons(t *testing.T) {
+	RegisterOIDCProvider(OIDCProvider{ID: "corp", DisplayName: "Corp", Icon: "https://example.com/corp.svg", Colour: "#123456"})
+
+	s := newOIDCSettings([]kratos.UiNode{
+		oidcButton(oidcUnlinkNodeName, "corp"),
+		oidcButton(oidcLinkNodeName, "github"),
+		oidcButton(oidcLinkNodeName, "acme"),
+	})
+	require.Len(t, s.Linked, 1)
+	require.Len(t, s.Available, 2)
+
+	var b bytes.Buffer
+	require.NoError(t, GetTemplate(settingsPage).tmpl.ExecuteTemplate(&b, "oidc", s))
+
+	// Providers with an icon show it, in the table as well as on their button, others show their initial
+	assert.Equal(t, 2, bytes.Count(b.Bytes(), []byte(`src="https://example.com/corp.svg"`)))
+	assert.Equal(t, 2, bytes.Count(b.Bytes(), []byte(`src="/static/images/oidc/github.svg"`)))
+	assert.Equal(t, 2, bytes.Count(b.Bytes(), []byte(`aria-hidden="true">A</span>`)))
+}
+
+func TestBuiltInOIDCProviderIcons(t *testing.T) {
+	for id, p := range oidcProviders {
+		if !strings.HasPrefix(p.Icon, "static/") {
+			continue
+		}
+		_, err := os.Stat(filepath.Join("..", p.Icon))
+		assert.NoError(t, err, "icon of %s", id)
+	}
+}
+
+func TestLoadOIDCProviders(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return path
+	}
+
+	require.NoError(t, LoadOIDCProviders(write("providers.yml", `
+- id: acme-sso
+  display_name: Acme SSO
+  icon: https://example.com/acme.svg
+  colour: "#0b5394"
+- id: keycloak
+`)))
+	assert.Equal(t, OIDCProvider{ID: "acme-sso", DisplayName: "Acme SSO", Icon: "https://example.com/acme.svg", Colour: "#0b5394"},
+		GetOIDCProvider("acme-sso"))
+	assert.Equal(t, "Keycloak", GetOIDCProvider("keycloak").DisplayName)
+
+	assert.Error(t, LoadOIDCProviders(write("no-id.yml", "- display_name: Nameless\n")))
+	assert.Error(t, LoadOIDCProviders(write("colour.yml", "- id: x\n  colour: blue\n")))
+	assert.Error(t, LoadOIDCProviders(filepath.Join(dir, "missing.yml")))
+}
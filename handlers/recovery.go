@@ -2,9 +2,12 @@ package handlers
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/challenge"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
 )
 
 // RecoveryParams configure the Login http handler
@@ -15,6 +18,13 @@ type RecoveryParams struct {
 	// FlowRedirectURL is the kratos URL to redirect the browser to,
 	// when the user wishes to login, and the 'flow' query param is missing
 	FlowRedirectURL string
+
+	// Challenge asks clients that request recovery repeatedly to prove they are human. If set the form is
+	// posted to this app, and only submitted to Kratos once the challenge is completed. Kratos has no hook
+	// before the recovery email is sent, so requests posted to Kratos directly are not checked. Optional.
+	Challenge *challenge.Guard
+
+	session.SessionStore
 }
 
 // Login handler displays the login screen
@@ -23,22 +33,44 @@ func (rp RecoveryParams) Recovery(w http.ResponseWriter, r *http.Request) {
 	// Start the recovery flow with Kratos if required
 	flow := r.URL.Query().Get("flow")
 	if flow == "" {
-		http.Redirect(w, r, rp.FlowRedirectURL, http.StatusMovedPermanently)
+		http.Redirect(w, r, rp.FlowRedirectURL, http.StatusSeeOther)
 		return
 	}
 
 	recoveryResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceRecoveryFlow(r.Context()).Id(flow).Cookie(r.Header.Get("Cookie")).Execute()
 	if err != nil {
-		KratosErrorHandler(w, r, rawResp, err, rp.FlowRedirectURL)
+		KratosErrorHandler(w, r, rp.FS, rawResp, err, rp.FlowRedirectURL)
 		return
 	}
 
+	status := http.StatusOK
+	if rp.Challenge != nil && r.Method == http.MethodPost {
+		problem := checkChallenge(r, rp.Challenge)
+		if problem == nil {
+			rp.Challenge.Submitted(r, flow)
+			// 307 has the browser post the same form to Kratos
+			http.Redirect(w, r, recoveryResp.Ui.Action, http.StatusTemporaryRedirect)
+			return
+		}
+		recoveryResp.Ui.Messages = append(recoveryResp.Ui.Messages, *problem)
+		status = http.StatusBadRequest
+	} else if rp.Challenge != nil {
+		// Kratos doesn't reveal if the address is known, so every request counts towards the challenge
+		rp.Challenge.Returned(flow, true)
+	}
+
 	dataMap := map[string]interface{}{
 		"title": "Recover account",
 		"resp":  recoveryResp,
 		"fs":    rp.FS,
 	}
-	if err = GetTemplate(recoveryPage).Render("layout", w, r, dataMap); err != nil {
+	if rp.Challenge != nil {
+		// The form is posted here first, then on to Kratos
+		recoveryResp.Ui.Action = AppPath("recovery") + "?flow=" + url.QueryEscape(flow)
+		dataMap["gated"] = true
+		addChallenge(r, rp.Challenge, dataMap)
+	}
+	if err = GetTemplate(recoveryPage).RenderStatus("layout", status, w, r, dataMap); err != nil {
 		TemplateErrorHandler(w, r, err)
 	}
 }
@@ -1,12 +1,22 @@
 package handlers
 
 import (
+	"log"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/challenge"
+	"github.com/davidoram/kratos-selfservice-ui-go/registration"
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	kratos "github.com/ory/kratos-client-go"
 )
 
+// inviteCodeField is the registration form field holding the invite code, it is not sent to Kratos as a trait
+const inviteCodeField = "invite_code"
+
 // RegistrationParams configure the Login http handler
 type RegistrationParams struct {
 	// FS provides access to static files
@@ -16,30 +26,139 @@ type RegistrationParams struct {
 	// when the user wishes to login, and the 'flow' query param is missing
 	FlowRedirectURL string
 	LoginURL        string
+
+	// Schemas loads the identity schema used to render the trait inputs
+	Schemas *schema.Loader
+
+	// IdentitySchemaID is the id of the schema new identities are created with
+	IdentitySchemaID string
+
+	// Gate checks registrations against the registration policies. If set the form is posted to this app,
+	// and only submitted to Kratos if it is allowed. Optional.
+	Gate *registration.Gate
+
+	// Challenge asks clients that fail to register repeatedly to prove they are human. If set the form is
+	// posted to this app, and only submitted to Kratos once the challenge is completed. Optional.
+	Challenge *challenge.Guard
 }
 
 // Login handler displays the login screen
 func (rp RegistrationParams) Registration(w http.ResponseWriter, r *http.Request) {
+	var policy registration.Policy
+	if rp.Gate != nil {
+		policy = rp.Gate.Policy(r.Host)
+	}
+	if policy.Disabled {
+		rp.renderDisabled(w, r, policy)
+		return
+	}
+
 	// Start the registration flow with Kratos if required
 	flow := r.URL.Query().Get("flow")
 	if flow == "" {
-		http.Redirect(w, r, rp.FlowRedirectURL, http.StatusMovedPermanently)
+		http.Redirect(w, r, rp.FlowRedirectURL, http.StatusSeeOther)
 		return
 	}
 
 	registrationResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceRegistrationFlow(r.Context()).Id(flow).Cookie(r.Header.Get("Cookie")).Execute()
 	if err != nil {
-		KratosErrorHandler(w, r, rawResp, err, rp.FlowRedirectURL)
+		KratosErrorHandler(w, r, rp.FS, rawResp, err, rp.FlowRedirectURL)
 		return
 	}
 
+	gated := rp.Gate != nil || rp.Challenge != nil
+	status := http.StatusOK
+	switch {
+	case gated && r.Method == http.MethodPost:
+		problems := rp.admit(r, flow)
+		if len(problems) == 0 {
+			// 307 has the browser post the same form to Kratos
+			http.Redirect(w, r, registrationResp.Ui.Action, http.StatusTemporaryRedirect)
+			return
+		}
+		registrationResp.Ui.Messages = append(registrationResp.Ui.Messages, problems...)
+		keepValues(registrationResp.Ui.Nodes, r.PostForm)
+		status = http.StatusBadRequest
+	case rp.Challenge != nil:
+		rp.Challenge.Returned(flow, flowErrors(registrationResp.Ui) != "")
+	}
+
 	dataMap := map[string]interface{}{
 		"title":     "Create account",
 		"resp":      registrationResp,
 		"signInUrl": rp.LoginURL,
+		"schema":    loadSchema(r, rp.Schemas, rp.IdentitySchemaID),
 		"fs":        rp.FS,
 	}
-	if err = GetTemplate(registrationPage).Render("layout", w, r, dataMap); err != nil {
+	if gated {
+		// The form is posted here first, then on to Kratos
+		registrationResp.Ui.Action = AppPath("registration") + "?flow=" + url.QueryEscape(flow)
+		dataMap["gated"] = true
+		dataMap["policy"] = policy
+		dataMap["inviteCode"] = r.PostFormValue(inviteCodeField)
+	}
+	if rp.Challenge != nil {
+		addChallenge(r, rp.Challenge, dataMap)
+	}
+	if err = GetTemplate(registrationPage).RenderStatus("layout", status, w, r, dataMap); err != nil {
 		TemplateErrorHandler(w, r, err)
 	}
 }
+
+// admit checks a posted registration form against the challenge and the policies, returning the messages to
+// show if it can't be submitted to Kratos
+func (rp RegistrationParams) admit(r *http.Request, flow string) []kratos.UiText {
+	if rp.Challenge != nil {
+		if problem := checkChallenge(r, rp.Challenge); problem != nil {
+			return []kratos.UiText{*problem}
+		}
+	}
+	if rp.Gate != nil {
+		problems, err := rp.Gate.Admit(r.Host, flow, registration.Submission{
+			Email:      r.PostFormValue("traits.email"),
+			InviteCode: r.PostFormValue(inviteCodeField),
+		})
+		if err != nil {
+			log.Printf("Error checking registration against the policy: %v", err)
+			problems = []registration.Problem{{ID: registration.ProblemCodeInvalid, Text: "Your invite code couldn't be checked just now, please try again."}}
+		}
+		if len(problems) > 0 {
+			messages := make([]kratos.UiText, 0, len(problems))
+			for _, p := range problems {
+				messages = append(messages, kratos.UiText{Id: p.ID, Text: p.Text, Type: "error"})
+			}
+			return messages
+		}
+	}
+	if rp.Challenge != nil {
+		rp.Challenge.Submitted(r, flow)
+	}
+	return nil
+}
+
+// renderDisabled shows the page saying registration is disabled
+func (rp RegistrationParams) renderDisabled(w http.ResponseWriter, r *http.Request, policy registration.Policy) {
+	dataMap := map[string]interface{}{
+		"title":           "Create account",
+		"signInUrl":       rp.LoginURL,
+		"disabled":        true,
+		"disabledMessage": registration.DisabledMessage(policy),
+		"fs":              rp.FS,
+	}
+	if err := GetTemplate(registrationPage).RenderStatus("layout", http.StatusForbidden, w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
+
+// keepValues sets the trait inputs to the values posted, so the user doesn't have to enter them again
+func keepValues(nodes []kratos.UiNode, form url.Values) {
+	for i := range nodes {
+		attrs := nodes[i].Attributes.UiNodeInputAttributes
+		if attrs == nil || !strings.HasPrefix(attrs.Name, "traits.") {
+			continue
+		}
+		if v, ok := form[attrs.Name]; ok && len(v) > 0 {
+			attrs.Value = v[0]
+		}
+	}
+}
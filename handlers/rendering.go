@@ -7,6 +7,8 @@ import (
 	"io"
 	"log"
 	"net/http"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
 )
 
 // Template wraps an html/template
@@ -45,27 +47,32 @@ func GetTemplate(name TemplateName) Template {
 
 // Render executes the template 'name' passing dataMap
 func (t Template) Render(name string, w http.ResponseWriter, r *http.Request, dataMap map[string]interface{}) error {
+	return t.RenderStatus(name, http.StatusOK, w, r, dataMap)
+}
+
+// RenderStatus executes the template 'name' passing dataMap, responding with status
+func (t Template) RenderStatus(name string, status int, w http.ResponseWriter, r *http.Request, dataMap map[string]interface{}) error {
 	log.Printf("Render template: %s", t.tmpl.Name())
 
-	// Add common query params into the dataMap
-	dataMap["flash_info"] = r.URL.Query().Get("flash_info")
-	dataMap["flash_error"] = r.URL.Query().Get("flash_error")
+	// Flash messages are shown once, whichever page is rendered next
+	dataMap["flashes"] = flashMessages(w, r)
 
-	// Render to a buffer
+	// Forms posted to this app include the CSRF token, see csrfField
+	dataMap["csrfToken"] = middleware.CSRFToken(r)
+
+	// Render to a buffer, so nothing is written if the template fails and the caller can show an error page
 	var b bytes.Buffer
 	err := t.tmpl.ExecuteTemplate(&b, name, dataMap)
 	if err != nil {
-		log.Printf("Error executing template: %s\n", err)
-		http.Error(w, ErrRenderingPage, http.StatusInternalServerError)
 		return err
 	}
 
-	// Copy the buffer to the HTML writer
+	// Copy the buffer to the HTML writer. The response has started, so failures, usually the client going
+	// away, can only be logged.
+	w.WriteHeader(status)
 	size, err := io.Copy(w, &b)
 	if err != nil {
 		log.Printf("Error copying template: %s, bytes %d\n", err, size)
-		http.Error(w, ErrRenderingPage, http.StatusInternalServerError)
-		return err
 	}
 	return nil
 }
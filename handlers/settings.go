@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/benbjohnson/hashfs"
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	kratos "github.com/ory/kratos-client-go"
 )
 
 // SettingsParams configure the Login http handler
@@ -15,6 +19,16 @@ type SettingsParams struct {
 	// FlowRedirectURL is the kratos URL to redirect the browser to,
 	// when the user wishes to login, and the 'flow' query param is missing
 	FlowRedirectURL string
+
+	// Schemas loads the identity schema used to render the profile trait inputs
+	Schemas *schema.Loader
+
+	// LookupSecretsDownloadURL and LookupSecretsPrintURL are the pages that let the
+	// user save their backup recovery codes
+	LookupSecretsDownloadURL string
+	LookupSecretsPrintURL    string
+
+	session.SessionStore
 }
 
 // Login handler displays the login screen
@@ -23,22 +37,45 @@ func (sp SettingsParams) Settings(w http.ResponseWriter, r *http.Request) {
 	// Start the settings flow with Kratos if required
 	flow := r.URL.Query().Get("flow")
 	if flow == "" {
-		http.Redirect(w, r, sp.FlowRedirectURL, http.StatusMovedPermanently)
+		http.Redirect(w, r, sp.FlowRedirectURL, http.StatusSeeOther)
 		return
 	}
 
 	settingsResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceSettingsFlow(r.Context()).Id(flow).Cookie(r.Header.Get("Cookie")).Execute()
 	if err != nil {
-		KratosErrorHandler(w, r, rawResp, err, sp.FlowRedirectURL)
+		KratosErrorHandler(w, r, sp.FS, rawResp, err, sp.FlowRedirectURL)
 		return
 	}
 
+	// Once settings are saved the cached session no longer reflects the identity
+	if settingsResp.State == kratos.SELFSERVICESETTINGSFLOWSTATE_SUCCESS {
+		sp.Cache.InvalidateIdentity(settingsResp.Identity.Id)
+	}
+
 	dataMap := map[string]interface{}{
-		"title": "Account settings",
-		"resp":  settingsResp,
-		"fs":    sp.FS,
+		"title":  "Account settings",
+		"resp":   settingsResp,
+		"schema": loadSchema(r, sp.Schemas, settingsResp.Identity.SchemaId),
+		"totp":   newTotpSettings(settingsResp.Ui.Nodes),
+		"oidc":   newOIDCSettings(settingsResp.Ui.Nodes),
+		"fs":     sp.FS,
+
+		"webauthn": sp.webauthnSettings(r, settingsResp),
+
+		"lookupSecret":             newLookupSecretSettings(settingsResp, sp.LookupSecretsSaved(r, settingsResp.Id)),
+		"lookupSecretsDownloadURL": sp.LookupSecretsDownloadURL + "?flow=" + url.QueryEscape(settingsResp.Id),
+		"lookupSecretsPrintURL":    sp.LookupSecretsPrintURL + "?flow=" + url.QueryEscape(settingsResp.Id),
 	}
 	if err = GetTemplate(settingsPage).Render("layout", w, r, dataMap); err != nil {
 		TemplateErrorHandler(w, r, err)
 	}
 }
+
+// webauthnSettings returns the security key settings, along with when a key was last used
+func (sp SettingsParams) webauthnSettings(r *http.Request, flow *kratos.SelfServiceSettingsFlow) webauthnSettings {
+	s := newWebauthnSettings(flow.Ui.Nodes)
+	if len(s.Keys) > 0 {
+		s.LastUsed = lastWebauthnUse(r, flow.Identity.Id)
+	}
+	return s
+}
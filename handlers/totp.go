@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"strings"
+
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// Kratos node identifiers in the 'totp' settings group
+	totpQRNodeID        = "totp_qr"
+	totpSecretKeyNodeID = "totp_secret_key"
+	totpCodeNodeName    = "totp_code"
+	totpUnlinkNodeName  = "totp_unlink"
+
+	// totpSecretGroupSize is the number of characters per group when displaying the secret key
+	totpSecretGroupSize = 4
+)
+
+// totpSettings holds the nodes of the 'totp' settings group, picked apart so that the
+// enrollment steps can be rendered with a dedicated partial
+type totpSettings struct {
+	// QRSrc is the data URL of the QR code image, set while enrolling
+	QRSrc string
+
+	// SecretKey is the secret to enter manually if the QR code can't be scanned, and
+	// SecretGroups the same secret split into groups for readability
+	SecretKey    string
+	SecretGroups []string
+
+	// Code is the input for the verification code, and Submit the button that submits it
+	Code   *kratos.UiNode
+	Submit *kratos.UiNode
+
+	// Unlink is the button that removes the authenticator app, set when one is linked
+	Unlink *kratos.UiNode
+
+	// Other holds any nodes in the group we don't know about, they are rendered generically
+	Other []kratos.UiNode
+}
+
+// IsLinked reports if an authenticator app is linked to the account
+func (t totpSettings) IsLinked() bool {
+	return t.Unlink != nil
+}
+
+// newTotpSettings picks the 'totp' group nodes out of a settings flow
+func newTotpSettings(nodes []kratos.UiNode) totpSettings {
+	var t totpSettings
+	for i := range nodes {
+		n := nodes[i]
+		if n.Group != "totp" {
+			continue
+		}
+		switch {
+		case n.Attributes.UiNodeImageAttributes != nil && n.Attributes.UiNodeImageAttributes.Id == totpQRNodeID:
+			t.QRSrc = n.Attributes.UiNodeImageAttributes.Src
+		case n.Attributes.UiNodeTextAttributes != nil && n.Attributes.UiNodeTextAttributes.Id == totpSecretKeyNodeID:
+			t.SecretKey = n.Attributes.UiNodeTextAttributes.Text.Text
+			t.SecretGroups = groupChars(t.SecretKey, totpSecretGroupSize)
+		case n.Attributes.UiNodeInputAttributes != nil && n.Attributes.UiNodeInputAttributes.Name == totpCodeNodeName:
+			t.Code = &n
+		case n.Attributes.UiNodeInputAttributes != nil && n.Attributes.UiNodeInputAttributes.Name == totpUnlinkNodeName:
+			t.Unlink = &n
+		case n.Attributes.UiNodeInputAttributes != nil && n.Attributes.UiNodeInputAttributes.Type == "submit":
+			t.Submit = &n
+		default:
+			t.Other = append(t.Other, n)
+		}
+	}
+	return t
+}
+
+// groupChars splits s into groups of size characters, ignoring any whitespace in s
+func groupChars(s string, size int) []string {
+	s = strings.Join(strings.Fields(s), "")
+	var groups []string
+	for len(s) > size {
+		groups = append(groups, s[:size])
+		s = s[size:]
+	}
+	if s != "" {
+		groups = append(groups, s)
+	}
+	return groups
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// traitNodePrefix prefixes the names of the input nodes that hold identity traits
+	traitNodePrefix = "traits."
+)
+
+// loadSchema returns the identity schema with id, or nil if there is no loader or it cannot be loaded,
+// in which case forms are rendered from the nodes alone
+func loadSchema(r *http.Request, loader *schema.Loader, id string) *schema.Schema {
+	if loader == nil || id == "" {
+		return nil
+	}
+	s, err := loader.Get(r.Context(), id)
+	if err != nil {
+		log.Printf("Error loading identity schema '%s': %v", id, err)
+		return nil
+	}
+	return s
+}
+
+// uiNodeItem is an entry in a form, either a single node or a fieldset grouping several
+// trait nodes. Trait nodes that are plain inputs also carry the schema property describing them.
+type uiNodeItem struct {
+	Node kratos.UiNode
+
+	// Property describes a trait input node, it is nil for other nodes
+	Property *schema.Property
+
+	// Options are the choices for a trait restricted to an enum, rendered as a select
+	Options []selectOption
+
+	// Legend and Items are set for a fieldset
+	Legend string
+	Items  []uiNodeItem
+}
+
+// selectOption is an option of a select input
+type selectOption struct {
+	Value    string
+	Selected bool
+}
+
+// IsFieldset reports if the item groups other items
+func (i uiNodeItem) IsFieldset() bool {
+	return i.Items != nil
+}
+
+// arrangeNodes returns the nodes as form items. If there is an identity schema, the trait nodes are
+// ordered and grouped as the schema describes, and placed where the first trait node appeared.
+// Other nodes keep their order.
+func arrangeNodes(nodes []kratos.UiNode, s *schema.Schema) []uiNodeItem {
+	items := make([]uiNodeItem, 0, len(nodes))
+	if s == nil {
+		for _, n := range nodes {
+			items = append(items, uiNodeItem{Node: n})
+		}
+		return items
+	}
+
+	traitNodes := make(map[string]kratos.UiNode)
+	var traitOrder []string
+	traitsAt := -1
+	for _, n := range nodes {
+		if name := traitNodeName(n); name != "" {
+			if traitsAt < 0 {
+				traitsAt = len(items)
+				items = append(items, uiNodeItem{})
+			}
+			traitNodes[name] = n
+			traitOrder = append(traitOrder, name)
+			continue
+		}
+		items = append(items, uiNodeItem{Node: n})
+	}
+	if traitsAt < 0 {
+		return items
+	}
+
+	traitItems := traitPropertyItems(s.Traits, traitNodes)
+	// Traits the schema does not describe are kept, after those it does
+	for _, name := range traitOrder {
+		if n, ok := traitNodes[name]; ok {
+			traitItems = append(traitItems, uiNodeItem{Node: n})
+		}
+	}
+
+	arranged := append([]uiNodeItem{}, items[:traitsAt]...)
+	arranged = append(arranged, traitItems...)
+	return append(arranged, items[traitsAt+1:]...)
+}
+
+// traitPropertyItems returns the items for the nodes described by props, removing them from nodes.
+// Nested objects become fieldsets, as do properties sharing a "group" hint.
+func traitPropertyItems(props []schema.Property, nodes map[string]kratos.UiNode) []uiNodeItem {
+	var items []uiNodeItem
+	groupAt := make(map[string]int)
+	for i := range props {
+		p := &props[i]
+		var item uiNodeItem
+		if len(p.Properties) > 0 {
+			children := traitPropertyItems(p.Properties, nodes)
+			if len(children) == 0 {
+				continue
+			}
+			item = uiNodeItem{Legend: p.Title, Items: children}
+		} else {
+			n, ok := nodes[p.Path]
+			if !ok {
+				continue
+			}
+			delete(nodes, p.Path)
+			item = newTraitItem(n, p)
+		}
+
+		if p.UI.Group == "" {
+			items = append(items, item)
+			continue
+		}
+		if at, ok := groupAt[p.UI.Group]; ok {
+			items[at].Items = append(items[at].Items, item)
+			continue
+		}
+		groupAt[p.UI.Group] = len(items)
+		items = append(items, uiNodeItem{Legend: p.UI.Group, Items: []uiNodeItem{item}})
+	}
+	return items
+}
+
+// newTraitItem returns the item for a trait node. Only plain inputs are rendered from the schema,
+// checkboxes etc. keep their usual partials.
+func newTraitItem(n kratos.UiNode, p *schema.Property) uiNodeItem {
+	attrs := n.Attributes.UiNodeInputAttributes
+	switch attrs.Type {
+	case "hidden", "submit", "button", "checkbox":
+		return uiNodeItem{Node: n}
+	}
+	item := uiNodeItem{Node: n, Property: p}
+	if len(p.Enum) > 0 {
+		current := ""
+		if attrs.Value != nil {
+			current = fmt.Sprint(attrs.Value)
+		}
+		for _, e := range p.Enum {
+			v := fmt.Sprint(e)
+			item.Options = append(item.Options, selectOption{Value: v, Selected: v == current})
+		}
+	}
+	return item
+}
+
+// traitNodeName returns the trait path of an input node, e.g. "name.first" for "traits.name.first",
+// or "" if the node does not hold a trait
+func traitNodeName(n kratos.UiNode) string {
+	if n.Type != "input" || n.Attributes.UiNodeInputAttributes == nil {
+		return ""
+	}
+	name := n.Attributes.UiNodeInputAttributes.Name
+	if !strings.HasPrefix(name, traitNodePrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(name, traitNodePrefix)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const traitFormSchema = `{
+  "properties": {
+    "traits": {
+      "properties": {
+        "email": {"type": "string", "x-ui": {"order": 2}},
+        "name": {
+          "type": "object",
+          "title": "Name",
+          "properties": {
+            "first": {"type": "string"},
+            "last": {"type": "string"}
+          }
+        },
+        "plan": {"type": "string", "enum": ["free", "pro"], "x-ui": {"order": 1}},
+        "phone": {"type": "string", "x-ui": {"group": "Contact"}},
+        "fax": {"type": "string", "x-ui": {"group": "Contact"}},
+        "newsletter": {"type": "boolean"}
+      }
+    }
+  }
+}`
+
+// inputNode returns an input node named name
+func inputNode(name, inputType string, value interface{}) kratos.UiNode {
+	attrs := kratos.NewUiNodeInputAttributes(false, name, "input", inputType)
+	attrs.Value = value
+	return kratos.UiNode{Type: "input", Group: "default", Attributes: kratos.UiNodeInputAttributesAsUiNodeAttributes(attrs)}
+}
+
+// describeItems summarises form items, e.g. "Name{traits.name.first traits.name.last}", marking the inputs
+// rendered from a schema property with '*' and listing select options with the selected one marked '='
+func describeItems(items []uiNodeItem) []string {
+	var out []string
+	for _, i := range items {
+		if i.IsFieldset() {
+			out = append(out, i.Legend+"{"+strings.Join(describeItems(i.Items), " ")+"}")
+			continue
+		}
+		d := i.Node.Attributes.UiNodeInputAttributes.Name
+		if i.Property != nil {
+			d += "*"
+		}
+		var opts []string
+		for _, o := range i.Options {
+			if o.Selected {
+				opts = append(opts, "="+o.Value)
+			} else {
+				opts = append(opts, o.Value)
+			}
+		}
+		if len(opts) > 0 {
+			d += "[" + strings.Join(opts, ",") + "]"
+		}
+		out = append(out, d)
+	}
+	return out
+}
+
+func TestArrangeNodes(t *testing.T) {
+	s, err := schema.Parse("default", []byte(traitFormSchema))
+	require.NoError(t, err)
+
+	registration := []kratos.UiNode{
+		inputNode("csrf_token", "hidden", "token"),
+		inputNode("traits.email", "email", nil),
+		inputNode("traits.name.first", "text", nil),
+		inputNode("traits.nickname", "text", nil),
+		inputNode("traits.name.last", "text", nil),
+		inputNode("traits.plan", "text", "pro"),
+		inputNode("traits.phone", "text", nil),
+		inputNode("traits.fax", "text", nil),
+		inputNode("traits.newsletter", "checkbox", false),
+		inputNode("password", "password", nil),
+		inputNode("method", "submit", "password"),
+	}
+	noTraits := []kratos.UiNode{
+		inputNode("csrf_token", "hidden", "token"),
+		inputNode("password", "password", nil),
+	}
+
+	tests := []struct {
+		name   string
+		nodes  []kratos.UiNode
+		schema *schema.Schema
+		want   []string
+	}{
+		{
+			name:   "without a schema nodes keep their order",
+			nodes:  registration,
+			schema: nil,
+			want: []string{"csrf_token", "traits.email", "traits.name.first", "traits.nickname", "traits.name.last",
+				"traits.plan", "traits.phone", "traits.fax", "traits.newsletter", "password", "method"},
+		},
+		{
+			// Ordered properties come first, nested objects and groups become fieldsets, enums become selects,
+			// checkboxes aren't rendered from the schema and unknown traits follow those the schema describes
+			name:   "with a schema traits are arranged in its order",
+			nodes:  registration,
+			schema: s,
+			want: []string{"csrf_token", "traits.plan*[free,=pro]", "traits.email*", "Name{traits.name.first* traits.name.last*}",
+				"Contact{traits.phone* traits.fax*}", "traits.newsletter", "traits.nickname", "password", "method"},
+		},
+		{
+			name:   "nodes without traits are unchanged",
+			nodes:  noTraits,
+			schema: s,
+			want:   []string{"csrf_token", "password"},
+		},
+		{
+			name:   "only unknown traits",
+			nodes:  []kratos.UiNode{inputNode("traits.nickname", "text", nil), inputNode("method", "submit", "profile")},
+			schema: s,
+			want:   []string{"traits.nickname", "method"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, describeItems(arrangeNodes(tt.nodes, tt.schema)))
+		})
+	}
+}
+
+func TestArrangeNodesTemplate(t *testing.T) {
+	s, err := schema.Parse("default", []byte(traitFormSchema))
+	require.NoError(t, err)
+	nodes := []kratos.UiNode{inputNode("traits.email", "email", nil), inputNode("traits.plan", "text", "pro")}
+
+	// Pages without a schema leave it out, or pass a nil one if it couldn't be loaded
+	for name, data := range map[string]map[string]interface{}{
+		"missing": {"Nodes": nodes, "Only": "all"},
+		"nil":     {"Nodes": nodes, "Only": "all", "Schema": (*schema.Schema)(nil)},
+		"schema":  {"Nodes": nodes, "Only": "all", "Schema": s},
+	} {
+		var b bytes.Buffer
+		assert.NoError(t, GetTemplate(registrationPage).tmpl.ExecuteTemplate(&b, "ui_nodes", data), name)
+		assert.Contains(t, b.String(), `name="traits.email"`, name)
+	}
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/benbjohnson/hashfs"
+)
+
+const (
+	// unavailableRetryAfter is the number of seconds browsers are told to wait before retrying
+	unavailableRetryAfter = "30"
+)
+
+// ServiceUnavailableParams configure the ServiceUnavailable http handler
+type ServiceUnavailableParams struct {
+	// FS provides access to static files
+	FS *hashfs.FS
+}
+
+// ServiceUnavailable handler displays the 'authentication service unavailable' page
+func (sp ServiceUnavailableParams) ServiceUnavailable(w http.ResponseWriter, r *http.Request) {
+	renderServiceUnavailable(w, r, sp.FS)
+}
+
+// renderServiceUnavailable tells the user that Kratos can't be reached, rather than redirecting them
+// to pages that will fail in turn
+func renderServiceUnavailable(w http.ResponseWriter, r *http.Request, fs *hashfs.FS) {
+	w.Header().Set("Retry-After", unavailableRetryAfter)
+	dataMap := map[string]interface{}{
+		"title":    "Authentication service unavailable",
+		"retryURL": AppPath(r.URL.RequestURI()),
+		"fs":       fs,
+	}
+	if err := GetTemplate(unavailablePage).RenderStatus("layout", http.StatusServiceUnavailable, w, r, dataMap); err != nil {
+		TemplateErrorHandler(w, r, err)
+	}
+}
@@ -23,13 +23,13 @@ func (vp VerificationParams) Verification(w http.ResponseWriter, r *http.Request
 	// Start the verification flow with Kratos if required
 	flow := r.URL.Query().Get("flow")
 	if flow == "" {
-		http.Redirect(w, r, vp.FlowRedirectURL, http.StatusMovedPermanently)
+		http.Redirect(w, r, vp.FlowRedirectURL, http.StatusSeeOther)
 		return
 	}
 
 	verificationResp, rawResp, err := api_client.PublicClient().V0alpha2Api.GetSelfServiceVerificationFlow(r.Context()).Id(flow).Cookie(r.Header.Get("Cookie")).Execute()
 	if err != nil {
-		KratosErrorHandler(w, r, rawResp, err, vp.FlowRedirectURL)
+		KratosErrorHandler(w, r, vp.FS, rawResp, err, vp.FlowRedirectURL)
 		return
 	}
 
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// Kratos node names in the 'webauthn' group
+	webauthnDisplayNameNodeName = "webauthn_register_displayname"
+	webauthnRemoveNodeName      = "webauthn_remove"
+	webauthnLoginTriggerName    = "webauthn_login_trigger"
+
+	// webauthnMethod is the session authentication method used by security keys
+	webauthnMethod = "webauthn"
+)
+
+// webauthnLoginCall matches the onclick of the login trigger, capturing the credential request options
+var webauthnLoginCall = regexp.MustCompile(`(?s)^\s*(?:window\.)?__oryWebAuthnLogin\((.*)\)\s*;?\s*$`)
+
+// webauthnSettings holds the nodes of the 'webauthn' settings group, picked apart so the
+// registered keys can be listed and the new key naming step explained
+type webauthnSettings struct {
+	// DisplayName is the input used to name a new key
+	DisplayName *kratos.UiNode
+
+	// Keys are the registered security keys
+	Keys []webauthnKey
+
+	// LastUsed is when a security key was last used to sign in, nil if unknown
+	LastUsed *time.Time
+
+	// Other holds the rest of the group's nodes, including the register button and Kratos' script
+	Other []kratos.UiNode
+}
+
+// webauthnKey is a registered security key
+type webauthnKey struct {
+	Name    string
+	AddedAt *time.Time
+	Remove  kratos.UiNode
+}
+
+// newWebauthnSettings picks the 'webauthn' group nodes out of a settings flow
+func newWebauthnSettings(nodes []kratos.UiNode) webauthnSettings {
+	var s webauthnSettings
+	for i := range nodes {
+		n := nodes[i]
+		if n.Group != "webauthn" {
+			continue
+		}
+		attrs := n.Attributes.UiNodeInputAttributes
+		switch {
+		case attrs != nil && attrs.Name == webauthnDisplayNameNodeName:
+			s.DisplayName = &n
+		case attrs != nil && attrs.Name == webauthnRemoveNodeName:
+			s.Keys = append(s.Keys, newWebauthnKey(n))
+		default:
+			s.Other = append(s.Other, n)
+		}
+	}
+	return s
+}
+
+// newWebauthnKey describes a key from its remove button, whose label context holds the key's name and
+// when it was added
+func newWebauthnKey(n kratos.UiNode) webauthnKey {
+	k := webauthnKey{Name: "Security key", Remove: n}
+	if n.Meta.Label == nil {
+		return k
+	}
+	if name, ok := n.Meta.Label.Context["display_name"].(string); ok && name != "" {
+		k.Name = name
+	}
+	if addedAt, ok := n.Meta.Label.Context["added_at"].(string); ok {
+		if t, err := time.Parse(time.RFC3339, addedAt); err == nil {
+			k.AddedAt = &t
+		}
+	}
+	return k
+}
+
+// lastWebauthnUse returns when the identity last completed a security key challenge, from its sessions.
+// Kratos does not record which key was used, so this is for security keys as a whole.
+func lastWebauthnUse(r *http.Request, identityID string) *time.Time {
+	sessions, _, err := api_client.AdminClient().V0alpha2Api.AdminListIdentitySessions(r.Context(), identityID).Execute()
+	if err != nil {
+		log.Printf("Error listing identity sessions: %v", err)
+		return nil
+	}
+	var last *time.Time
+	for _, s := range sessions {
+		for _, m := range s.AuthenticationMethods {
+			if m.Method == nil || *m.Method != webauthnMethod || m.CompletedAt == nil {
+				continue
+			}
+			if last == nil || m.CompletedAt.After(*last) {
+				last = m.CompletedAt
+			}
+		}
+	}
+	return last
+}
+
+// webauthnLoginOptions returns the credential request options that Kratos embeds in the onclick of the
+// login trigger, so they can also be used for conditional (autofill) sign in. Returns "" if there are none.
+func webauthnLoginOptions(nodes []kratos.UiNode) string {
+	for _, n := range nodes {
+		attrs := n.Attributes.UiNodeInputAttributes
+		if attrs == nil || attrs.Name != webauthnLoginTriggerName || attrs.Onclick == nil {
+			continue
+		}
+		m := webauthnLoginCall.FindStringSubmatch(*attrs.Onclick)
+		if m == nil || !json.Valid([]byte(m[1])) {
+			log.Printf("Unrecognised webauthn login trigger")
+			return ""
+		}
+		return m[1]
+	}
+	return ""
+}
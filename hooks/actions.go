@@ -0,0 +1,174 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"text/template"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
+	"github.com/davidoram/kratos-selfservice-ui-go/mailer"
+	"github.com/davidoram/kratos-selfservice-ui-go/registration"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// auditTypes are the audit event types recorded for hook events, other events are recorded as "hook.<event>"
+var auditTypes = map[string]string{
+	EventSettings: audit.SettingsUpdated,
+	EventRecovery: audit.RecoveryUsed,
+}
+
+// AuditRecord is a HandlerFunc that records an audit event for each hook. Kratos only calls hooks once a flow
+// has succeeded, so each one is recorded once, as a success; failed submissions, e.g. a settings form with a
+// wrong password, are posted straight to Kratos and aren't recorded. The client's IP address is read from the
+// X-Forwarded-For header of its request to Kratos, trusting the same proxies as requests to the app.
+func AuditRecord(ctx context.Context, event string, p *Payload) error {
+	eventType, ok := auditTypes[event]
+	if !ok {
+		eventType = "hook." + event
+	}
+	e := audit.Event{
+		Time:      time.Now().UTC(),
+		Type:      eventType,
+		IP:        clientip.Forwarded(p.RequestHeaders),
+		UserAgent: p.RequestHeaders.Get("User-Agent"),
+		Outcome:   audit.Success,
+		Detail:    "flow " + p.FlowID,
+	}
+	if p.Identity != nil {
+		e.IdentityID = p.Identity.Id
+	}
+	audit.Log(e)
+	return nil
+}
+
+// InvalidateSessions returns a HandlerFunc that removes the identity's sessions from cache, so changes
+// made in the flow are seen straight away
+func InvalidateSessions(cache *session.Cache) HandlerFunc {
+	return func(ctx context.Context, event string, p *Payload) error {
+		if p.Identity != nil {
+			cache.InvalidateIdentity(p.Identity.Id)
+		}
+		return nil
+	}
+}
+
+// welcomeEmail is the body of the welcome email, executed with the identity
+var welcomeEmail = template.Must(template.New("welcome").Parse(`Hello{{with .Traits.name}}{{with .first}} {{.}}{{end}}{{end}},
+
+Welcome aboard! Your account has been created, you can manage it at any time from your account dashboard.
+`))
+
+// WelcomeEmail returns a HandlerFunc that emails new identities a welcome, with the subject given
+func WelcomeEmail(m mailer.Mailer, subject string) HandlerFunc {
+	return func(ctx context.Context, event string, p *Payload) error {
+		if p.Identity == nil {
+			return errors.New("payload has no identity")
+		}
+		to := emailAddress(p.Identity)
+		if to == "" {
+			return nil
+		}
+		var b bytes.Buffer
+		if err := welcomeEmail.Execute(&b, p.Identity); err != nil {
+			return err
+		}
+		return m.Send(ctx, mailer.Message{To: to, Subject: subject, Body: b.String()})
+	}
+}
+
+// emailAddress returns the identity's 'email' trait, or its first email verifiable address
+func emailAddress(identity *kratos.Identity) string {
+	if traits, ok := identity.Traits.(map[string]interface{}); ok {
+		if email, ok := traits["email"].(string); ok && email != "" {
+			return email
+		}
+	}
+	for _, a := range identity.VerifiableAddresses {
+		if a.Via == "email" {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// RegistrationGate returns a HandlerFunc that rejects registrations the gate didn't admit, deleting their
+// identity through the admin API. Register it before other registration handlers, so they aren't called
+// for identities that are deleted.
+func RegistrationGate(gate *registration.Gate) HandlerFunc {
+	return func(ctx context.Context, event string, p *Payload) error {
+		if p.Identity == nil {
+			return errors.New("payload has no identity")
+		}
+		err := gate.Verify(p.FlowID, emailAddress(p.Identity))
+		if err == nil {
+			return nil
+		}
+		if resp, delErr := api_client.AdminClient().V0alpha2Api.AdminDeleteIdentity(ctx, p.Identity.Id).Execute(); delErr != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			return fmt.Errorf("%w: %v, and deleting identity '%s' failed: %v", ErrReject, err, p.Identity.Id, delErr)
+		}
+		audit.Log(audit.Event{
+			Time:       time.Now().UTC(),
+			Type:       audit.RegistrationRejected,
+			IdentityID: p.Identity.Id,
+			Outcome:    audit.Failure,
+			Detail:     err.Error(),
+		})
+		return fmt.Errorf("%w: %v, identity '%s' deleted", ErrReject, err, p.Identity.Id)
+	}
+}
+
+// MetadataFunc returns the values to set in an identity's public metadata
+type MetadataFunc func(ctx context.Context, event string, p *Payload) (map[string]interface{}, error)
+
+// EnrichMetadataPublic returns a HandlerFunc that merges the values returned by fn into the
+// identity's metadata_public, through the admin API
+func EnrichMetadataPublic(fn MetadataFunc) HandlerFunc {
+	return func(ctx context.Context, event string, p *Payload) error {
+		if p.Identity == nil {
+			return errors.New("payload has no identity")
+		}
+		values, err := fn(ctx, event, p)
+		if err != nil || len(values) == 0 {
+			return err
+		}
+
+		// Updates replace the whole identity, so start from its current state
+		identity, _, err := api_client.AdminClient().V0alpha2Api.AdminGetIdentity(ctx, p.Identity.Id).Execute()
+		if err != nil {
+			return fmt.Errorf("getting identity: %w", err)
+		}
+		metadata, _ := identity.MetadataPublic.(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+		}
+		for k, v := range values {
+			metadata[k] = v
+		}
+		traits, _ := identity.Traits.(map[string]interface{})
+		body := kratos.AdminUpdateIdentityBody{
+			SchemaId:       identity.SchemaId,
+			State:          identity.GetState(),
+			Traits:         traits,
+			MetadataPublic: metadata,
+			MetadataAdmin:  identity.MetadataAdmin,
+		}
+		if _, _, err := api_client.AdminClient().V0alpha2Api.AdminUpdateIdentity(ctx, identity.Id).AdminUpdateIdentityBody(body).Execute(); err != nil {
+			return fmt.Errorf("updating identity: %w", err)
+		}
+		return nil
+	}
+}
+
+// EventTimestamp is a MetadataFunc recording when the event last happened, e.g. "last_login_at"
+func EventTimestamp(ctx context.Context, event string, p *Payload) (map[string]interface{}, error) {
+	return map[string]interface{}{
+		"last_" + event + "_at": time.Now().UTC().Format(time.RFC3339),
+	}, nil
+}
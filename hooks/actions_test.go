@@ -0,0 +1,35 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditRecord(t *testing.T) {
+	sink := audit.NewMemorySink(10)
+	audit.Init(sink)
+	defer audit.Close()
+
+	var p Payload
+	require.NoError(t, json.Unmarshal([]byte(`{"flow_id":"f1","identity":{"id":"i1","schema_id":"default","schema_url":"","traits":{}},"request_headers":{"User-Agent":["Firefox"],"X-Forwarded-For":["198.51.100.1"]}}`), &p))
+	for _, event := range []string{EventSettings, EventRecovery, EventLogin} {
+		require.NoError(t, AuditRecord(context.Background(), event, &p))
+	}
+
+	events, err := sink.Query(audit.Query{})
+	require.NoError(t, err)
+	var types []string
+	for _, e := range events {
+		types = append(types, e.Type)
+		assert.Equal(t, "i1", e.IdentityID)
+		assert.Equal(t, "Firefox", e.UserAgent)
+		assert.Equal(t, "198.51.100.1", e.IP)
+		assert.Equal(t, audit.Success, e.Outcome)
+	}
+	assert.Equal(t, []string{"hook.login", audit.RecoveryUsed, audit.SettingsUpdated}, types)
+}
@@ -0,0 +1,203 @@
+// Package hooks receives the web hooks that Kratos calls after self service flows complete,
+// and dispatches them to Go handler functions
+package hooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
+	"github.com/gorilla/mux"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+const (
+	// SignatureHeader holds the hex encoded HMAC-SHA256 of the request body, keyed with the
+	// shared secret and prefixed with "sha256=", e.g. "sha256=5d41..."
+	SignatureHeader = "X-Hook-Signature"
+
+	// signaturePrefix prefixes the signature in the SignatureHeader
+	signaturePrefix = "sha256="
+
+	// MaxPayloadSize limits the size of the hook payloads we read
+	MaxPayloadSize = 1 << 20
+
+	// asyncTimeout limits how long the handlers registered with HandleAsync may take for each hook
+	asyncTimeout = time.Minute
+)
+
+// Events that Kratos can call hooks after
+const (
+	EventRegistration = "registration"
+	EventLogin        = "login"
+	EventSettings     = "settings"
+	EventRecovery     = "recovery"
+	EventVerification = "verification"
+)
+
+// Payload is the body that Kratos sends, as shaped by contrib/quickstart/kratos/hooks/payload.jsonnet
+type Payload struct {
+	FlowID        string           `json:"flow_id"`
+	FlowType      string           `json:"flow_type"`
+	Identity      *kratos.Identity `json:"identity"`
+	RequestURL    string           `json:"request_url"`
+	RequestMethod string           `json:"request_method"`
+
+	// RequestHeaders are the headers of the browser's request to Kratos, e.g. its User-Agent
+	RequestHeaders http.Header `json:"request_headers"`
+
+	// Raw is the payload as received, so handlers can read fields added to the jsonnet
+	Raw json.RawMessage `json:"-"`
+}
+
+// HandlerFunc is called with the payload of each hook for the event it is registered for
+type HandlerFunc func(ctx context.Context, event string, p *Payload) error
+
+// ErrReject is wrapped by the errors of handlers that reject the flow. Kratos gets an error response, and the
+// handlers registered after them are not called. Other errors are logged, they don't fail the hook.
+var ErrReject = errors.New("flow rejected")
+
+// Receiver is a http handler for the route '/hooks/{event}'. Requests must either carry the shared
+// secret as a bearer token, or sign the body with it (see SignatureHeader).
+type Receiver struct {
+	secret []byte
+
+	mu            sync.RWMutex
+	handlers      map[string][]HandlerFunc
+	asyncHandlers map[string][]HandlerFunc
+
+	// async tracks the async handlers running, so shutdown can wait for them
+	async sync.WaitGroup
+}
+
+// NewReceiver returns a Receiver that authenticates requests with secret
+func NewReceiver(secret string) *Receiver {
+	return &Receiver{
+		secret:        []byte(secret),
+		handlers:      make(map[string][]HandlerFunc),
+		asyncHandlers: make(map[string][]HandlerFunc),
+	}
+}
+
+// Handle registers fn to be called for event, before Kratos gets its response. Handlers are called in the order
+// they are registered, and only those that gate the flow should return ErrReject.
+func (rc *Receiver) Handle(event string, fn HandlerFunc) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.handlers[event] = append(rc.handlers[event], fn)
+}
+
+// HandleAsync registers fn to be called for event after Kratos gets its response, for side effects that are
+// slow or may fail, such as sending emails. They are called in the order they are registered, unless the flow
+// was rejected, and their errors are logged.
+func (rc *Receiver) HandleAsync(event string, fn HandlerFunc) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.asyncHandlers[event] = append(rc.asyncHandlers[event], fn)
+}
+
+// Wait waits for the async handlers that are running to finish
+func (rc *Receiver) Wait() {
+	rc.async.Wait()
+}
+
+// ServeHTTP authenticates the hook, parses its payload and calls the handlers for its event. If a handler
+// rejects the flow Kratos gets a 500 response, see ErrReject, otherwise the async handlers are started.
+func (rc *Receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := mux.Vars(r)["event"]
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxPayloadSize+1))
+	if errors.Is(err, middleware.ErrBodyTooLarge) || len(body) > MaxPayloadSize {
+		http.Error(w, "Payload too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Printf("Error reading '%s' hook: %v", event, err)
+		http.Error(w, "Error reading payload", http.StatusBadRequest)
+		return
+	}
+	if !rc.authenticated(r, body) {
+		log.Printf("Rejected unauthenticated '%s' hook from %s", event, r.RemoteAddr)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	rc.mu.RLock()
+	handlers, asyncHandlers := rc.handlers[event], rc.asyncHandlers[event]
+	rc.mu.RUnlock()
+	if len(handlers) == 0 && len(asyncHandlers) == 0 {
+		http.Error(w, fmt.Sprintf("Unknown hook event '%s'", event), http.StatusNotFound)
+		return
+	}
+
+	p := Payload{Raw: body}
+	if err := json.Unmarshal(body, &p); err != nil {
+		log.Printf("Error parsing '%s' hook payload: %v", event, err)
+		http.Error(w, "Invalid payload", http.StatusBadRequest)
+		return
+	}
+
+	for _, fn := range handlers {
+		err := fn(r.Context(), event, &p)
+		if err == nil {
+			continue
+		}
+		log.Printf("Error handling '%s' hook for flow '%s': %v", event, p.FlowID, err)
+		if errors.Is(err, ErrReject) {
+			http.Error(w, "Error handling hook", http.StatusInternalServerError)
+			return
+		}
+	}
+	if len(asyncHandlers) > 0 {
+		rc.async.Add(1)
+		go rc.callAsync(event, &p, asyncHandlers)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// callAsync calls the async handlers for a hook, logging their errors
+func (rc *Receiver) callAsync(event string, p *Payload, handlers []HandlerFunc) {
+	defer rc.async.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), asyncTimeout)
+	defer cancel()
+	for _, fn := range handlers {
+		if err := fn(ctx, event, p); err != nil {
+			log.Printf("Error handling '%s' hook for flow '%s': %v", event, p.FlowID, err)
+		}
+	}
+}
+
+// authenticated reports if the request is signed with, or carries, the shared secret
+func (rc *Receiver) authenticated(r *http.Request, body []byte) bool {
+	if len(rc.secret) == 0 {
+		return false
+	}
+	if sig := r.Header.Get(SignatureHeader); sig != "" {
+		got, err := hex.DecodeString(strings.TrimPrefix(sig, signaturePrefix))
+		if err != nil {
+			return false
+		}
+		return hmac.Equal(got, Sign(rc.secret, body))
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), rc.secret) == 1
+}
+
+// Sign returns the HMAC-SHA256 of body keyed with secret
+func Sign(secret, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,126 @@
+package hooks
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+const testPayload = `{"flow_id":"f1","flow_type":"browser","identity":{"id":"i1","schema_id":"default","schema_url":"","traits":{"email":"a@example.com"}}}`
+
+func serve(rc *Receiver, event string, header http.Header) *httptest.ResponseRecorder {
+	router := mux.NewRouter()
+	router.Handle("/hooks/{event}", rc)
+	req := httptest.NewRequest(http.MethodPost, "/hooks/"+event, strings.NewReader(testPayload))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestReceiverAuthentication(t *testing.T) {
+	rc := NewReceiver("s3cret")
+	rc.Handle(EventLogin, func(ctx context.Context, event string, p *Payload) error { return nil })
+
+	signature := signaturePrefix + hex.EncodeToString(Sign([]byte("s3cret"), []byte(testPayload)))
+	badSignature := signaturePrefix + hex.EncodeToString(Sign([]byte("wrong"), []byte(testPayload)))
+
+	tests := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"none", http.Header{}, http.StatusUnauthorized},
+		{"bearer", http.Header{"Authorization": {"Bearer s3cret"}}, http.StatusNoContent},
+		{"wrong bearer", http.Header{"Authorization": {"Bearer nope"}}, http.StatusUnauthorized},
+		{"signature", http.Header{SignatureHeader: {signature}}, http.StatusNoContent},
+		{"wrong signature", http.Header{SignatureHeader: {badSignature}}, http.StatusUnauthorized},
+		{"malformed signature", http.Header{SignatureHeader: {"sha256=zz"}}, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, serve(rc, EventLogin, tt.header).Code)
+		})
+	}
+}
+
+func TestReceiverDispatch(t *testing.T) {
+	rc := NewReceiver("s3cret")
+	var calls []string
+	rc.Handle(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		calls = append(calls, "first:"+p.Identity.Id)
+		return errors.New("boom")
+	})
+	rc.Handle(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		calls = append(calls, "second:"+p.FlowID)
+		return nil
+	})
+	auth := http.Header{"Authorization": {"Bearer s3cret"}}
+
+	// Errors that don't reject the flow are logged, they don't fail the hook
+	w := serve(rc, EventRegistration, auth)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []string{"first:i1", "second:f1"}, calls)
+
+	assert.Equal(t, http.StatusNotFound, serve(rc, "unknown", auth).Code)
+}
+
+func TestReceiverReject(t *testing.T) {
+	rc := NewReceiver("s3cret")
+	var calls []string
+	rc.Handle(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		calls = append(calls, "gate")
+		return fmt.Errorf("%w: not allowed", ErrReject)
+	})
+	rc.Handle(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		calls = append(calls, "welcome")
+		return nil
+	})
+
+	w := serve(rc, EventRegistration, http.Header{"Authorization": {"Bearer s3cret"}})
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, []string{"gate"}, calls)
+}
+
+func TestReceiverAsync(t *testing.T) {
+	rc := NewReceiver("s3cret")
+	var calls []string
+	release := make(chan struct{})
+	rc.HandleAsync(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		<-release
+		calls = append(calls, "email:"+p.Identity.Id)
+		return errors.New("smtp down")
+	})
+	rc.HandleAsync(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		calls = append(calls, "metadata:"+p.FlowID)
+		return nil
+	})
+	auth := http.Header{"Authorization": {"Bearer s3cret"}}
+
+	// Kratos gets its response before the async handlers finish, and their errors don't fail the hook
+	w := serve(rc, EventRegistration, auth)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	close(release)
+	rc.Wait()
+	assert.Equal(t, []string{"email:i1", "metadata:f1"}, calls)
+
+	// They aren't called for rejected flows
+	calls = nil
+	rc.Handle(EventRegistration, func(ctx context.Context, event string, p *Payload) error {
+		return fmt.Errorf("%w: not allowed", ErrReject)
+	})
+	w = serve(rc, EventRegistration, auth)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	rc.Wait()
+	assert.Empty(t, calls)
+}
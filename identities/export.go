@@ -0,0 +1,50 @@
+package identities
+
+import (
+	"context"
+
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// DefaultPageSize is the number of identities fetched from Kratos at a time, unless configured otherwise
+const DefaultPageSize = 250
+
+// ListFunc returns a page of identities, pages are numbered from 0
+type ListFunc func(ctx context.Context, page, perPage int64) ([]kratos.Identity, error)
+
+// Exporter writes identities as records
+type Exporter struct {
+	// List lists the identities
+	List ListFunc
+
+	// PageSize is the number of identities listed at a time, DefaultPageSize if not set
+	PageSize int64
+
+	// Traits are the dotted paths of the traits exported, e.g. "email" or "name.first". All
+	// traits are exported if there are none.
+	Traits []string
+}
+
+// Export writes all the identities to w, returning the number written
+func (ex Exporter) Export(ctx context.Context, w Writer) (int, error) {
+	pageSize := ex.PageSize
+	if pageSize < 1 {
+		pageSize = DefaultPageSize
+	}
+	n := 0
+	for page := int64(0); ; page++ {
+		identities, err := ex.List(ctx, page, pageSize)
+		if err != nil {
+			return n, err
+		}
+		for _, i := range identities {
+			if err := w.Write(FromIdentity(i, ex.Traits)); err != nil {
+				return n, err
+			}
+			n++
+		}
+		if int64(len(identities)) < pageSize {
+			return n, w.Flush()
+		}
+	}
+}
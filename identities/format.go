@@ -0,0 +1,275 @@
+package identities
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// File formats
+const (
+	// JSONLines has a JSON record on each line
+	JSONLines = "jsonl"
+
+	// CSV has a header row naming the columns, followed by a record on each row
+	CSV = "csv"
+)
+
+// CSV columns, other than the traits
+const (
+	columnID                = "id"
+	columnSchemaID          = "schema_id"
+	columnState             = "state"
+	columnPasswordHash      = "password_hash"
+	columnVerifiedAddresses = "verified_addresses"
+
+	// traitColumnPrefix prefixes the dotted path of a trait to name its column, e.g. "traits.name.first"
+	traitColumnPrefix = "traits."
+
+	// listSeparator separates the values of lists in CSV cells
+	listSeparator = ";"
+)
+
+// maxLineSize is the longest JSON line that can be read
+const maxLineSize = 1024 * 1024
+
+// FormatOf returns the format of a file from its name, JSON lines unless it ends with ".csv"
+func FormatOf(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".csv") {
+		return CSV
+	}
+	return JSONLines
+}
+
+// RowError is a record that can't be read or imported
+type RowError struct {
+	Row int
+	ID  string
+	Err error
+}
+
+func (e *RowError) Error() string {
+	return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+}
+
+func (e *RowError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads records from a file
+type Reader interface {
+	// Read returns the next record, or io.EOF after the last. A record that can't be decoded returns
+	// a *RowError, and the records after it can still be read.
+	Read() (Record, error)
+}
+
+// NewReader returns a reader of r in format
+func NewReader(r io.Reader, format string) (Reader, error) {
+	switch format {
+	case JSONLines:
+		s := bufio.NewScanner(r)
+		s.Buffer(make([]byte, 64*1024), maxLineSize)
+		return &jsonLinesReader{scanner: s}, nil
+	case CSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		return newCSVReader(cr)
+	}
+	return nil, fmt.Errorf("unknown format '%s', must be '%s' or '%s'", format, JSONLines, CSV)
+}
+
+type jsonLinesReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (jr *jsonLinesReader) Read() (Record, error) {
+	for jr.scanner.Scan() {
+		jr.line++
+		line := strings.TrimSpace(jr.scanner.Text())
+		if line == "" {
+			continue
+		}
+		rec := Record{Row: jr.line}
+		if err := json.Unmarshal([]byte(line), &rec); err != nil {
+			return rec, &RowError{Row: jr.line, Err: fmt.Errorf("invalid JSON: %w", err)}
+		}
+		rec.Row = jr.line
+		return rec, nil
+	}
+	if err := jr.scanner.Err(); err != nil {
+		return Record{}, err
+	}
+	return Record{}, io.EOF
+}
+
+type csvReader struct {
+	r       *csv.Reader
+	columns []string
+	row     int
+}
+
+// newCSVReader reads the header row of r
+func newCSVReader(r *csv.Reader) (*csvReader, error) {
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, errors.New("the CSV file is empty, it must start with a header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range header {
+		switch c {
+		case columnID, columnSchemaID, columnState, columnPasswordHash, columnVerifiedAddresses:
+		default:
+			if !strings.HasPrefix(c, traitColumnPrefix) || c == traitColumnPrefix {
+				return nil, fmt.Errorf("unknown CSV column '%s', traits are named like 'traits.email'", c)
+			}
+		}
+	}
+	return &csvReader{r: r, columns: header, row: 1}, nil
+}
+
+func (cr *csvReader) Read() (Record, error) {
+	values, err := cr.r.Read()
+	cr.row++
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return Record{Row: cr.row}, &RowError{Row: cr.row, Err: err}
+	}
+	if err != nil {
+		return Record{}, err
+	}
+	rec := Record{Row: cr.row, Traits: make(map[string]interface{})}
+	if len(values) != len(cr.columns) {
+		return rec, &RowError{Row: cr.row, Err: fmt.Errorf("has %d values, the header has %d columns", len(values), len(cr.columns))}
+	}
+	for i, c := range cr.columns {
+		v := values[i]
+		if v == "" {
+			continue
+		}
+		switch c {
+		case columnID:
+			rec.ID = v
+		case columnSchemaID:
+			rec.SchemaID = v
+		case columnState:
+			rec.State = v
+		case columnPasswordHash:
+			rec.PasswordHash = v
+		case columnVerifiedAddresses:
+			rec.VerifiedAddresses = splitList(v)
+		default:
+			set(rec.Traits, strings.TrimPrefix(c, traitColumnPrefix), v)
+		}
+	}
+	rec.textTraits = true
+	return rec, nil
+}
+
+// splitList splits a CSV cell holding a list
+func splitList(v string) []string {
+	var list []string
+	for _, s := range strings.Split(v, listSeparator) {
+		if s = strings.TrimSpace(s); s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// Writer writes records to a file
+type Writer interface {
+	Write(r Record) error
+
+	// Flush writes any buffered records
+	Flush() error
+}
+
+// NewWriter returns a writer of records to w in format. CSV files have a column for each of traits,
+// the dotted paths of the traits written.
+func NewWriter(w io.Writer, format string, traits []string) (Writer, error) {
+	switch format {
+	case JSONLines:
+		bw := bufio.NewWriter(w)
+		return &jsonLinesWriter{w: bw, enc: json.NewEncoder(bw)}, nil
+	case CSV:
+		if len(traits) == 0 {
+			return nil, errors.New("CSV files need the traits to write")
+		}
+		return &csvWriter{w: csv.NewWriter(w), traits: traits}, nil
+	}
+	return nil, fmt.Errorf("unknown format '%s', must be '%s' or '%s'", format, JSONLines, CSV)
+}
+
+type jsonLinesWriter struct {
+	w   *bufio.Writer
+	enc *json.Encoder
+}
+
+func (jw *jsonLinesWriter) Write(r Record) error {
+	return jw.enc.Encode(r)
+}
+
+func (jw *jsonLinesWriter) Flush() error {
+	return jw.w.Flush()
+}
+
+type csvWriter struct {
+	w           *csv.Writer
+	traits      []string
+	wroteHeader bool
+}
+
+func (cw *csvWriter) Write(r Record) error {
+	if !cw.wroteHeader {
+		header := []string{columnID, columnSchemaID, columnState, columnVerifiedAddresses}
+		for _, t := range cw.traits {
+			header = append(header, traitColumnPrefix+t)
+		}
+		if err := cw.w.Write(header); err != nil {
+			return err
+		}
+		cw.wroteHeader = true
+	}
+	row := []string{r.ID, r.SchemaID, r.State, strings.Join(r.VerifiedAddresses, listSeparator)}
+	for _, t := range cw.traits {
+		v, _ := lookup(r.Traits, t)
+		row = append(row, cellValue(v))
+	}
+	return cw.w.Write(row)
+}
+
+func (cw *csvWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// cellValue formats a trait value for a CSV cell. Lists of values are separated by listSeparator, and
+// objects are written as JSON.
+func cellValue(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return t
+	case bool:
+		return strconv.FormatBool(t)
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case []interface{}:
+		values := make([]string, len(t))
+		for i, e := range t {
+			values[i] = cellValue(e)
+		}
+		return strings.Join(values, listSeparator)
+	}
+	b, _ := json.Marshal(v)
+	return string(b)
+}
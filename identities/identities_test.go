@@ -0,0 +1,237 @@
+package identities
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSchema = `{
+  "properties": {
+    "traits": {
+      "type": "object",
+      "required": ["email"],
+      "properties": {
+        "email": {"type": "string", "format": "email"},
+        "name": {"type": "object", "properties": {"first": {"type": "string"}, "last": {"type": "string"}}},
+        "age": {"type": "integer"},
+        "newsletter": {"type": "boolean"}
+      }
+    }
+  }
+}`
+
+func testSchemas(t *testing.T) SchemaFunc {
+	s, err := schema.Parse("default", []byte(testSchema))
+	require.NoError(t, err)
+	return func(ctx context.Context, id string) (*schema.Schema, error) {
+		if id != "default" {
+			return nil, fmt.Errorf("no schema '%s'", id)
+		}
+		return s, nil
+	}
+}
+
+// fakeKratos records the identities created, failing those whose email is taken
+type fakeKratos struct {
+	mu      sync.Mutex
+	created []kratos.AdminCreateIdentityBody
+}
+
+func (f *fakeKratos) create(ctx context.Context, body kratos.AdminCreateIdentityBody) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if body.Traits["email"] == "taken@example.com" {
+		return "", errors.New("This identity conflicts with another identity that already exists.")
+	}
+	f.created = append(f.created, body)
+	return fmt.Sprintf("identity-%d", len(f.created)), nil
+}
+
+func readAll(t *testing.T, format, data string) []Record {
+	r, err := NewReader(strings.NewReader(data), format)
+	require.NoError(t, err)
+	var records []Record
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+	}
+	return records
+}
+
+func TestReadCSV(t *testing.T) {
+	records := readAll(t, CSV, "id,traits.email,traits.name.first,traits.age,password_hash,verified_addresses\n"+
+		"legacy-1,ann@example.com,Ann,30,$2a$10$abc,ann@example.com\n"+
+		"legacy-2,bob@example.com,,,,\n")
+	require.Len(t, records, 2)
+	assert.Equal(t, 2, records[0].Row)
+	assert.Equal(t, "legacy-1", records[0].ID)
+	assert.Equal(t, map[string]interface{}{"email": "ann@example.com", "name": map[string]interface{}{"first": "Ann"}, "age": "30"}, records[0].Traits)
+	assert.Equal(t, "$2a$10$abc", records[0].PasswordHash)
+	assert.Equal(t, []string{"ann@example.com"}, records[0].VerifiedAddresses)
+	assert.Equal(t, map[string]interface{}{"email": "bob@example.com"}, records[1].Traits)
+
+	_, err := NewReader(strings.NewReader("email\n"), CSV)
+	assert.EqualError(t, err, "unknown CSV column 'email', traits are named like 'traits.email'")
+}
+
+func TestImport(t *testing.T) {
+	data := `{"id":"legacy-1","traits":{"email":"ann@example.com","age":30},"password_hash":"$2a$10$abc","verified_addresses":["ann@example.com"]}
+
+{"id":"legacy-2","traits":{"email":"not an email"}}
+{"id":"legacy-3","traits":{"email":"taken@example.com"}}
+not json
+{"id":"legacy-4","schema_id":"other","traits":{"email":"dan@example.com"}}
+{"id":"legacy-5","traits":{"email":"eve@example.com"},"verified_addresses":["other@example.com"]}
+{"traits":{"email":"fay@example.com"},"state":"inactive"}
+`
+	kratosAPI := &fakeKratos{}
+	im := Importer{Create: kratosAPI.create, Schemas: testSchemas(t), DefaultSchemaID: "default", Concurrency: 3}
+	var report bytes.Buffer
+	er := NewErrorReport(&report)
+	r, err := NewReader(strings.NewReader(data), JSONLines)
+	require.NoError(t, err)
+	summary, err := im.Import(context.Background(), r, er.Add)
+	require.NoError(t, err)
+	require.NoError(t, er.Flush())
+
+	assert.Equal(t, Summary{Created: 2, Failed: 5}, summary)
+	require.Len(t, kratosAPI.created, 2)
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	assert.Equal(t, "row,id,error", lines[0])
+	assert.ElementsMatch(t, []string{
+		"3,legacy-2,'email' must be an email address",
+		"4,legacy-3,This identity conflicts with another identity that already exists.",
+		"5,,invalid JSON: invalid character 'o' in literal null (expecting 'u')",
+		"6,legacy-4,loading identity schema 'other': no schema 'other'",
+		"7,legacy-5,verified address 'other@example.com' is not one of the traits",
+	}, lines[1:])
+
+	for _, body := range kratosAPI.created {
+		switch body.Traits["email"] {
+		case "ann@example.com":
+			assert.Equal(t, "default", body.SchemaId)
+			assert.Equal(t, "$2a$10$abc", *body.Credentials.Password.Config.HashedPassword)
+			assert.Equal(t, map[string]interface{}{"import_id": "legacy-1"}, body.MetadataAdmin)
+			require.Len(t, body.VerifiableAddresses, 1)
+			assert.True(t, body.VerifiableAddresses[0].Verified)
+		case "fay@example.com":
+			assert.Equal(t, kratos.IDENTITYSTATE_INACTIVE, *body.State)
+			assert.Nil(t, body.MetadataAdmin)
+		default:
+			t.Errorf("unexpected identity %v", body.Traits)
+		}
+	}
+}
+
+func TestImportCSVTypesTraits(t *testing.T) {
+	kratosAPI := &fakeKratos{}
+	im := Importer{Create: kratosAPI.create, Schemas: testSchemas(t), DefaultSchemaID: "default"}
+	r, err := NewReader(strings.NewReader("traits.email,traits.age,traits.newsletter\nann@example.com,30,true\nbob@example.com,thirty,\n"), CSV)
+	require.NoError(t, err)
+	var results []Result
+	summary, err := im.Import(context.Background(), r, func(res Result) { results = append(results, res) })
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Created: 1, Failed: 1}, summary)
+	require.Len(t, kratosAPI.created, 1)
+	assert.Equal(t, map[string]interface{}{"email": "ann@example.com", "age": float64(30), "newsletter": true}, kratosAPI.created[0].Traits)
+}
+
+func TestImportDryRun(t *testing.T) {
+	kratosAPI := &fakeKratos{}
+	im := Importer{Create: kratosAPI.create, Schemas: testSchemas(t), DefaultSchemaID: "default", DryRun: true}
+	r, err := NewReader(strings.NewReader(`{"traits":{"email":"ann@example.com"}}`+"\n"+`{"traits":{}}`), JSONLines)
+	require.NoError(t, err)
+	summary, err := im.Import(context.Background(), r, nil)
+	require.NoError(t, err)
+	assert.Equal(t, Summary{Valid: 1, Failed: 1}, summary)
+	assert.Empty(t, kratosAPI.created)
+}
+
+func TestImportResumes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "progress.jsonl")
+	data := `{"id":"legacy-1","traits":{"email":"ann@example.com"}}
+{"id":"legacy-2","traits":{"email":"taken@example.com"}}
+{"traits":{"email":"cat@example.com"}}
+`
+	run := func() Summary {
+		progress, err := OpenProgress(path)
+		require.NoError(t, err)
+		defer progress.Close()
+		kratosAPI := &fakeKratos{}
+		im := Importer{Create: kratosAPI.create, Schemas: testSchemas(t), DefaultSchemaID: "default", Progress: progress}
+		r, err := NewReader(strings.NewReader(data), JSONLines)
+		require.NoError(t, err)
+		summary, err := im.Import(context.Background(), r, nil)
+		require.NoError(t, err)
+		return summary
+	}
+
+	assert.Equal(t, Summary{Created: 2, Failed: 1}, run())
+	// The records created are skipped, the one that failed is tried again
+	assert.Equal(t, Summary{Skipped: 2, Failed: 1}, run())
+}
+
+func TestExport(t *testing.T) {
+	verified, unverified := true, false
+	all := []kratos.Identity{
+		{Id: "1", SchemaId: "default", Traits: map[string]interface{}{"email": "ann@example.com", "name": map[string]interface{}{"first": "Ann", "last": "Lee"}, "age": float64(30)},
+			VerifiableAddresses: []kratos.VerifiableIdentityAddress{{Value: "ann@example.com", Verified: verified}}},
+		{Id: "2", SchemaId: "default", Traits: map[string]interface{}{"email": "bob@example.com"},
+			VerifiableAddresses: []kratos.VerifiableIdentityAddress{{Value: "bob@example.com", Verified: unverified}}},
+		{Id: "3", SchemaId: "default", Traits: map[string]interface{}{"email": "cat@example.com", "tags": []interface{}{"a", "b"}}},
+	}
+	var pages []int64
+	list := func(ctx context.Context, page, perPage int64) ([]kratos.Identity, error) {
+		pages = append(pages, page)
+		start := page * perPage
+		if start >= int64(len(all)) {
+			return nil, nil
+		}
+		end := start + perPage
+		if end > int64(len(all)) {
+			end = int64(len(all))
+		}
+		return all[start:end], nil
+	}
+
+	var out bytes.Buffer
+	w, err := NewWriter(&out, JSONLines, nil)
+	require.NoError(t, err)
+	n, err := Exporter{List: list, PageSize: 2, Traits: []string{"email", "name.first"}}.Export(context.Background(), w)
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, []int64{0, 1}, pages)
+	assert.Equal(t, `{"id":"1","schema_id":"default","traits":{"email":"ann@example.com","name":{"first":"Ann"}},"verified_addresses":["ann@example.com"]}
+{"id":"2","schema_id":"default","traits":{"email":"bob@example.com"}}
+{"id":"3","schema_id":"default","traits":{"email":"cat@example.com"}}
+`, out.String())
+
+	out.Reset()
+	w, err = NewWriter(&out, CSV, []string{"email", "name.last", "age", "tags"})
+	require.NoError(t, err)
+	_, err = Exporter{List: list, PageSize: 2}.Export(context.Background(), w)
+	require.NoError(t, err)
+	assert.Equal(t, "id,schema_id,state,verified_addresses,traits.email,traits.name.last,traits.age,traits.tags\n"+
+		"1,default,,ann@example.com,ann@example.com,Lee,30,\n"+
+		"2,default,,,bob@example.com,,,\n"+
+		"3,default,,,cat@example.com,,,a;b\n", out.String())
+
+	// An export can be imported again
+	records := readAll(t, CSV, out.String())
+	require.Len(t, records, 3)
+	assert.Equal(t, map[string]interface{}{"email": "cat@example.com", "tags": "a;b"}, records[2].Traits)
+}
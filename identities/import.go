@@ -0,0 +1,235 @@
+package identities
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// DefaultConcurrency is the number of identities created at once, unless configured otherwise
+const DefaultConcurrency = 4
+
+// CreateFunc creates an identity, returning its id
+type CreateFunc func(ctx context.Context, body kratos.AdminCreateIdentityBody) (string, error)
+
+// SchemaFunc returns the identity schema with id, e.g. schema.Loader's Get
+type SchemaFunc func(ctx context.Context, id string) (*schema.Schema, error)
+
+// Importer creates identities from records
+type Importer struct {
+	// Create creates each identity
+	Create CreateFunc
+
+	// Schemas returns the identity schemas the traits are validated against. If nil the traits
+	// are only validated by Kratos.
+	Schemas SchemaFunc
+
+	// DefaultSchemaID is the schema of records without a schema_id
+	DefaultSchemaID string
+
+	// Concurrency is the number of identities created at once, DefaultConcurrency if not set
+	Concurrency int
+
+	// DryRun validates the records without creating any identities
+	DryRun bool
+
+	// Progress optionally records the records imported, those already recorded are skipped. This
+	// lets an import that stopped part way be run again.
+	Progress *Progress
+}
+
+// Result is the outcome of importing a record
+type Result struct {
+	Row int
+	ID  string
+
+	// IdentityID is the id of the identity created
+	IdentityID string
+
+	// Skipped is set if the record was imported before, according to the progress
+	Skipped bool
+
+	// Err is why the record couldn't be imported
+	Err error
+}
+
+// Summary counts the outcomes of an import
+type Summary struct {
+	Created int
+
+	// Valid is the number of records that would have been created by a dry run
+	Valid int
+
+	Skipped int
+	Failed  int
+}
+
+// Import imports the records from r, calling report with the result of each, in the order they
+// complete. It returns early if the context is cancelled or the records can't be read.
+func (im Importer) Import(ctx context.Context, r Reader, report func(Result)) (Summary, error) {
+	concurrency := im.Concurrency
+	if concurrency < 1 {
+		concurrency = DefaultConcurrency
+	}
+
+	records := make(chan Record)
+	results := make(chan Result)
+	var workers sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for rec := range records {
+				results <- im.importRecord(ctx, rec)
+			}
+		}()
+	}
+
+	// Results are collected by one goroutine, so report and the progress needn't be safe for concurrent use
+	var summary Summary
+	var progressErr error
+	collected := make(chan struct{})
+	go func() {
+		defer close(collected)
+		for res := range results {
+			switch {
+			case res.Skipped:
+				summary.Skipped++
+			case res.Err != nil:
+				summary.Failed++
+			case im.DryRun:
+				summary.Valid++
+			default:
+				summary.Created++
+				if im.Progress != nil && progressErr == nil {
+					progressErr = im.Progress.Add(Record{Row: res.Row, ID: res.ID}.key(), res.IdentityID)
+				}
+			}
+			if report != nil {
+				report(res)
+			}
+		}
+	}()
+
+	readErr := im.readRecords(ctx, r, records, results)
+	close(records)
+	workers.Wait()
+	close(results)
+	<-collected
+
+	if readErr != nil {
+		return summary, readErr
+	}
+	if progressErr != nil {
+		return summary, fmt.Errorf("recording progress: %w", progressErr)
+	}
+	return summary, ctx.Err()
+}
+
+// readRecords sends the records to import, and the results of those that can't be read or were imported before
+func (im Importer) readRecords(ctx context.Context, r Reader, records chan<- Record, results chan<- Result) error {
+	for ctx.Err() == nil {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var rowErr *RowError
+		if errors.As(err, &rowErr) {
+			results <- Result{Row: rowErr.Row, ID: rec.ID, Err: rowErr.Err}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("reading records: %w", err)
+		}
+		if im.Progress != nil && im.Progress.Done(rec.key()) {
+			results <- Result{Row: rec.Row, ID: rec.ID, Skipped: true}
+			continue
+		}
+		select {
+		case records <- rec:
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+// importRecord validates a record, and creates its identity unless this is a dry run
+func (im Importer) importRecord(ctx context.Context, rec Record) Result {
+	res := Result{Row: rec.Row, ID: rec.ID}
+	body, err := im.validate(ctx, rec)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if im.DryRun {
+		return res
+	}
+	res.IdentityID, res.Err = im.Create(ctx, body)
+	return res
+}
+
+// validate checks the record against its identity schema, returning the request that creates it
+func (im Importer) validate(ctx context.Context, rec Record) (kratos.AdminCreateIdentityBody, error) {
+	if len(rec.Traits) == 0 {
+		return kratos.AdminCreateIdentityBody{}, errors.New("the record has no traits")
+	}
+	schemaID := rec.SchemaID
+	if schemaID == "" {
+		schemaID = im.DefaultSchemaID
+	}
+	if im.Schemas != nil {
+		s, err := im.Schemas(ctx, schemaID)
+		if err != nil {
+			return kratos.AdminCreateIdentityBody{}, fmt.Errorf("loading identity schema '%s': %w", schemaID, err)
+		}
+		if rec.textTraits {
+			typeTraits(s.Traits, rec.Traits)
+		}
+		if problems := s.Validate(rec.Traits); len(problems) > 0 {
+			return kratos.AdminCreateIdentityBody{}, errors.New(strings.Join(problems, "; "))
+		}
+	}
+	return rec.CreateBody(im.DefaultSchemaID)
+}
+
+// typeTraits converts the text values of traits read from CSV to the types of their properties. Values
+// that can't be converted are left as text, for validation to report.
+func typeTraits(props []schema.Property, traits map[string]interface{}) {
+	for _, p := range props {
+		v, ok := traits[p.Name]
+		if !ok {
+			continue
+		}
+		if child, ok := v.(map[string]interface{}); ok {
+			typeTraits(p.Properties, child)
+			continue
+		}
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+		switch p.Type {
+		case "number", "integer":
+			if n, err := strconv.ParseFloat(s, 64); err == nil {
+				traits[p.Name] = n
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(s); err == nil {
+				traits[p.Name] = b
+			}
+		case "array":
+			list := []interface{}{}
+			for _, e := range splitList(s) {
+				list = append(list, e)
+			}
+			traits[p.Name] = list
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package identities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// KratosCreate returns a CreateFunc creating identities with the Kratos admin API
+func KratosCreate(admin *kratos.APIClient) CreateFunc {
+	return func(ctx context.Context, body kratos.AdminCreateIdentityBody) (string, error) {
+		identity, _, err := admin.V0alpha2Api.AdminCreateIdentity(ctx).AdminCreateIdentityBody(body).Execute()
+		if err != nil {
+			return "", apiError(err)
+		}
+		return identity.Id, nil
+	}
+}
+
+// KratosList returns a ListFunc listing identities with the Kratos admin API
+func KratosList(admin *kratos.APIClient) ListFunc {
+	return func(ctx context.Context, page, perPage int64) ([]kratos.Identity, error) {
+		identities, _, err := admin.V0alpha2Api.AdminListIdentities(ctx).Page(page).PerPage(perPage).Execute()
+		if err != nil {
+			return nil, apiError(err)
+		}
+		return identities, nil
+	}
+}
+
+// apiError returns the reason Kratos gave for an error, which is more useful in a report than the HTTP status
+func apiError(err error) error {
+	var apiErr *kratos.GenericOpenAPIError
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	var body struct {
+		Error struct {
+			Reason  string `json:"reason"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(apiErr.Body(), &body) != nil {
+		return err
+	}
+	switch {
+	case body.Error.Reason != "":
+		return errors.New(body.Error.Reason)
+	case body.Error.Message != "":
+		return errors.New(body.Error.Message)
+	}
+	return err
+}
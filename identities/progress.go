@@ -0,0 +1,78 @@
+package identities
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// Progress records the records imported in a JSON lines file, so an import that stopped part way
+// can be run again without creating identities twice. Records are identified by their id, or by
+// their row if they have none, so the file being imported must not be reordered between runs.
+type Progress struct {
+	mu   sync.Mutex
+	done map[string]string
+	f    *os.File
+}
+
+// progressEntry is a line of the progress file
+type progressEntry struct {
+	Key        string `json:"key"`
+	IdentityID string `json:"identity_id"`
+}
+
+// OpenProgress reads the progress file at path, creating it if required, and appends to it
+func OpenProgress(path string) (*Progress, error) {
+	p := &Progress{done: make(map[string]string)}
+	if f, err := os.Open(path); err == nil {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var e progressEntry
+			// A line cut short by the previous import being killed is ignored, the record is imported again
+			if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Key != "" {
+				p.done[e.Key] = e.IdentityID
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	p.f = f
+	return p, nil
+}
+
+// Done reports if the record with key has been imported
+func (p *Progress) Done(key string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.done[key]
+	return ok
+}
+
+// Add records that the record with key was imported as the identity with identityID
+func (p *Progress) Add(key, identityID string) error {
+	line, err := json.Marshal(progressEntry{Key: key, IdentityID: identityID})
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done[key] = identityID
+	_, err = p.f.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the progress file
+func (p *Progress) Close() error {
+	return p.f.Close()
+}
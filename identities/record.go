@@ -0,0 +1,203 @@
+// Package identities imports identities into Kratos in bulk, e.g. when migrating users from another
+// system, and exports them again. Identities are read and written as JSON lines or CSV records.
+package identities
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// nilUUID is sent as the id of imported addresses, so Kratos generates one
+const nilUUID = "00000000-0000-0000-0000-000000000000"
+
+// importIDKey is the admin metadata key holding the id an identity was imported with
+const importIDKey = "import_id"
+
+// Record is an identity in an import or export file
+type Record struct {
+	// Row is the line or row the record was read from, counting from 1. It is not written.
+	Row int `json:"-"`
+
+	// ID identifies the record. Imported records may give their id in the source system, which is kept
+	// in the identity's admin metadata as "import_id", and identifies the record when an import is resumed.
+	// Exported records have the Kratos identity id.
+	ID string `json:"id,omitempty"`
+
+	// SchemaID is the identity schema of the traits, imports default to the 'identity-schema-id' option
+	SchemaID string `json:"schema_id,omitempty"`
+
+	// State is "active" or "inactive", imports default to active
+	State string `json:"state,omitempty"`
+
+	Traits map[string]interface{} `json:"traits"`
+
+	// PasswordHash is the hashed password to import, in a format Kratos can import, e.g. bcrypt "$2a$12$...",
+	// or PHC strings such as "$argon2id$v=19$...". Passwords are never exported.
+	PasswordHash string `json:"password_hash,omitempty"`
+
+	// VerifiedAddresses are the email addresses in the traits that have been verified
+	VerifiedAddresses []string `json:"verified_addresses,omitempty"`
+
+	// textTraits is set if the traits were read as text, from CSV, and need converting to the schema's types
+	textTraits bool
+}
+
+// key identifies the record in the progress of an import
+func (r Record) key() string {
+	if r.ID != "" {
+		return "id:" + r.ID
+	}
+	return fmt.Sprintf("row:%d", r.Row)
+}
+
+// CreateBody returns the admin API request that creates the record's identity
+func (r Record) CreateBody(defaultSchemaID string) (kratos.AdminCreateIdentityBody, error) {
+	body := kratos.AdminCreateIdentityBody{
+		SchemaId: r.SchemaID,
+		Traits:   r.Traits,
+	}
+	if body.SchemaId == "" {
+		body.SchemaId = defaultSchemaID
+	}
+	if r.State != "" {
+		state, err := kratos.NewIdentityStateFromValue(r.State)
+		if err != nil {
+			return body, fmt.Errorf("state must be 'active' or 'inactive', got '%s'", r.State)
+		}
+		body.State = state
+	}
+	if r.ID != "" {
+		body.MetadataAdmin = map[string]interface{}{importIDKey: r.ID}
+	}
+	if r.PasswordHash != "" {
+		hash := r.PasswordHash
+		body.Credentials = &kratos.AdminIdentityImportCredentials{
+			Password: &kratos.AdminCreateIdentityImportCredentialsPassword{
+				Config: &kratos.AdminCreateIdentityImportCredentialsPasswordConfig{HashedPassword: &hash},
+			},
+		}
+	}
+	now := time.Now().UTC()
+	for _, a := range r.VerifiedAddresses {
+		if !hasTraitValue(r.Traits, a) {
+			return body, fmt.Errorf("verified address '%s' is not one of the traits", a)
+		}
+		verifiedAt := now
+		body.VerifiableAddresses = append(body.VerifiableAddresses, kratos.VerifiableIdentityAddress{
+			Id:         nilUUID,
+			Value:      a,
+			Verified:   true,
+			VerifiedAt: &verifiedAt,
+			Via:        "email",
+			Status:     "completed",
+		})
+	}
+	return body, nil
+}
+
+// FromIdentity returns the record of an exported identity. If traits are given only those
+// trait paths, e.g. "email" or "name.first", are exported.
+func FromIdentity(i kratos.Identity, traits []string) Record {
+	r := Record{ID: i.Id, SchemaID: i.SchemaId}
+	if i.State != nil {
+		r.State = string(*i.State)
+	}
+	t, _ := i.Traits.(map[string]interface{})
+	r.Traits = Project(t, traits)
+	for _, a := range i.VerifiableAddresses {
+		if a.Verified {
+			r.VerifiedAddresses = append(r.VerifiedAddresses, a.Value)
+		}
+	}
+	return r
+}
+
+// Project returns the traits at the dotted paths, nested as they are in traits. All the traits are
+// returned if there are no paths.
+func Project(traits map[string]interface{}, paths []string) map[string]interface{} {
+	if len(paths) == 0 {
+		return traits
+	}
+	projected := make(map[string]interface{})
+	for _, p := range paths {
+		if v, ok := lookup(traits, p); ok {
+			set(projected, p, v)
+		}
+	}
+	return projected
+}
+
+// lookup returns the value at a dotted path within traits
+func lookup(traits map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = traits
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if v, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// set sets the value at a dotted path within traits, creating the objects along it
+func set(traits map[string]interface{}, path string, v interface{}) {
+	parts := strings.Split(path, ".")
+	m := traits
+	for _, part := range parts[:len(parts)-1] {
+		child, ok := m[part].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			m[part] = child
+		}
+		m = child
+	}
+	m[parts[len(parts)-1]] = v
+}
+
+// hasTraitValue reports if s is the value of a trait, or in a list of values
+func hasTraitValue(v interface{}, s string) bool {
+	switch t := v.(type) {
+	case string:
+		return t == s
+	case map[string]interface{}:
+		for _, child := range t {
+			if hasTraitValue(child, s) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, child := range t {
+			if hasTraitValue(child, s) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ParseTraits splits comma separated trait paths, e.g. "email, name.first"
+func ParseTraits(s string) []string {
+	var traits []string
+	for _, t := range strings.Split(s, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			traits = append(traits, t)
+		}
+	}
+	return traits
+}
+
+// SchemaTraits returns the dotted paths of the traits in an identity schema, the default CSV columns
+func SchemaTraits(s *schema.Schema) []string {
+	var traits []string
+	for _, f := range s.Fields(nil) {
+		traits = append(traits, f.Path)
+	}
+	return traits
+}
@@ -0,0 +1,36 @@
+package identities
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// ErrorReport writes the records that failed to import as CSV, giving the row, id and error of each
+type ErrorReport struct {
+	w           *csv.Writer
+	wroteHeader bool
+}
+
+// NewErrorReport returns a report written to w
+func NewErrorReport(w io.Writer) *ErrorReport {
+	return &ErrorReport{w: csv.NewWriter(w)}
+}
+
+// Add writes res to the report if it failed
+func (er *ErrorReport) Add(res Result) {
+	if res.Err == nil {
+		return
+	}
+	if !er.wroteHeader {
+		er.w.Write([]string{"row", "id", "error"})
+		er.wroteHeader = true
+	}
+	er.w.Write([]string{strconv.Itoa(res.Row), res.ID, res.Err.Error()})
+}
+
+// Flush writes any buffered rows
+func (er *ErrorReport) Flush() error {
+	er.w.Flush()
+	return er.w.Error()
+}
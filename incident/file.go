@@ -0,0 +1,41 @@
+package incident
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// FileSink appends incidents to a file as JSON lines
+type FileSink struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileSink opens, creating if required, the JSON lines file at path
+func NewFileSink(path string) (*FileSink, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{f: f}, nil
+}
+
+// Write appends i to the file
+func (s *FileSink) Write(i Incident) error {
+	line, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.f.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,103 @@
+// Package incident records unexpected failures while handling requests, such as panics and pages that can't
+// be rendered. Each gets an ID that is shown to the user, so support can find it in the logs and sinks.
+package incident
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
+)
+
+// Kinds of incident
+const (
+	Panic  = "panic"
+	Render = "render"
+)
+
+// Incident is an unexpected failure while handling a request
+type Incident struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Kind      string    `json:"kind"`
+	Method    string    `json:"method"`
+	Path      string    `json:"path"`
+	IP        string    `json:"ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Error     string    `json:"error"`
+	Stack     string    `json:"stack,omitempty"`
+}
+
+// Sink stores or forwards incidents
+type Sink interface {
+	Write(i Incident) error
+	Close() error
+}
+
+var (
+	mu    sync.RWMutex
+	sinks []Sink
+)
+
+// Init sets the sinks that incidents are written to, as well as the log
+func Init(s ...Sink) {
+	mu.Lock()
+	defer mu.Unlock()
+	sinks = s
+}
+
+// Close closes all the sinks
+func Close() error {
+	mu.Lock()
+	defer mu.Unlock()
+	var firstErr error
+	for _, s := range sinks {
+		if err := s.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	sinks = nil
+	return firstErr
+}
+
+// Report records an incident of kind that happened while handling r, logging it and writing it to the sinks.
+// stack is optional.
+func Report(r *http.Request, kind string, err error, stack []byte) Incident {
+	i := Incident{
+		ID:        newID(),
+		Time:      time.Now().UTC(),
+		Kind:      kind,
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		IP:        clientip.Of(r),
+		UserAgent: r.UserAgent(),
+		Error:     err.Error(),
+		Stack:     string(stack),
+	}
+	log.Printf("Incident %s: %s handling %s %s from %s: %s", i.ID, i.Kind, i.Method, i.Path, i.IP, i.Error)
+	if len(stack) > 0 {
+		log.Printf("Incident %s stack:\n%s", i.ID, stack)
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	for _, s := range sinks {
+		if err := s.Write(i); err != nil {
+			log.Printf("Error writing incident %s: %v", i.ID, err)
+		}
+	}
+	return i
+}
+
+// newID returns a short random ID that users can quote
+func newID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return time.Now().UTC().Format("20060102150405.000000000")
+	}
+	return hex.EncodeToString(b)
+}
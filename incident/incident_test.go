@@ -0,0 +1,57 @@
+package incident
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "incidents.jsonl")
+	file, err := NewFileSink(path)
+	require.NoError(t, err)
+
+	posted := make(chan Incident, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var i Incident
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&i))
+		posted <- i
+	}))
+	defer srv.Close()
+
+	Init(file, NewWebhookSink(srv.URL))
+	r := httptest.NewRequest(http.MethodGet, "/dashboard?flow=1", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("User-Agent", "test")
+	i := Report(r, Panic, errors.New("boom"), []byte("goroutine 1"))
+	require.NoError(t, Close())
+
+	assert.Len(t, i.ID, 16)
+	assert.Equal(t, Panic, i.Kind)
+	assert.Equal(t, "/dashboard", i.Path)
+	assert.Equal(t, "10.0.0.1", i.IP)
+	assert.Equal(t, "boom", i.Error)
+	assert.Equal(t, "goroutine 1", i.Stack)
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	require.True(t, scanner.Scan())
+	var written Incident
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &written))
+	assert.Equal(t, i.ID, written.ID)
+
+	assert.Equal(t, i.ID, (<-posted).ID)
+
+	// Each incident gets its own ID
+	assert.NotEqual(t, i.ID, Report(r, Render, errors.New("boom"), nil).ID)
+}
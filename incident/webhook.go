@@ -0,0 +1,69 @@
+package incident
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhookTimeout limits each call to the webhook
+const webhookTimeout = 5 * time.Second
+
+// WebhookSink posts incidents as JSON to a URL, e.g. a chat or alerting service. Incidents are posted in the
+// background, so a slow webhook doesn't delay the error page.
+type WebhookSink struct {
+	URL string
+
+	// Client calls the webhook, one with a short timeout if nil
+	Client *http.Client
+
+	wg sync.WaitGroup
+}
+
+// NewWebhookSink returns a sink posting incidents to url
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Write posts i to the webhook in the background, failures are logged
+func (s *WebhookSink) Write(i Incident) error {
+	body, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		if err := s.post(body); err != nil {
+			log.Printf("Error posting incident %s to webhook: %v", i.ID, err)
+		}
+	}()
+	return nil
+}
+
+// post sends an incident to the webhook
+func (s *WebhookSink) post(body []byte) error {
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: webhookTimeout}
+	}
+	resp, err := client.Post(s.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// Close waits for the incidents being posted
+func (s *WebhookSink) Close() error {
+	s.wg.Wait()
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package invites onboards users by invitation. Creating an invite creates the user's identity, without
+// credentials, and emails them a link. Accepting the invite signs them in with a Kratos recovery link, so
+// they can set their password.
+package invites
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Status is the state of an invite
+type Status string
+
+// Invite statuses
+const (
+	Pending  = Status("pending")
+	Accepted = Status("accepted")
+	Expired  = Status("expired")
+	Revoked  = Status("revoked")
+)
+
+// Invite is an invitation for a user to set up their account
+type Invite struct {
+	ID    string `json:"id"`
+	Email string `json:"email"`
+
+	// Traits are the identity's traits, set by the admin, as well as the email
+	Traits map[string]interface{} `json:"traits,omitempty"`
+
+	// IdentityID is the identity created for the invite
+	IdentityID string `json:"identity_id"`
+
+	// TokenHash is the SHA-256 hash of the secret in the invite link, the link itself is not kept
+	TokenHash string `json:"token_hash"`
+
+	// CreatedBy is the identity id of the admin who created the invite
+	CreatedBy string `json:"created_by,omitempty"`
+
+	// TTL is how long each link sent can be accepted for
+	TTL time.Duration `json:"ttl"`
+
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	SentAt     *time.Time `json:"sent_at,omitempty"`
+	AcceptedAt *time.Time `json:"accepted_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Status returns the status of the invite at now
+func (i Invite) Status(now time.Time) Status {
+	switch {
+	case i.AcceptedAt != nil:
+		return Accepted
+	case i.RevokedAt != nil:
+		return Revoked
+	case !now.Before(i.ExpiresAt):
+		return Expired
+	}
+	return Pending
+}
+
+// errBadToken is returned when an invite token is malformed
+var errBadToken = errors.New("malformed invite token")
+
+// newID returns a random invite id
+func newID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// newToken returns a token for the invite with id, which is "<id>.<secret>", and the hash of the secret
+func newToken(id string) (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	return id + "." + secret, hashSecret(secret), nil
+}
+
+// splitToken returns the invite id and secret in a token
+func splitToken(token string) (id, secret string, err error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errBadToken
+	}
+	return parts[0], parts[1], nil
+}
+
+// matches reports if secret is the invite's, comparing the hashes in constant time
+func (i Invite) matches(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(secret)), []byte(i.TokenHash)) == 1
+}
+
+// hashSecret returns the hex SHA-256 hash of an invite secret
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
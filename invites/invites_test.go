@@ -0,0 +1,252 @@
+package invites
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/mailer"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeIdentities records the identities created and deleted, and those that have credentials
+type fakeIdentities struct {
+	created     []kratos.AdminCreateIdentityBody
+	deleted     []string
+	credentials map[string]bool
+}
+
+func (f *fakeIdentities) Create(ctx context.Context, body kratos.AdminCreateIdentityBody) (string, error) {
+	if body.Traits["email"] == "taken@example.com" {
+		return "", errors.New("This identity conflicts with another identity that already exists.")
+	}
+	f.created = append(f.created, body)
+	return fmt.Sprintf("identity-%d", len(f.created)), nil
+}
+
+func (f *fakeIdentities) Delete(ctx context.Context, id string) error {
+	f.deleted = append(f.deleted, id)
+	return nil
+}
+
+func (f *fakeIdentities) RecoveryLink(ctx context.Context, id string) (string, error) {
+	return "https://kratos.example.com/self-service/recovery?flow=1&token=" + id, nil
+}
+
+func (f *fakeIdentities) HasCredentials(ctx context.Context, id string) (bool, error) {
+	return f.credentials[id], nil
+}
+
+// fakeMailer records the emails sent, failing if err is set
+type fakeMailer struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (f *fakeMailer) Send(ctx context.Context, m mailer.Message) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, m)
+	return nil
+}
+
+type clock struct{ now time.Time }
+
+func (c *clock) Now() time.Time { return c.now }
+
+func newService(store Store) (*Service, *fakeIdentities, *fakeMailer, *clock) {
+	ids, m := &fakeIdentities{credentials: map[string]bool{}}, &fakeMailer{}
+	c := &clock{now: time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)}
+	return &Service{
+		Store:      store,
+		Identities: ids,
+		Mailer:     m,
+		AcceptURL:  func(token string) string { return "https://app.example.com/invitation?token=" + token },
+		SchemaID:   "default",
+		TTL:        48 * time.Hour,
+		Subject:    "You're invited",
+		Now:        c.Now,
+	}, ids, m, c
+}
+
+// tokenOf returns the token in an accept link
+func tokenOf(link string) string {
+	return strings.TrimPrefix(link, "https://app.example.com/invitation?token=")
+}
+
+func TestCreateAndAccept(t *testing.T) {
+	s, ids, m, _ := newService(NewMemoryStore())
+	inv, link, err := s.Create(context.Background(), "ann@example.com", map[string]interface{}{"name": map[string]interface{}{"first": "Ann"}}, 0, "admin-1")
+	require.NoError(t, err)
+
+	require.Len(t, ids.created, 1)
+	assert.Equal(t, "default", ids.created[0].SchemaId)
+	assert.Equal(t, map[string]interface{}{"email": "ann@example.com", "name": map[string]interface{}{"first": "Ann"}}, ids.created[0].Traits)
+	assert.Equal(t, map[string]interface{}{"invite_id": inv.ID}, ids.created[0].MetadataAdmin)
+	assert.Equal(t, "identity-1", inv.IdentityID)
+	assert.Equal(t, Pending, inv.Status(s.Now()))
+	assert.NotNil(t, inv.SentAt)
+
+	require.Len(t, m.sent, 1)
+	assert.Equal(t, "ann@example.com", m.sent[0].To)
+	assert.Equal(t, "You're invited", m.sent[0].Subject)
+	assert.Contains(t, m.sent[0].Body, link)
+	assert.Contains(t, m.sent[0].Body, "3 Jun 2021 12:00 UTC")
+	assert.NotContains(t, inv.TokenHash, tokenOf(link))
+
+	_, err = s.Find(inv.ID + ".wrong")
+	assert.Equal(t, ErrNotFound, err)
+
+	found, err := s.Find(tokenOf(link))
+	require.NoError(t, err)
+	assert.Equal(t, inv.ID, found.ID)
+
+	accepted, recoveryLink, err := s.Accept(context.Background(), tokenOf(link))
+	require.NoError(t, err)
+	assert.Equal(t, "https://kratos.example.com/self-service/recovery?flow=1&token=identity-1", recoveryLink)
+	assert.Equal(t, Accepted, accepted.Status(s.Now()))
+
+	// Until a password is set the invite is still pending, so an abandoned invite can be accepted again
+	status, err := s.CurrentStatus(context.Background(), accepted)
+	require.NoError(t, err)
+	assert.Equal(t, Pending, status)
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	assert.NoError(t, err)
+
+	// Once it is the link can't be used again, and the invite can't be resent or revoked
+	ids.credentials["identity-1"] = true
+	status, err = s.CurrentStatus(context.Background(), accepted)
+	require.NoError(t, err)
+	assert.Equal(t, Accepted, status)
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	assert.Equal(t, ErrNotPending, err)
+	_, _, err = s.Resend(context.Background(), inv.ID)
+	assert.Equal(t, ErrNotPending, err)
+	_, err = s.Revoke(context.Background(), inv.ID)
+	assert.Equal(t, ErrNotPending, err)
+	assert.Empty(t, ids.deleted)
+}
+
+func TestAbandonedAccept(t *testing.T) {
+	s, ids, m, c := newService(NewMemoryStore())
+	inv, link, err := s.Create(context.Background(), "ann@example.com", nil, time.Hour, "admin-1")
+	require.NoError(t, err)
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	require.NoError(t, err)
+
+	// An invite accepted without setting a password expires as usual, and can be resent
+	c.now = c.now.Add(2 * time.Hour)
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	assert.Equal(t, ErrNotPending, err)
+	resent, link, err := s.Resend(context.Background(), inv.ID)
+	require.NoError(t, err)
+	assert.Nil(t, resent.AcceptedAt)
+	assert.Equal(t, Pending, resent.Status(c.now))
+	assert.Len(t, m.sent, 2)
+
+	// or revoked
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	require.NoError(t, err)
+	revoked, err := s.Revoke(context.Background(), inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Revoked, revoked.Status(c.now))
+	assert.Equal(t, []string{"identity-1"}, ids.deleted)
+}
+
+func TestCreateErrors(t *testing.T) {
+	s, ids, m, _ := newService(NewMemoryStore())
+	_, _, err := s.Create(context.Background(), "Ann <ann@example.com>", nil, 0, "admin-1")
+	assert.EqualError(t, err, "'Ann <ann@example.com>' is not an email address")
+
+	_, _, err = s.Create(context.Background(), "taken@example.com", nil, 0, "admin-1")
+	assert.EqualError(t, err, "creating identity: This identity conflicts with another identity that already exists.")
+	assert.Empty(t, ids.created)
+
+	m.err = errors.New("connection refused")
+	inv, link, err := s.Create(context.Background(), "bob@example.com", nil, 0, "admin-1")
+	assert.True(t, errors.Is(err, ErrNotSent))
+	assert.NotEmpty(t, link)
+	assert.Nil(t, inv.SentAt)
+	list, err := s.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, Pending, list[0].Status(s.Now()))
+}
+
+func TestExpireAndResend(t *testing.T) {
+	s, _, m, c := newService(NewMemoryStore())
+	inv, oldLink, err := s.Create(context.Background(), "ann@example.com", nil, time.Hour, "admin-1")
+	require.NoError(t, err)
+	assert.Equal(t, c.now.Add(time.Hour), inv.ExpiresAt)
+
+	c.now = c.now.Add(2 * time.Hour)
+	_, _, err = s.Accept(context.Background(), tokenOf(oldLink))
+	assert.Equal(t, ErrNotPending, err)
+	inv, err = s.Store.Get(inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Expired, inv.Status(c.now))
+
+	resent, link, err := s.Resend(context.Background(), inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Pending, resent.Status(c.now))
+	assert.Equal(t, c.now.Add(time.Hour), resent.ExpiresAt)
+	assert.Len(t, m.sent, 2)
+
+	// Only the new link works
+	_, err = s.Find(tokenOf(oldLink))
+	assert.Equal(t, ErrNotFound, err)
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	assert.NoError(t, err)
+}
+
+func TestRevoke(t *testing.T) {
+	s, ids, _, _ := newService(NewMemoryStore())
+	inv, link, err := s.Create(context.Background(), "ann@example.com", nil, 0, "admin-1")
+	require.NoError(t, err)
+
+	revoked, err := s.Revoke(context.Background(), inv.ID)
+	require.NoError(t, err)
+	assert.Equal(t, Revoked, revoked.Status(s.Now()))
+	assert.Equal(t, []string{"identity-1"}, ids.deleted)
+
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	assert.Equal(t, ErrNotPending, err)
+	_, _, err = s.Resend(context.Background(), inv.ID)
+	assert.Equal(t, ErrNotPending, err)
+	_, err = s.Revoke(context.Background(), "unknown")
+	assert.Equal(t, ErrNotFound, err)
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invites.jsonl")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	s, _, _, _ := newService(store)
+	first, _, err := s.Create(context.Background(), "ann@example.com", nil, 0, "admin-1")
+	require.NoError(t, err)
+	s.Now = func() time.Time { return time.Date(2021, 6, 2, 12, 0, 0, 0, time.UTC) }
+	second, link, err := s.Create(context.Background(), "bob@example.com", nil, 0, "admin-1")
+	require.NoError(t, err)
+	_, _, err = s.Accept(context.Background(), tokenOf(link))
+	require.NoError(t, err)
+	require.NoError(t, store.Close())
+
+	// The invites are read back in their latest state
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	defer store.Close()
+	list, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, second.ID, list[0].ID)
+	assert.Equal(t, Accepted, list[0].Status(s.Now()))
+	assert.Equal(t, first.ID, list[1].ID)
+	assert.Equal(t, Pending, list[1].Status(s.Now()))
+}
@@ -0,0 +1,53 @@
+package invites
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/identities"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// kratosIdentities manages identities with the Kratos admin API
+type kratosIdentities struct {
+	admin  *kratos.APIClient
+	create identities.CreateFunc
+}
+
+// KratosIdentities returns Identities managed with the Kratos admin API
+func KratosIdentities(admin *kratos.APIClient) Identities {
+	return kratosIdentities{admin: admin, create: identities.KratosCreate(admin)}
+}
+
+// Create creates an identity, returning its id
+func (k kratosIdentities) Create(ctx context.Context, body kratos.AdminCreateIdentityBody) (string, error) {
+	return k.create(ctx, body)
+}
+
+// Delete deletes an identity, identities already deleted are ignored
+func (k kratosIdentities) Delete(ctx context.Context, id string) error {
+	resp, err := k.admin.V0alpha2Api.AdminDeleteIdentity(ctx, id).Execute()
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+// RecoveryLink returns a recovery link for the identity, it expires as configured in Kratos
+func (k kratosIdentities) RecoveryLink(ctx context.Context, id string) (string, error) {
+	link, _, err := k.admin.V0alpha2Api.AdminCreateSelfServiceRecoveryLink(ctx).
+		AdminCreateSelfServiceRecoveryLinkBody(kratos.AdminCreateSelfServiceRecoveryLinkBody{IdentityId: id}).Execute()
+	if err != nil {
+		return "", err
+	}
+	return link.RecoveryLink, nil
+}
+
+// HasCredentials reports if the identity has any credentials
+func (k kratosIdentities) HasCredentials(ctx context.Context, id string) (bool, error) {
+	identity, _, err := k.admin.V0alpha2Api.AdminGetIdentity(ctx, id).Execute()
+	if err != nil {
+		return false, err
+	}
+	return len(identity.GetCredentials()) > 0, nil
+}
@@ -0,0 +1,275 @@
+package invites
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"text/template"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/mailer"
+	kratos "github.com/ory/kratos-client-go"
+)
+
+// DefaultTTL is how long an invite can be accepted for, unless configured otherwise
+const DefaultTTL = 7 * 24 * time.Hour
+
+// inviteIDKey is the admin metadata key holding the id of the invite an identity was created for
+const inviteIDKey = "invite_id"
+
+var (
+	// ErrNotPending is returned when an invite that has been accepted, revoked or has expired is used
+	ErrNotPending = errors.New("the invite is no longer pending")
+
+	// ErrNotSent wraps the error sending an invite email, the invite has been saved and can be resent
+	ErrNotSent = errors.New("the invite email could not be sent")
+)
+
+// Identities manages the identities that invites are created for
+type Identities interface {
+	// Create creates an identity, returning its id
+	Create(ctx context.Context, body kratos.AdminCreateIdentityBody) (string, error)
+
+	// Delete deletes an identity
+	Delete(ctx context.Context, id string) error
+
+	// RecoveryLink returns a link that signs the identity in, so they can set their password
+	RecoveryLink(ctx context.Context, id string) (string, error)
+
+	// HasCredentials reports if the identity has set up a way to sign in, such as a password
+	HasCredentials(ctx context.Context, id string) (bool, error)
+}
+
+// inviteEmail is the body of the invite email
+var inviteEmail = template.Must(template.New("invite").Parse(`Hello,
+
+You have been invited to set up an account. Follow the link below to accept the invitation and
+choose your password. The link can be used until {{.ExpiresAt.Format "2 Jan 2006 15:04 MST"}}.
+
+{{.Link}}
+
+If you weren't expecting this invitation you can ignore this email.
+`))
+
+// Service creates, sends and accepts invites
+type Service struct {
+	Store      Store
+	Identities Identities
+	Mailer     mailer.Mailer
+
+	// AcceptURL returns the URL of the page accepting the invite with token
+	AcceptURL func(token string) string
+
+	// SchemaID is the identity schema of the identities created
+	SchemaID string
+
+	// TTL is how long an invite can be accepted for when none is given, DefaultTTL if not set
+	TTL time.Duration
+
+	// Subject is the subject of the invite email
+	Subject string
+
+	// Now returns the current time, time.Now if not set
+	Now func() time.Time
+}
+
+// Create creates an identity with the email and traits, and emails an invite to it that can be accepted for ttl,
+// or the service's TTL if 0. The link sent is returned, so it can be given to the user another way. If the email
+// can't be sent the invite is returned along with an error wrapping ErrNotSent.
+func (s *Service) Create(ctx context.Context, email string, traits map[string]interface{}, ttl time.Duration, createdBy string) (Invite, string, error) {
+	addr, err := mail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return Invite{}, "", fmt.Errorf("'%s' is not an email address", email)
+	}
+	id, err := newID()
+	if err != nil {
+		return Invite{}, "", err
+	}
+	all := map[string]interface{}{}
+	for k, v := range traits {
+		all[k] = v
+	}
+	all["email"] = email
+
+	identityID, err := s.Identities.Create(ctx, kratos.AdminCreateIdentityBody{
+		SchemaId:      s.SchemaID,
+		Traits:        all,
+		MetadataAdmin: map[string]interface{}{inviteIDKey: id},
+	})
+	if err != nil {
+		return Invite{}, "", fmt.Errorf("creating identity: %w", err)
+	}
+	inv := Invite{
+		ID:         id,
+		Email:      email,
+		Traits:     all,
+		IdentityID: identityID,
+		TTL:        ttl,
+		CreatedBy:  createdBy,
+		CreatedAt:  s.now(),
+	}
+	if inv.TTL <= 0 {
+		inv.TTL = s.ttl()
+	}
+	return s.send(ctx, inv)
+}
+
+// Resend sends a new link for an invite, which can be accepted for the invite's TTL again. Links sent before no longer work.
+func (s *Service) Resend(ctx context.Context, id string) (Invite, string, error) {
+	inv, err := s.Store.Get(id)
+	if err != nil {
+		return Invite{}, "", err
+	}
+	status, err := s.CurrentStatus(ctx, inv)
+	if err != nil {
+		return inv, "", err
+	}
+	if status != Pending && status != Expired {
+		return inv, "", ErrNotPending
+	}
+	inv.AcceptedAt = nil
+	return s.send(ctx, inv)
+}
+
+// send saves the invite with a new token and expiry, then emails the link to it
+func (s *Service) send(ctx context.Context, inv Invite) (Invite, string, error) {
+	token, hash, err := newToken(inv.ID)
+	if err != nil {
+		return inv, "", err
+	}
+	inv.TokenHash = hash
+	inv.ExpiresAt = s.now().Add(inv.TTL)
+	inv.SentAt = nil
+	if err := s.Store.Put(inv); err != nil {
+		return inv, "", fmt.Errorf("saving invite: %w", err)
+	}
+
+	link := s.AcceptURL(token)
+	var body bytes.Buffer
+	if err := inviteEmail.Execute(&body, struct {
+		Link      string
+		ExpiresAt time.Time
+	}{link, inv.ExpiresAt}); err != nil {
+		return inv, link, err
+	}
+	if err := s.Mailer.Send(ctx, mailer.Message{To: inv.Email, Subject: s.Subject, Body: body.String()}); err != nil {
+		return inv, link, fmt.Errorf("%w: %v", ErrNotSent, err)
+	}
+	sentAt := s.now()
+	inv.SentAt = &sentAt
+	if err := s.Store.Put(inv); err != nil {
+		return inv, link, fmt.Errorf("saving invite: %w", err)
+	}
+	return inv, link, nil
+}
+
+// Revoke revokes an invite that hasn't been accepted, and deletes its identity
+func (s *Service) Revoke(ctx context.Context, id string) (Invite, error) {
+	inv, err := s.Store.Get(id)
+	if err != nil {
+		return Invite{}, err
+	}
+	status, err := s.CurrentStatus(ctx, inv)
+	if err != nil {
+		return inv, err
+	}
+	if status != Pending && status != Expired {
+		return inv, ErrNotPending
+	}
+	if err := s.Identities.Delete(ctx, inv.IdentityID); err != nil {
+		return inv, fmt.Errorf("deleting identity: %w", err)
+	}
+	revokedAt := s.now()
+	inv.AcceptedAt, inv.RevokedAt = nil, &revokedAt
+	if err := s.Store.Put(inv); err != nil {
+		return inv, fmt.Errorf("saving invite: %w", err)
+	}
+	return inv, nil
+}
+
+// Find returns the invite with token, whatever its status, or ErrNotFound
+func (s *Service) Find(token string) (Invite, error) {
+	id, secret, err := splitToken(token)
+	if err != nil {
+		return Invite{}, ErrNotFound
+	}
+	inv, err := s.Store.Get(id)
+	if err != nil {
+		return Invite{}, err
+	}
+	if !inv.matches(secret) {
+		return Invite{}, ErrNotFound
+	}
+	return inv, nil
+}
+
+// Accept accepts the pending invite with token, returning the recovery link that signs its identity in.
+// Until the user has set their password the invite can be accepted again, e.g. if the recovery link expired.
+func (s *Service) Accept(ctx context.Context, token string) (Invite, string, error) {
+	inv, err := s.Find(token)
+	if err != nil {
+		return Invite{}, "", err
+	}
+	status, err := s.CurrentStatus(ctx, inv)
+	if err != nil {
+		return inv, "", err
+	}
+	if status != Pending {
+		return inv, "", ErrNotPending
+	}
+	link, err := s.Identities.RecoveryLink(ctx, inv.IdentityID)
+	if err != nil {
+		return inv, "", fmt.Errorf("creating recovery link: %w", err)
+	}
+	acceptedAt := s.now()
+	inv.AcceptedAt = &acceptedAt
+	if err := s.Store.Put(inv); err != nil {
+		return inv, "", fmt.Errorf("saving invite: %w", err)
+	}
+	return inv, link, nil
+}
+
+// CurrentStatus returns the status of the invite. Accepting an invite only signs the user in, so an accepted
+// invite whose identity has no credentials yet was abandoned before a password was set, and is still pending,
+// or expired.
+func (s *Service) CurrentStatus(ctx context.Context, inv Invite) (Status, error) {
+	now := s.now()
+	status := inv.Status(now)
+	if status != Accepted {
+		return status, nil
+	}
+	has, err := s.Identities.HasCredentials(ctx, inv.IdentityID)
+	if err != nil {
+		return status, fmt.Errorf("checking credentials: %w", err)
+	}
+	switch {
+	case has:
+		return Accepted, nil
+	case !now.Before(inv.ExpiresAt):
+		return Expired, nil
+	}
+	return Pending, nil
+}
+
+// List returns all invites, newest first
+func (s *Service) List() ([]Invite, error) {
+	return s.Store.List()
+}
+
+// now returns the current time
+func (s *Service) now() time.Time {
+	if s.Now != nil {
+		return s.Now().UTC()
+	}
+	return time.Now().UTC()
+}
+
+// ttl returns how long invites can be accepted for
+func (s *Service) ttl() time.Duration {
+	if s.TTL > 0 {
+		return s.TTL
+	}
+	return DefaultTTL
+}
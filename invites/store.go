@@ -0,0 +1,128 @@
+package invites
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+)
+
+// ErrNotFound is returned when there is no invite with an id, or token
+var ErrNotFound = errors.New("invite not found")
+
+// Store keeps invites
+type Store interface {
+	// Get returns the invite with id, or ErrNotFound
+	Get(id string) (Invite, error)
+
+	// Put adds an invite, or replaces the invite with the same id
+	Put(i Invite) error
+
+	// List returns all invites, newest first
+	List() ([]Invite, error)
+
+	Close() error
+}
+
+// MemoryStore keeps invites in memory, they are lost when the app stops
+type MemoryStore struct {
+	mu      sync.Mutex
+	invites map[string]Invite
+}
+
+// NewMemoryStore returns an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{invites: make(map[string]Invite)}
+}
+
+// Get returns the invite with id, or ErrNotFound
+func (m *MemoryStore) Get(id string) (Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i, ok := m.invites[id]
+	if !ok {
+		return Invite{}, ErrNotFound
+	}
+	return i, nil
+}
+
+// Put adds or replaces the invite
+func (m *MemoryStore) Put(i Invite) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.invites[i.ID] = i
+	return nil
+}
+
+// List returns all invites, newest first
+func (m *MemoryStore) List() ([]Invite, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make([]Invite, 0, len(m.invites))
+	for _, i := range m.invites {
+		list = append(list, i)
+	}
+	sort.Slice(list, func(a, b int) bool { return list[a].CreatedAt.After(list[b].CreatedAt) })
+	return list, nil
+}
+
+// Close does nothing
+func (m *MemoryStore) Close() error {
+	return nil
+}
+
+// FileStore keeps invites in a JSON lines file. Each change appends the whole invite, and the last line
+// with an id is its current state. The invites are read into memory when the file is opened.
+type FileStore struct {
+	*MemoryStore
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// NewFileStore opens, creating if required, the JSON lines file at path
+func NewFileStore(path string) (*FileStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	s := &FileStore{MemoryStore: NewMemoryStore(), f: f}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var i Invite
+		if err := json.Unmarshal(scanner.Bytes(), &i); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		s.MemoryStore.invites[i.ID] = i
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put appends the invite to the file, then replaces it in memory
+func (s *FileStore) Put(i Invite) error {
+	line, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := s.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return s.MemoryStore.Put(i)
+}
+
+// Close closes the file
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
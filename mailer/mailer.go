@@ -0,0 +1,139 @@
+// Package mailer sends emails to users, such as welcome emails and invitations
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// DefaultTimeout limits how long sending an email may take, unless configured otherwise
+const DefaultTimeout = 30 * time.Second
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends emails
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// SMTP sends emails via an SMTP relay
+type SMTP struct {
+	// Addr is the host:port of the SMTP relay, which must accept mail without authentication
+	Addr string
+
+	// From is the sender address
+	From string
+
+	// Timeout limits how long sending each email may take, DefaultTimeout if not set
+	Timeout time.Duration
+}
+
+// Send sends m via the relay, using STARTTLS if the relay supports it. It gives up when ctx is done, or
+// after the timeout.
+func (s SMTP) Send(ctx context.Context, m Message) error {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return err
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	// Closing the connection interrupts the exchange if ctx is cancelled before the deadline
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+
+	if err := send(conn, host, s.From, m); err != nil {
+		var netErr net.Error
+		switch {
+		case ctx.Err() != nil:
+			return fmt.Errorf("%w: %v", ctx.Err(), err)
+		case errors.As(err, &netErr) && netErr.Timeout():
+			// The connection's deadline can pass just before the context's
+			return fmt.Errorf("%w: %v", context.DeadlineExceeded, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// send sends m over conn, as smtp.SendMail does
+func send(conn net.Conn, host, from string, m Message) error {
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(from); err != nil {
+		return err
+	}
+	if err := c.Rcpt(m.To); err != nil {
+		return err
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(format(from, m)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// format returns m with its headers, as sent to the relay
+func format(from string, m Message) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\nTo: %s\r\nSubject: %s\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\n", from, m.To, m.Subject)
+	b.WriteString(m.Body)
+	return b.Bytes()
+}
+
+// Log logs the recipient and subject of emails instead of sending them, for when no SMTP relay is configured.
+// The body is not logged as it may hold links that sign the recipient in.
+type Log struct{}
+
+// Send logs that m was not sent
+func (Log) Send(ctx context.Context, m Message) error {
+	log.Printf("No SMTP relay configured, not sending '%s' email to %s", m.Subject, m.To)
+	return nil
+}
@@ -0,0 +1,111 @@
+package mailer
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	m := Message{To: "ann@example.com", Subject: "Welcome", Body: "Hello Ann\n"}
+	assert.Equal(t, "From: no-reply@example.com\r\nTo: ann@example.com\r\nSubject: Welcome\r\nContent-Type: text/plain; charset=UTF-8\r\n\r\nHello Ann\n",
+		string(format("no-reply@example.com", m)))
+}
+
+// relay is a minimal SMTP relay, it returns the data of each message received on the channel
+func relay(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	received := make(chan string, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 relay ready")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO", "MAIL", "RCPT":
+				reply("250 ok")
+			case "DATA":
+				reply("354 go ahead")
+				var data strings.Builder
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				received <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("502 not implemented")
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestSMTPSend(t *testing.T) {
+	addr, received := relay(t)
+	s := SMTP{Addr: addr, From: "no-reply@example.com"}
+	require.NoError(t, s.Send(context.Background(), Message{To: "ann@example.com", Subject: "Welcome", Body: "Hello Ann\r\n"}))
+	assert.Contains(t, <-received, "Subject: Welcome\r\n")
+}
+
+// silentRelay accepts connections but never replies
+func silentRelay(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestSMTPSendGivesUp(t *testing.T) {
+	addr := silentRelay(t)
+
+	// After the timeout
+	start := time.Now()
+	err := SMTP{Addr: addr, From: "no-reply@example.com", Timeout: 50 * time.Millisecond}.Send(context.Background(), Message{To: "ann@example.com"})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+
+	// Or when the context is cancelled
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start = time.Now()
+	err = SMTP{Addr: addr, From: "no-reply@example.com"}.Send(ctx, Message{To: "ann@example.com"})
+	assert.True(t, errors.Is(err, context.Canceled), "got %v", err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
@@ -2,19 +2,30 @@ package main
 
 import (
 	"context"
+	"crypto/sha256"
 	"embed"
 	"encoding/gob"
 	"log"
 	"net/http"
-	"net/url"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/challenge"
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
 	"github.com/davidoram/kratos-selfservice-ui-go/handlers"
+	"github.com/davidoram/kratos-selfservice-ui-go/hooks"
+	"github.com/davidoram/kratos-selfservice-ui-go/identities"
+	"github.com/davidoram/kratos-selfservice-ui-go/incident"
+	"github.com/davidoram/kratos-selfservice-ui-go/invites"
+	"github.com/davidoram/kratos-selfservice-ui-go/mailer"
 	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
 	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"github.com/davidoram/kratos-selfservice-ui-go/registration"
+	"github.com/davidoram/kratos-selfservice-ui-go/schema"
 	"github.com/davidoram/kratos-selfservice-ui-go/session"
 
 	"github.com/benbjohnson/hashfs"
@@ -30,29 +41,116 @@ import (
 //go:embed static
 var staticFS embed.FS
 
+const (
+	// identitySchemaCacheTTL is how long a fetched identity schema is used before fetching it again
+	identitySchemaCacheTTL = 5 * time.Minute
+
+	// readinessCacheTTL is how long a readiness probe result is reused
+	readinessCacheTTL = 2 * time.Second
+
+	// maxRequestBodyBytes limits the bodies posted to the app's forms, before they are read for the CSRF token.
+	// Uploads and hooks have their own limits.
+	maxRequestBodyBytes = 64 << 10
+)
+
 func main() {
-	opt := options.NewOptions().SetFromCommandLine()
+	os.Exit(run(os.Args[1:]))
+}
+
+// runServe is the serve command, serving the self service UI until interrupted
+func runServe(name string, args []string) int {
+	opt, _, code, ok := parseOptions(name, args, "Serve the self service UI until interrupted.")
+	if !ok {
+		return code
+	}
 	if err := opt.Validate(); err != nil {
-		log.Fatalf("Error parsing command line: %v", err)
+		log.Printf("Error parsing command line: %v", err)
+		return exitUsage
 	}
 	log.Printf("KratosAdminURL: %s", opt.KratosAdminURL.String())
 	log.Printf("KratosPublicURL: %s", opt.KratosPublicURL.String())
 	log.Printf("KratosBrowserURL: %s", opt.KratosBrowserURL.String())
 	log.Printf("BaseURL: %s", opt.BaseURL.String())
+	log.Printf("BasePath: %s", opt.BasePath())
 	log.Printf("Address: %s", opt.Address())
 	log.Printf("Port: %v", opt.Port)
+	log.Printf("Debug: %v", opt.Debug)
+	log.Printf("Hooks enabled: %v", opt.HookSecret != "")
 	log.Printf("Number of Cookie store keys: %d", len(opt.CookieStoreKeyPairs))
 
 	// Init API clients
 	if _, err := api_client.InitPublicClient(opt); err != nil {
-		log.Fatalf("Error initializing public API client failed with error: %v", err)
+		log.Printf("Error initializing public API client failed with error: %v", err)
+		return exitFailure
 	}
 	if _, err := api_client.InitAdminClient(opt); err != nil {
-		log.Fatalf("Error initializing admin API client failed with error: %v", err)
+		log.Printf("Error initializing admin API client failed with error: %v", err)
+		return exitFailure
+	}
+
+	// Init audit sinks
+	if err := initAudit(opt); err != nil {
+		log.Printf("Error initializing audit sinks: %v", err)
+		return exitFailure
+	}
+
+	// Init incident sinks
+	if err := initIncidents(opt); err != nil {
+		log.Printf("Error initializing incident sinks: %v", err)
+		return exitFailure
+	}
+
+	// Init invitation store
+	inviteStore, err := newInviteStore(opt)
+	if err != nil {
+		log.Printf("Error opening invitations: %v", err)
+		return exitFailure
+	}
+
+	// Init registration policies
+	gate, err := newRegistrationGate(opt)
+	if err != nil {
+		log.Printf("Error loading registration policies: %v", err)
+		return exitFailure
+	}
+	if gate != nil && opt.HookSecret == "" {
+		log.Printf("Warning: registrations posted straight to Kratos are not checked against the registration policies or challenge, set 'hook-secret' and add the registration web hook")
+	}
+
+	// Init bot challenge
+	challengeGuard := newChallengeGuard(opt)
+	if challengeGuard != nil {
+		log.Printf("Warning: the challenge can't stop recovery requests posted straight to Kratos, limit their rate at the proxy in front of Kratos")
+	}
+
+	// Client IP addresses are read from X-Forwarded-For when requests come through the trusted proxies
+	proxies, err := clientip.ParseProxies(opt.TrustedProxies)
+	if err != nil {
+		log.Printf("Error parsing trusted proxies: %v", err)
+		return exitFailure
+	}
+	clientip.SetTrustedProxies(proxies)
+
+	// Links and redirects to the app's pages are under the base path
+	handlers.SetBasePath(opt.BasePath())
+
+	// Social sign in providers beyond those built in
+	if opt.OIDCProviders != "" {
+		if err := handlers.LoadOIDCProviders(opt.OIDCProviders); err != nil {
+			log.Printf("Error loading social sign in providers: %v", err)
+			return exitFailure
+		}
 	}
 
 	// Setup sesssion store in cookies
 	var store = sessions.NewCookieStore(opt.CookieStoreKeyPairs...)
+	store.Options.Path = opt.BasePath() + "/"
+
+	// Kratos sessions are cached briefly, so every page load doesn't need to verify the session with Kratos
+	sessionStore := session.SessionStore{
+		Store: store,
+		Cache: session.NewCache(opt.KratosSessionCookie, opt.SessionCacheTTL),
+	}
 
 	// Register kratos session type with gob
 	gob.Register(kratos.Session{})
@@ -65,18 +163,45 @@ func main() {
 	var fsys = hashfs.NewFS(staticFS)
 	r.PathPrefix("/static/").Handler(hashfs.FileServer(fsys))
 
+	// Forms posted to this app must include a CSRF token. Hooks authenticate with their own secret.
+	csrfFailureP := handlers.CSRFFailureParams{
+		HomeURL: opt.GetBaseURL(),
+		FS:      fsys,
+	}
+	csrfP := middleware.CSRFParams{
+		SessionStore:   sessionStore,
+		ExemptPaths:    []string{"/static/", "/health/", "/hooks/"},
+		FailureHandler: http.HandlerFunc(csrfFailureP.CSRFFailure),
+	}
+
+	// Flash messages stored in the session are shown on the next page rendered
+	flashP := middleware.FlashParams{SessionStore: sessionStore}
+
+	// Panics are reported as incidents, and the user sees an error page
+	handlers.SetErrorPageFS(fsys)
+	panicP := middleware.RecoverParams{PanicHandler: handlers.PanicHandler}
+
 	// Public Routes
-	r.Use(gh.RecoveryHandler(gh.PrintRecoveryStack(true)), middleware.NoCacheMiddleware)
+	r.Use(appMiddleware(panicP, csrfP, flashP)...)
 
 	// Health/readiness probe endpoints
+	readiness := handlers.NewReadiness(readinessCacheTTL,
+		handlers.KratosReadinessCheck("kratos_public", api_client.PublicClient),
+		handlers.KratosReadinessCheck("kratos_admin", api_client.AdminClient),
+		handlers.SessionStoreReadinessCheck(store),
+		handlers.TemplatesReadinessCheck(),
+	)
 	r.HandleFunc("/health/alive", handlers.Health)
-	r.HandleFunc("/health/ready", handlers.Health)
+	r.HandleFunc("/health/ready", readiness.Ready)
 
-	// Redirect from / to /welcome
+	// Redirect from / to /dashboard
 	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "/welcome", http.StatusMovedPermanently)
+		http.Redirect(w, r, handlers.AppPath("dashboard"), http.StatusFound)
 	})
 
+	// Identity schemas, used to render traits
+	schemas := schema.NewLoader(api_client.FetchIdentitySchema, identitySchemaCacheTTL)
+
 	// Login page
 	loginP := handlers.LoginParams{
 		FlowRedirectURL: opt.LoginFlowURL(),
@@ -87,11 +212,15 @@ func main() {
 
 	// Registration page
 	regP := handlers.RegistrationParams{
-		FlowRedirectURL: opt.RegistrationURL(),
-		LoginURL:        opt.LoginURL(),
-		FS:              fsys,
+		FlowRedirectURL:  opt.RegistrationURL(),
+		LoginURL:         opt.LoginURL(),
+		Schemas:          schemas,
+		IdentitySchemaID: opt.IdentitySchemaID,
+		Gate:             gate,
+		Challenge:        challengeGuard,
+		FS:               fsys,
 	}
-	r.HandleFunc("/registration", regP.Registration)
+	r.HandleFunc("/registration", regP.Registration).Methods("GET", "POST")
 
 	// Verification page
 	verificationP := handlers.VerificationParams{
@@ -103,14 +232,18 @@ func main() {
 	// Recovery page
 	recoverP := handlers.RecoveryParams{
 		FlowRedirectURL: opt.RecoveryFlowURL(),
+		Challenge:       challengeGuard,
+		SessionStore:    sessionStore,
 		FS:              fsys,
 	}
-	r.HandleFunc("/recovery", recoverP.Recovery)
+	r.HandleFunc("/recovery", recoverP.Recovery).Methods("GET", "POST")
 
 	// Error page
 	errorP := handlers.KratosErrorParams{
 		RedirectURL: opt.GetBaseURL(),
 		HomeURL:     opt.GetBaseURL(),
+		LoginURL:    opt.LoginURL(),
+		Debug:       opt.Debug,
 		FS:          fsys,
 	}
 	r.HandleFunc("/error", errorP.Error)
@@ -122,35 +255,163 @@ func main() {
 	}
 	r.NotFoundHandler = http.HandlerFunc(pageNotFoundP.PageNotFound)
 
+	// Shown when Kratos can't be reached
+	unavailableP := handlers.ServiceUnavailableParams{
+		FS: fsys,
+	}
+
 	// Routes with authentication middleware
 	authP := middleware.KratosAuthParams{
-		SessionStore:      session.SessionStore{Store: store},
-		RedirectUnauthURL: MustURL(r.Get("login")).String(),
-		Redirect2FA:       opt.TwoFAURL(),
+		SessionStore:       sessionStore,
+		RedirectUnauth:     opt.App().JoinPath("login"),
+		LoginFlow:          opt.LoginFlow(),
+		BaseURL:            opt.BaseURL,
+		UnavailableHandler: http.HandlerFunc(unavailableP.ServiceUnavailable),
+	}
+
+	// Welcome page for developers, showing the raw session (debug mode only, authentication optional)
+	if opt.Debug {
+		welcomeP := handlers.WelcomeParams{
+			SessionStore: sessionStore,
+			FS:           fsys,
+		}
+		r.Handle("/welcome", Middleware(
+			http.HandlerFunc(welcomeP.Welcome),
+			authP.SetSession,
+		))
 	}
 
-	// Welcome page (authentication optional)
-	welcomeP := handlers.WelcomeParams{
-		SessionStore: session.SessionStore{Store: store},
+	// Dashboard page (authentication required)
+	dashboardP := handlers.DashboardParams{
+		SessionStore: sessionStore,
+		Schemas:      schemas,
+		LoginURL:     opt.LoginURL(),
 		FS:           fsys,
 	}
-	r.Handle("/welcome", Middleware(
-		http.HandlerFunc(welcomeP.Welcome),
-		authP.SetSession,
+	r.Handle("/dashboard", Middleware(
+		http.HandlerFunc(dashboardP.Dashboard),
+		authP.KratoAuthMiddleware,
 	))
 
+	// Logout, ends the session with Kratos
+	logoutP := handlers.LogoutParams{
+		LoginURL:     opt.LoginURL(),
+		SessionStore: sessionStore,
+	}
+	r.HandleFunc("/logout", logoutP.Logout).Methods(http.MethodPost)
+
 	// Settings page (authentication required)
 	settingsP := handlers.SettingsParams{
-		FlowRedirectURL: opt.SettingsURL(),
-		FS:              fsys,
+		FlowRedirectURL:          opt.SettingsURL(),
+		Schemas:                  schemas,
+		LookupSecretsDownloadURL: handlers.AppPath("settings/lookup-secrets.txt"),
+		LookupSecretsPrintURL:    handlers.AppPath("settings/lookup-secrets/print"),
+		SessionStore:             sessionStore,
+		FS:                       fsys,
 	}
 	r.Handle("/settings", Middleware(
 		http.HandlerFunc(settingsP.Settings),
 		authP.KratoAuthMiddleware,
 	))
 
-	// Wrap everything in a logger
-	logR := gh.LoggingHandler(os.Stdout, r)
+	// Backup recovery code download and print pages (authentication required)
+	lookupSecretsP := handlers.LookupSecretsParams{
+		FlowRedirectURL: opt.SettingsURL(),
+		SettingsURL:     handlers.AppPath("settings"),
+		SessionStore:    sessionStore,
+		FS:              fsys,
+	}
+	privileged := authP.RequireAuth(middleware.AuthRequirement{MaxAge: opt.PrivilegedMaxAge})
+	r.Handle("/settings/lookup-secrets.txt", Middleware(
+		http.HandlerFunc(lookupSecretsP.Download),
+		privileged,
+	))
+	r.Handle("/settings/lookup-secrets/print", Middleware(
+		http.HandlerFunc(lookupSecretsP.Print),
+		privileged,
+	))
+
+	// Admin pages require authentication at the admin AAL, and are restricted to admins
+	adminAuth := authP.RequireAuth(middleware.AuthRequirement{AAL: kratos.AuthenticatorAssuranceLevel(opt.AdminAAL)})
+
+	// Audit log
+	auditP := handlers.AuditParams{
+		AdminIdentityIDs: opt.AdminIDs,
+		SessionStore:     sessionStore,
+		FS:               fsys,
+	}
+	r.Handle("/admin/audit", Middleware(
+		http.HandlerFunc(auditP.Audit),
+		adminAuth,
+	))
+
+	// Identity import and export
+	identitiesP := handlers.IdentitiesParams{
+		AdminIdentityIDs: opt.AdminIDs,
+		Importer: identities.Importer{
+			Create:          identities.KratosCreate(api_client.AdminClient()),
+			Schemas:         schemas.Get,
+			DefaultSchemaID: opt.IdentitySchemaID,
+		},
+		Exporter:     identities.Exporter{List: identities.KratosList(api_client.AdminClient())},
+		ListTimeout:  opt.KratosListTimeout,
+		SessionStore: sessionStore,
+		FS:           fsys,
+	}
+	r.Handle("/admin/identities", Middleware(
+		http.HandlerFunc(identitiesP.Identities),
+		adminAuth,
+	)).Methods(http.MethodGet, http.MethodPost)
+	r.Handle("/admin/identities/export", Middleware(
+		http.HandlerFunc(identitiesP.IdentitiesExport),
+		adminAuth,
+	))
+
+	// Invitations, managed by admins and accepted by the users invited
+	inviteService := &invites.Service{
+		Store:      inviteStore,
+		Identities: invites.KratosIdentities(api_client.AdminClient()),
+		Mailer:     newMailer(opt),
+		AcceptURL: func(token string) string {
+			return opt.App().JoinPath("invitation").Query("token", token).String()
+		},
+		SchemaID: opt.IdentitySchemaID,
+		TTL:      opt.InviteTTL,
+		Subject:  "You're invited",
+	}
+	invitesP := handlers.InvitesParams{
+		AdminIdentityIDs: opt.AdminIDs,
+		Service:          inviteService,
+		SessionStore:     sessionStore,
+		FS:               fsys,
+	}
+	r.Handle("/admin/invites", Middleware(
+		http.HandlerFunc(invitesP.Invites),
+		adminAuth,
+	)).Methods(http.MethodGet, http.MethodPost)
+	invitationP := handlers.InvitationParams{
+		Service:  inviteService,
+		LoginURL: opt.LoginURL(),
+		FS:       fsys,
+	}
+	r.HandleFunc("/invitation", invitationP.Invitation).Methods(http.MethodGet, http.MethodPost)
+
+	// Kratos web hooks, only served if they can be authenticated
+	var hookReceiver *hooks.Receiver
+	if opt.HookSecret != "" {
+		hookReceiver = newHookReceiver(opt, sessionStore.Cache, gate)
+		r.Handle("/hooks/{event}", hookReceiver).Methods(http.MethodPost)
+	}
+
+	// Routes are matched without the base path, then everything is wrapped in a logger
+	logR := gh.LoggingHandler(os.Stdout, middleware.BasePath(opt.BasePath())(r))
+
+	// HTTPS config, the certificates are loaded now so errors are reported before serving
+	tlsConfig, err := opt.ServerTLS.Config()
+	if err != nil {
+		log.Printf("Error loading server TLS config: %v", err)
+		return exitFailure
+	}
 
 	// Start server
 	srv := &http.Server{
@@ -160,13 +421,14 @@ func main() {
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      logR, // Pass our instance of gorilla/mux in.
+		TLSConfig:    tlsConfig,
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
 	go func() {
-		if opt.TLSCertPath != "" {
+		if tlsConfig != nil {
 			log.Printf("Serving TLS")
-			if err := srv.ListenAndServeTLS(opt.TLSCertPath, opt.TLSKeyPath); err != nil {
+			if err := srv.ListenAndServeTLS("", ""); err != nil {
 				log.Println(err)
 			}
 		} else {
@@ -178,12 +440,18 @@ func main() {
 	}()
 
 	c := make(chan os.Signal, 1)
-	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
-	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
-	signal.Notify(c, os.Interrupt)
+	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C), or SIGTERM as sent by orchestrators
+	// SIGKILL or SIGQUIT (Ctrl+/) will not be caught.
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 
 	// Block until we receive our signal.
-	<-c
+	sig := <-c
+	log.Printf("Received %v, shutting down", sig)
+
+	// Stop receiving new traffic while existing requests complete. Keep serving until the readiness probe
+	// has seen that, so load balancers stop sending requests before the listener closes.
+	readiness.SetShuttingDown()
+	time.Sleep(opt.ShutdownDrain)
 
 	// Create a deadline to wait for.
 	ctx, cancel := context.WithTimeout(context.Background(), opt.ShutdownWait)
@@ -191,20 +459,168 @@ func main() {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	srv.Shutdown(ctx)
+	if hookReceiver != nil {
+		// Finish sending emails and the like for the hooks received
+		hookReceiver.Wait()
+	}
+	if err := incident.Close(); err != nil {
+		log.Printf("Error closing incident sinks: %v", err)
+	}
+	if err := audit.Close(); err != nil {
+		log.Printf("Error closing audit sinks: %v", err)
+	}
+	if err := inviteStore.Close(); err != nil {
+		log.Printf("Error closing invitations: %v", err)
+	}
+	if gate != nil {
+		if err := gate.Codes.Close(); err != nil {
+			log.Printf("Error closing invite code redemptions: %v", err)
+		}
+	}
 	// Optionally, you could run srv.Shutdown in a goroutine and block on
 	// <-ctx.Done() if your application should wait for other services
 	// to finalize based on context cancellation.
 	log.Println("shutting down")
-	os.Exit(0)
+	return exitOK
+}
+
+// initAudit sets up the audit sinks configured in opt
+func initAudit(opt *options.Options) error {
+	var sinks []audit.Sink
+	if opt.AuditFile != "" {
+		s, err := audit.NewFileSink(opt.AuditFile)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+	if opt.AuditSQLDriver != "" {
+		s, err := audit.NewSQLSink(opt.AuditSQLDriver, opt.AuditSQLDSN)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+	if opt.AuditSyslog {
+		s, err := audit.NewSyslogSink("kratos-selfservice-ui-go")
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+	audit.Init(sinks...)
+	return nil
+}
+
+// initIncidents sets up the incident sinks configured in opt
+func initIncidents(opt *options.Options) error {
+	var sinks []incident.Sink
+	if opt.IncidentFile != "" {
+		s, err := incident.NewFileSink(opt.IncidentFile)
+		if err != nil {
+			return err
+		}
+		sinks = append(sinks, s)
+	}
+	if opt.IncidentWebhookURL != "" {
+		sinks = append(sinks, incident.NewWebhookSink(opt.IncidentWebhookURL))
+	}
+	incident.Init(sinks...)
+	return nil
 }
 
-// MustURL returns a 'named' URL or panics
-func MustURL(r *mux.Route, pairs ...string) *url.URL {
-	url, err := r.URL(pairs...)
+// newInviteStore opens the invitation file configured in opt, or keeps invitations in memory
+func newInviteStore(opt *options.Options) (invites.Store, error) {
+	if opt.InviteFile != "" {
+		return invites.NewFileStore(opt.InviteFile)
+	}
+	return invites.NewMemoryStore(), nil
+}
+
+// newRegistrationGate returns the gate checking registrations against the policies configured in opt, or
+// nil if anyone may register. With a challenge, the gate makes sure registrations were posted through the app.
+func newRegistrationGate(opt *options.Options) (*registration.Gate, error) {
+	if opt.RegistrationPolicy == "" && !opt.RegistrationDisabled && opt.Challenge == "" {
+		return nil, nil
+	}
+	var cfg registration.Config
+	if opt.RegistrationPolicy != "" {
+		var err error
+		if cfg, err = registration.LoadConfig(opt.RegistrationPolicy); err != nil {
+			return nil, err
+		}
+	}
+	codes, err := registration.NewCodes(cfg.InviteCodes, cfg.RedemptionsFile)
 	if err != nil {
-		log.Fatalf("Error r.URL failed with error: %v", err)
+		return nil, err
+	}
+	return &registration.Gate{Config: cfg, Codes: codes, Disabled: opt.RegistrationDisabled}, nil
+}
+
+// newChallengeGuard returns the guard asking for the challenge configured in opt, or nil if there isn't one
+func newChallengeGuard(opt *options.Options) *challenge.Guard {
+	var provider challenge.Provider
+	switch opt.Challenge {
+	case "pow":
+		// The challenges are signed with a key derived from the cookie key, so they survive restarts
+		secret := sha256.Sum256(append([]byte("challenge:"), opt.CookieStoreKeyPairs[0]...))
+		provider = &challenge.ProofOfWork{Secret: secret[:], Difficulty: opt.ChallengeDifficulty}
+	case "hcaptcha":
+		provider = challenge.HCaptcha(opt.ChallengeSiteKey, opt.ChallengeSecret)
+	case "turnstile":
+		provider = challenge.Turnstile(opt.ChallengeSiteKey, opt.ChallengeSecret)
+	default:
+		return nil
+	}
+	return &challenge.Guard{Provider: provider, After: opt.ChallengeAfter}
+}
+
+// newMailer returns the mailer configured in opt, emails are only logged if there is no SMTP relay
+func newMailer(opt *options.Options) mailer.Mailer {
+	if opt.SMTPAddr != "" {
+		return mailer.SMTP{Addr: opt.SMTPAddr, From: opt.SMTPFrom, Timeout: opt.SMTPTimeout}
+	}
+	return mailer.Log{}
+}
+
+// newHookReceiver returns the receiver for Kratos web hooks, with the built in actions registered
+func newHookReceiver(opt *options.Options, cache *session.Cache, gate *registration.Gate) *hooks.Receiver {
+	receiver := hooks.NewReceiver(opt.HookSecret)
+	if gate != nil {
+		// Registrations the gate didn't admit are rejected before the other actions see them
+		receiver.Handle(hooks.EventRegistration, hooks.RegistrationGate(gate))
+	}
+	for _, event := range []string{hooks.EventRegistration, hooks.EventLogin, hooks.EventSettings, hooks.EventRecovery, hooks.EventVerification} {
+		receiver.Handle(event, hooks.AuditRecord)
+	}
+	receiver.Handle(hooks.EventSettings, hooks.InvalidateSessions(cache))
+	receiver.Handle(hooks.EventRecovery, hooks.InvalidateSessions(cache))
+	// Side effects run after Kratos has its response, so they can't hold up or fail the flow
+	receiver.HandleAsync(hooks.EventRegistration, hooks.EnrichMetadataPublic(hooks.EventTimestamp))
+	receiver.HandleAsync(hooks.EventLogin, hooks.EnrichMetadataPublic(hooks.EventTimestamp))
+	if opt.SMTPAddr != "" {
+		receiver.HandleAsync(hooks.EventRegistration, hooks.WelcomeEmail(newMailer(opt), "Welcome"))
+	}
+	return receiver
+}
+
+// appMiddleware returns the middleware every route runs through, in order. Request bodies are limited before
+// anything reads them, forms are small but identity uploads and hooks are larger.
+func appMiddleware(panicP middleware.RecoverParams, csrfP middleware.CSRFParams, flashP middleware.FlashParams) []mux.MiddlewareFunc {
+	bodyLimitP := middleware.BodyLimitParams{
+		MaxBytes: maxRequestBodyBytes,
+		RouteMaxBytes: map[string]int64{
+			"/admin/identities": handlers.IdentityUploadMaxBytes,
+			"/hooks/":           hooks.MaxPayloadSize,
+		},
+	}
+	return []mux.MiddlewareFunc{
+		panicP.RecoverMiddleware,
+		middleware.NoCacheMiddleware,
+		bodyLimitP.BodyLimitMiddleware,
+		csrfP.CSRFMiddleware,
+		flashP.FlashMiddleware,
 	}
-	return url
 }
 
 // Middleware (this function) makes adding more than one layer of middleware easy
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/handlers"
+	"github.com/davidoram/kratos-selfservice-ui-go/middleware"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// chunkedUpload posts a multipart form with a file of size bytes to url, without a Content-Length so the
+// body is sent chunked
+func chunkedUpload(t *testing.T, client *http.Client, url, token string, size int) *http.Response {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		mw.WriteField(middleware.CSRFFieldName, token)
+		fw, err := mw.CreateFormFile("file", "identities.csv")
+		if err == nil {
+			_, err = fw.Write(bytes.Repeat([]byte("x"), size))
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	req, err := http.NewRequest(http.MethodPost, url, pr)
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	res, err := client.Do(req)
+	require.NoError(t, err)
+	res.Body.Close()
+	return res
+}
+
+func TestAppMiddlewareLimitsUploads(t *testing.T) {
+	sessionStore := session.SessionStore{Store: sessions.NewCookieStore(securecookie.GenerateRandomKey(32))}
+	csrfP := middleware.CSRFParams{
+		SessionStore:   sessionStore,
+		FailureHandler: http.HandlerFunc(handlers.CSRFFailureParams{HomeURL: "/"}.CSRFFailure),
+	}
+	r := mux.NewRouter()
+	r.Use(appMiddleware(middleware.RecoverParams{PanicHandler: handlers.PanicHandler}, csrfP, middleware.FlashParams{SessionStore: sessionStore})...)
+	r.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(middleware.CSRFToken(r)))
+	})
+	var uploaded int
+	r.HandleFunc("/admin/identities", func(w http.ResponseWriter, r *http.Request) {
+		if f, _, err := r.FormFile("file"); err == nil {
+			b, _ := ioutil.ReadAll(f)
+			uploaded = len(b)
+		}
+	}).Methods(http.MethodPost)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	require.NoError(t, err)
+	client := &http.Client{Jar: jar}
+	res, err := client.Get(srv.URL + "/token")
+	require.NoError(t, err)
+	token, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	require.NoError(t, err)
+
+	// An upload within the limit reaches the handler
+	res = chunkedUpload(t, client, srv.URL+"/admin/identities", string(token), handlers.IdentityUploadMaxBytes/2)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, handlers.IdentityUploadMaxBytes/2, uploaded)
+
+	// Over the limit it is rejected before the CSRF middleware reads it all
+	uploaded = 0
+	res = chunkedUpload(t, client, srv.URL+"/admin/identities", string(token), handlers.IdentityUploadMaxBytes)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+	assert.Zero(t, uploaded)
+
+	// Other forms have a smaller limit
+	res = chunkedUpload(t, client, srv.URL+"/token", string(token), maxRequestBodyBytes)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
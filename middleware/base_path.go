@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// BasePath serves the app under prefix, e.g. "/account". Requests are accepted with the prefix, and
+// without it when a proxy in front of the app strips it, and are routed by the path without the prefix.
+func BasePath(prefix string) func(http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(next http.Handler) http.Handler {
+		if prefix == "" {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			p, ok := stripBasePath(prefix, r.URL.Path)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+			r2 := new(http.Request)
+			*r2 = *r
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = p
+			r2.URL.RawPath = ""
+			next.ServeHTTP(w, r2)
+		})
+	}
+}
+
+// stripBasePath returns p without prefix, and if p was under prefix
+func stripBasePath(prefix, p string) (string, bool) {
+	if p == prefix {
+		return "/", true
+	}
+	if strings.HasPrefix(p, prefix+"/") {
+		return p[len(prefix):], true
+	}
+	return p, false
+}
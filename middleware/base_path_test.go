@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBasePath(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("home")) })
+	r.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("dashboard")) })
+	r.PathPrefix("/static/").HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.URL.Path)) })
+
+	get := func(h http.Handler, target string) (int, string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Code, w.Body.String()
+	}
+
+	tests := []struct {
+		name     string
+		basePath string
+		target   string
+		code     int
+		body     string
+	}{
+		{"root", "", "/dashboard", http.StatusOK, "dashboard"},
+		{"root home", "/", "/", http.StatusOK, "home"},
+		{"nested", "/account", "/account/dashboard", http.StatusOK, "dashboard"},
+		{"nested trailing slash", "/account/", "/account/dashboard", http.StatusOK, "dashboard"},
+		{"nested home", "/account", "/account", http.StatusOK, "home"},
+		{"nested asset", "/account", "/account/static/css/styles.css", http.StatusOK, "/static/css/styles.css"},
+		{"nested, stripped by proxy", "/account", "/dashboard", http.StatusOK, "dashboard"},
+		{"deeply nested", "/a/b", "/a/b/dashboard", http.StatusOK, "dashboard"},
+		{"similar prefix", "/account", "/accounting/dashboard", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, body := get(BasePath(tt.basePath)(r), tt.target)
+			assert.Equal(t, tt.code, code)
+			assert.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestBasePathBehindStrippingProxy(t *testing.T) {
+	r := mux.NewRouter()
+	r.HandleFunc("/dashboard", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(r.URL.Path)) })
+	app := httptest.NewServer(BasePath("/account")(r))
+	defer app.Close()
+	appURL, err := url.Parse(app.URL)
+	require.NoError(t, err)
+
+	// The proxy serves the app under /account, removing the prefix before forwarding
+	proxy := httptest.NewServer(http.StripPrefix("/account", httputil.NewSingleHostReverseProxy(appURL)))
+	defer proxy.Close()
+
+	resp, err := http.Get(proxy.URL + "/account/dashboard")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// ErrBodyTooLarge is returned reading a request body larger than the BodyLimitMiddleware allows
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// BodyLimitParams configure the request body limit middleware
+type BodyLimitParams struct {
+	// MaxBytes is the largest request body accepted
+	MaxBytes int64
+
+	// RouteMaxBytes overrides MaxBytes for paths starting with a prefix, e.g. uploads. The longest
+	// matching prefix is used.
+	RouteMaxBytes map[string]int64
+}
+
+// BodyLimitMiddleware limits the size of request bodies, before any other middleware reads them. Requests that
+// declare a larger body are rejected, and reading past the limit of a chunked body fails with ErrBodyTooLarge.
+func (p BodyLimitParams) BodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := p.maxBytes(r)
+		if r.ContentLength > limit {
+			log.Printf("Request body of %d bytes for %s %s is over the limit of %d", r.ContentLength, r.Method, r.URL.Path, limit)
+			BodyTooLarge(w)
+			return
+		}
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, limit), remaining: limit}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (p BodyLimitParams) maxBytes(r *http.Request) int64 {
+	limit, matched := p.MaxBytes, ""
+	for prefix, max := range p.RouteMaxBytes {
+		if strings.HasPrefix(r.URL.Path, prefix) && len(prefix) > len(matched) {
+			limit, matched = max, prefix
+		}
+	}
+	return limit
+}
+
+// BodyTooLarge writes the response to a request whose body is over the limit
+func BodyTooLarge(w http.ResponseWriter) {
+	http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+}
+
+// limitedBody reports reading past the limit of a http.MaxBytesReader as ErrBodyTooLarge, so it can be told
+// apart from other errors reading the body
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	if err != nil && err != io.EOF && b.remaining <= 0 {
+		err = ErrBodyTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimitMiddleware(t *testing.T) {
+	p := BodyLimitParams{MaxBytes: 10, RouteMaxBytes: map[string]int64{"/upload": 20, "/upload/small": 5}}
+	var readErr error
+	h := p.BodyLimitMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = io.ReadAll(r.Body)
+	}))
+
+	tests := []struct {
+		name    string
+		path    string
+		size    int
+		chunked bool
+		code    int
+		err     error
+	}{
+		{"within the limit", "/form", 10, false, http.StatusOK, nil},
+		{"declared over the limit", "/form", 11, false, http.StatusRequestEntityTooLarge, nil},
+		{"chunked over the limit", "/form", 11, true, http.StatusOK, ErrBodyTooLarge},
+		{"route limit", "/upload", 20, false, http.StatusOK, nil},
+		{"chunked over the route limit", "/upload", 21, true, http.StatusOK, ErrBodyTooLarge},
+		{"longest prefix", "/upload/small", 6, false, http.StatusRequestEntityTooLarge, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			readErr = nil
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(strings.Repeat("x", tt.size)))
+			if tt.chunked {
+				req.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			assert.Equal(t, tt.code, w.Code)
+			assert.True(t, errors.Is(readErr, tt.err), "got %v", readErr)
+		})
+	}
+}
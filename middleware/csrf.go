@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+)
+
+const (
+	// CSRFFieldName is the name of the form field holding the CSRF token
+	CSRFFieldName = "_csrf"
+
+	// CSRFHeaderName is the request header that scripts can send the CSRF token in instead
+	CSRFHeaderName = "X-CSRF-Token"
+
+	// csrfTokenSize is the number of random bytes in a token
+	csrfTokenSize = 32
+
+	// csrfMaxFormBytes limits the url encoded form bodies read for the token
+	csrfMaxFormBytes = 64 << 10
+
+	// csrfMaxMemory limits the memory used parsing multipart form bodies for the token, larger files are
+	// written to temporary files. The size of the body must be limited by the BodyLimitMiddleware.
+	csrfMaxMemory = 64 << 10
+)
+
+type contextKey string
+
+// csrfTokenKey holds the request's CSRF token in the request context
+const csrfTokenKey = contextKey("csrfToken")
+
+// CSRFParams configure the CSRF middleware
+type CSRFParams struct {
+	session.SessionStore
+
+	// ExemptPaths are path prefixes that are not checked, e.g. endpoints authenticated in other ways
+	ExemptPaths []string
+
+	// FailureHandler renders the response when the token is missing or wrong
+	FailureHandler http.Handler
+}
+
+// CSRFMiddleware protects the forms that post to this application with a synchronizer token.
+// The token is stored in the session, and made available to templates via CSRFToken. Requests that
+// change state must send it back in the CSRFFieldName form field or the CSRFHeaderName header. Multipart
+// bodies are parsed for the field, so the BodyLimitMiddleware must run first to limit their size.
+func (p CSRFParams) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.isExempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := p.GetCSRFToken(r)
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			if token == "" {
+				token = newCSRFToken()
+				if err := p.SetCSRFToken(w, r, token); err != nil {
+					log.Printf("Error saving CSRF token: %v", err)
+				}
+			}
+		default:
+			// Without a token in the session the request fails whatever is sent, so the body isn't read
+			if token == "" {
+				log.Printf("CSRF token missing from the session for %s %s", r.Method, r.URL.Path)
+				p.FailureHandler.ServeHTTP(w, r)
+				return
+			}
+			sent, err := sentCSRFToken(w, r)
+			if r.MultipartForm != nil {
+				defer r.MultipartForm.RemoveAll()
+			}
+			if errors.Is(err, ErrBodyTooLarge) {
+				log.Printf("Request body for %s %s is too large", r.Method, r.URL.Path)
+				BodyTooLarge(w)
+				return
+			}
+			if err != nil {
+				log.Printf("Error reading CSRF token for %s %s: %v", r.Method, r.URL.Path, err)
+				http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+				return
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				log.Printf("CSRF token missing or invalid for %s %s", r.Method, r.URL.Path)
+				p.FailureHandler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfTokenKey, token)))
+	})
+}
+
+func (p CSRFParams) isExempt(r *http.Request) bool {
+	for _, prefix := range p.ExemptPaths {
+		if strings.HasPrefix(r.URL.Path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// sentCSRFToken returns the token sent in the CSRFHeaderName header, or else the CSRFFieldName form field.
+// Url encoded forms are read up to csrfMaxFormBytes, multipart forms are parsed keeping up to csrfMaxMemory
+// in memory.
+func sentCSRFToken(w http.ResponseWriter, r *http.Request) (string, error) {
+	if sent := r.Header.Get(CSRFHeaderName); sent != "" {
+		return sent, nil
+	}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(csrfMaxMemory); err != nil {
+			return "", err
+		}
+	} else {
+		r.Body = &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, csrfMaxFormBytes), remaining: csrfMaxFormBytes}
+		if err := r.ParseForm(); err != nil {
+			return "", err
+		}
+	}
+	return r.PostForm.Get(CSRFFieldName), nil
+}
+
+// CSRFToken returns the CSRF token for the request, or "" if the request is not protected
+func CSRFToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey).(string)
+	return token
+}
+
+// newCSRFToken returns a random token
+func newCSRFToken() string {
+	b := make([]byte, csrfTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("Error generating CSRF token: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	p := CSRFParams{
+		SessionStore: session.SessionStore{Store: sessions.NewCookieStore(securecookie.GenerateRandomKey(32))},
+		ExemptPaths:  []string{"/hooks/"},
+		FailureHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}),
+	}
+	var seen string
+	h := p.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = CSRFToken(r)
+	}))
+
+	// A GET issues the token, in the session cookie and the request context
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	require.NotEmpty(t, seen)
+	token := seen
+	cookie := w.Header().Get("Set-Cookie")
+	require.NotEmpty(t, cookie)
+
+	post := func(path string, form url.Values, header http.Header) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Cookie", cookie)
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, post("/form", url.Values{CSRFFieldName: {token}}, nil))
+	assert.Equal(t, http.StatusOK, post("/form", nil, http.Header{http.CanonicalHeaderKey(CSRFHeaderName): {token}}))
+	assert.Equal(t, http.StatusForbidden, post("/form", nil, nil))
+	assert.Equal(t, http.StatusForbidden, post("/form", url.Values{CSRFFieldName: {"wrong"}}, nil))
+	assert.Equal(t, http.StatusOK, post("/hooks/login", nil, nil))
+}
+
+// readCounter counts the bytes read from a request body
+type readCounter struct {
+	io.Reader
+	n int
+}
+
+func (c *readCounter) Read(p []byte) (int, error) {
+	n, err := c.Reader.Read(p)
+	c.n += n
+	return n, err
+}
+
+func TestCSRFMiddlewareBodies(t *testing.T) {
+	p := CSRFParams{
+		SessionStore: session.SessionStore{Store: sessions.NewCookieStore(securecookie.GenerateRandomKey(32))},
+		FailureHandler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		}),
+	}
+	var token, file string
+	h := p.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = CSRFToken(r)
+		if f, _, err := r.FormFile("file"); err == nil {
+			b, _ := io.ReadAll(f)
+			file = string(b)
+		}
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookie := w.Header().Get("Set-Cookie")
+
+	multipartBody := func(sent string) (*bytes.Buffer, string) {
+		var b bytes.Buffer
+		mw := multipart.NewWriter(&b)
+		require.NoError(t, mw.WriteField(CSRFFieldName, sent))
+		fw, err := mw.CreateFormFile("file", "identities.csv")
+		require.NoError(t, err)
+		fw.Write([]byte("id,email\n"))
+		require.NoError(t, mw.Close())
+		return &b, mw.FormDataContentType()
+	}
+
+	// Without a token in the session the body isn't read
+	body := &readCounter{Reader: strings.NewReader(url.Values{CSRFFieldName: {"any"}}.Encode())}
+	req := httptest.NewRequest(http.MethodPost, "/form", body)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Zero(t, body.n)
+
+	// Multipart forms are parsed for the token, and the handler can read their files
+	b, contentType := multipartBody(token)
+	req = httptest.NewRequest(http.MethodPost, "/form", b)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "id,email\n", file)
+
+	b, contentType = multipartBody("wrong")
+	req = httptest.NewRequest(http.MethodPost, "/form", b)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Url encoded forms are only read up to a limit
+	form := url.Values{CSRFFieldName: {token}, "padding": {strings.Repeat("x", csrfMaxFormBytes)}}
+	req = httptest.NewRequest(http.MethodPost, "/form", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+)
+
+// flashStoreKey holds the session store flash messages are kept in, in the request context
+const flashStoreKey = contextKey("flashStore")
+
+// FlashParams configure the flash middleware
+type FlashParams struct {
+	session.SessionStore
+}
+
+// FlashMiddleware makes the flash messages stored in the session available to the pages rendered, via Flashes
+func (p FlashParams) FlashMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), flashStoreKey, p.SessionStore)))
+	})
+}
+
+// Flashes returns the flash messages waiting to be shown, removing them from the session, or nil if the
+// request didn't pass through the flash middleware
+func Flashes(w http.ResponseWriter, r *http.Request) []session.Flash {
+	store, ok := r.Context().Value(flashStoreKey).(session.SessionStore)
+	if !ok {
+		return nil
+	}
+	return store.ConsumeFlashes(w, r)
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFlashMiddleware(t *testing.T) {
+	store := session.SessionStore{Store: sessions.NewCookieStore(securecookie.GenerateRandomKey(32))}
+	p := FlashParams{SessionStore: store}
+	var seen []session.Flash
+	h := p.FlashMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = Flashes(w, r)
+	}))
+
+	// A flash added before a redirect is shown on the next page, once
+	w := httptest.NewRecorder()
+	require.NoError(t, store.AddFlash(w, httptest.NewRequest(http.MethodPost, "/form", nil), session.FlashWarning, "Sign in again"))
+	cookie := w.Header().Get("Set-Cookie")
+	require.NotEmpty(t, cookie)
+
+	req := httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.Header.Set("Cookie", cookie)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	assert.Equal(t, []session.Flash{{Type: session.FlashWarning, Text: "Sign in again"}}, seen)
+	cookie = w.Header().Get("Set-Cookie")
+	require.NotEmpty(t, cookie)
+
+	req = httptest.NewRequest(http.MethodGet, "/login", nil)
+	req.Header.Set("Cookie", cookie)
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Empty(t, seen)
+
+	// Without the middleware there are no flashes
+	assert.Nil(t, Flashes(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/login", nil)))
+}
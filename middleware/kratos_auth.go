@@ -3,56 +3,155 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
 
 	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
 	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	kratos "github.com/ory/kratos-client-go"
 )
 
+// code2FA is returned by Kratos' whoami endpoint when the session must complete a second factor
 const code2FA = 403
 
 // KratosAuthParams configure the KratosAuth http handler
 type KratosAuthParams struct {
 	session.SessionStore
 
-	// RedirectUnauthURL is where we will rerirect to if the session is
+	// RedirectUnauth is where we will rerirect to if the session is
 	// not associated with a valid user
-	RedirectUnauthURL string
+	RedirectUnauth options.URLBuilder
 
-	// Redirect2FA is where we will redirect to if the session requires 2FA authenication.
-	Redirect2FA string
+	// LoginFlow is the Kratos URL that starts a browser login flow, used to step up
+	// the session's authentication
+	LoginFlow options.URLBuilder
+
+	// BaseURL is the base url of this app, used to build the URL Kratos returns to after
+	// signing in. Its path is the prefix the app is served under. If it is not absolute the
+	// request's host is used.
+	BaseURL *url.URL
+
+	// UnavailableHandler renders the response when Kratos is unavailable, so we don't redirect
+	// to pages that will also fail
+	UnavailableHandler http.Handler
+}
+
+// AuthRequirement is what a route requires of the user's session, beyond being signed in
+type AuthRequirement struct {
+	// AAL is the lowest authenticator assurance level allowed, e.g. aal2 to require a second factor.
+	// Empty allows any level.
+	AAL kratos.AuthenticatorAssuranceLevel
+
+	// MaxAge is the longest time since the user last authenticated. Zero allows any age.
+	MaxAge time.Duration
+}
+
+// aalRank orders the authenticator assurance levels
+var aalRank = map[kratos.AuthenticatorAssuranceLevel]int{
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL0: 0,
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL1: 1,
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL2: 2,
+	kratos.AUTHENTICATORASSURANCELEVEL_AAL3: 3,
+}
+
+// stepUp is how the user must sign in again for their session to meet a requirement
+type stepUp struct {
+	// aal is the level to sign in at, if the session's level is too low
+	aal kratos.AuthenticatorAssuranceLevel
+
+	// refresh is set if the user last authenticated too long ago
+	refresh bool
+}
+
+// needed reports if the user must sign in again
+func (s stepUp) needed() bool {
+	return s.aal != "" || s.refresh
+}
+
+// message explains why the user must sign in again
+func (s stepUp) message() string {
+	if s.aal != "" {
+		return "This page needs a second factor, confirm it's you to continue."
+	}
+	return "This page needs a recent sign in, sign in again to continue."
+}
+
+// stepUp returns how the user must sign in again for ks to meet the requirement
+func (req AuthRequirement) stepUp(ks *kratos.Session) stepUp {
+	var s stepUp
+	if req.AAL != "" && aalRank[ks.GetAuthenticatorAssuranceLevel()] < aalRank[req.AAL] {
+		s.aal = req.AAL
+	}
+	if req.MaxAge > 0 && time.Since(ks.GetAuthenticatedAt()) > req.MaxAge {
+		s.refresh = true
+	}
+	return s
 }
 
 // KratoAuthMiddleware retrieves the user from the session via Kratos WhoAmIURL,
 // and if the user is authenticated the request will proceed through the middleware chain.
 // If the session is not authenticated, redirects to the RedirectUnauthURL
 func (p KratosAuthParams) KratoAuthMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, rawResp, err := api_client.PublicClient().V0alpha2Api.ToSession(r.Context()).Cookie(r.Header.Get("Cookie")).Execute()
-		if rawResp != nil && rawResp.StatusCode == code2FA {
-			log.Printf("2 factor authentication required, redirecting to %v", p.Redirect2FA)
-			http.Redirect(w, r, p.Redirect2FA, http.StatusPermanentRedirect)
-		} else if err != nil {
-			log.Printf("No kratos session found: %v, redirecting to %v", err, p.RedirectUnauthURL)
-			http.Redirect(w, r, p.RedirectUnauthURL, http.StatusPermanentRedirect)
-		} else {
-			err = p.SaveKratosSession(w, r, session)
+	return p.RequireAuth(AuthRequirement{})(next)
+}
+
+// RequireAuth returns middleware that lets the request proceed if the user is authenticated and their
+// session meets req. Otherwise the user is sent to sign in, with a second factor or again if req needs it,
+// and Kratos returns them to the original URL afterwards.
+func (p KratosAuthParams) RequireAuth(req AuthRequirement) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ks, rawResp, err := p.toSession(r, req)
+			if api_client.IsUnavailable(rawResp, err) {
+				log.Printf("Kratos unavailable: %v", err)
+				p.UnavailableHandler.ServeHTTP(w, r)
+				return
+			}
+			if rawResp != nil && rawResp.StatusCode == code2FA {
+				p.audit2FARequired(r)
+				p.redirectToLogin(w, r, stepUp{aal: kratos.AUTHENTICATORASSURANCELEVEL_AAL2})
+				return
+			}
 			if err != nil {
+				redirect := p.RedirectUnauth.ReturnTo(p.returnTo(r)).String()
+				log.Printf("No kratos session found: %v, redirecting to %v", err, redirect)
+				http.Redirect(w, r, redirect, http.StatusSeeOther)
+				return
+			}
+
+			if err = p.SaveKratosSession(w, r, ks); err != nil {
 				log.Printf("Error saving kratos session: %v", err)
 			}
-		}
+			if s := req.stepUp(ks); s.needed() {
+				if s.aal != "" {
+					p.audit2FARequired(r)
+				}
+				// The login page says why the user is signing in again
+				if err = p.AddFlash(w, r, session.FlashWarning, s.message()); err != nil {
+					log.Printf("Error saving flash message: %v", err)
+				}
+				p.redirectToLogin(w, r, s)
+				return
+			}
 
-		next.ServeHTTP(w, r)
-	})
+			next.ServeHTTP(w, r)
+		})
+	}
 }
 
 // SetSession attempts to set the session for the request. If the user is not authenicated no session is set.
 // Redirects to MFA login if required.
 func (p KratosAuthParams) SetSession(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		session, rawResp, err := api_client.PublicClient().V0alpha2Api.ToSession(r.Context()).Cookie(r.Header.Get("Cookie")).Execute()
+		ks, rawResp, err := p.toSession(r, AuthRequirement{})
 		if rawResp != nil && rawResp.StatusCode == code2FA {
-			log.Printf("2 factor authentication required, redirecting to %v", p.Redirect2FA)
-			http.Redirect(w, r, p.Redirect2FA, http.StatusPermanentRedirect)
+			p.audit2FARequired(r)
+			p.redirectToLogin(w, r, stepUp{aal: kratos.AUTHENTICATORASSURANCELEVEL_AAL2})
+			return
 		} else if rawResp != nil && rawResp.StatusCode == 401 {
 			err = p.ClearKratosSession(w, r)
 			if err != nil {
@@ -61,7 +160,7 @@ func (p KratosAuthParams) SetSession(next http.Handler) http.Handler {
 		} else if err != nil {
 			log.Printf("Error setting kratos session: %v", err)
 		} else {
-			err = p.SaveKratosSession(w, r, session)
+			err = p.SaveKratosSession(w, r, ks)
 			if err != nil {
 				log.Printf("Error saving kratos session: %v", err)
 			}
@@ -70,3 +169,60 @@ func (p KratosAuthParams) SetSession(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// toSession returns the Kratos session for the request, from the cache if it was verified recently.
+// If the session comes from the cache the response is nil. A cached session that doesn't meet req is
+// verified again, as the user may have stepped up their authentication since it was cached.
+func (p KratosAuthParams) toSession(r *http.Request, req AuthRequirement) (*kratos.Session, *http.Response, error) {
+	if ks := p.Cache.Get(r); ks != nil {
+		if !req.stepUp(ks).needed() {
+			return ks, nil, nil
+		}
+		p.Cache.Invalidate(r)
+	}
+	ks, rawResp, err := api_client.PublicClient().V0alpha2Api.ToSession(r.Context()).Cookie(r.Header.Get("Cookie")).Execute()
+	if err == nil {
+		p.Cache.Put(r, ks)
+	}
+	return ks, rawResp, err
+}
+
+// redirectToLogin sends the browser to a new Kratos login flow that steps up the session, returning to the current URL
+func (p KratosAuthParams) redirectToLogin(w http.ResponseWriter, r *http.Request, s stepUp) {
+	b := p.LoginFlow.ReturnTo(p.returnTo(r))
+	if s.aal != "" {
+		b = b.AAL(string(s.aal))
+	}
+	if s.refresh {
+		b = b.Refresh()
+	}
+	redirect := b.String()
+	log.Printf("Step up authentication required, redirecting to %v", redirect)
+	http.Redirect(w, r, redirect, http.StatusSeeOther)
+}
+
+// returnTo returns the absolute URL of the request, for Kratos to return to after signing in.
+// The request's path has had the base path removed, so it is added back.
+func (p KratosAuthParams) returnTo(r *http.Request) string {
+	u := url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if p.BaseURL != nil {
+		if p.BaseURL.IsAbs() {
+			u.Scheme, u.Host = p.BaseURL.Scheme, p.BaseURL.Host
+		}
+		u.Path = strings.TrimSuffix(path.Clean("/"+p.BaseURL.Path), "/") + r.URL.Path
+	}
+	return u.String()
+}
+
+// audit2FARequired records that the session must complete a second factor, the identity is known
+// if we have already stored its session
+func (p KratosAuthParams) audit2FARequired(r *http.Request) {
+	identityID := ""
+	if ks := p.GetKratosSession(r); ks != nil {
+		identityID = ks.Identity.Id
+	}
+	audit.Record(r, audit.SecondFactorRequired, identityID, audit.Required, "")
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/davidoram/kratos-selfservice-ui-go/api_client"
+	"github.com/davidoram/kratos-selfservice-ui-go/options"
+	"github.com/davidoram/kratos-selfservice-ui-go/session"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	kratos "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKratosSession returns an active session, authenticated age ago at aal
+func testKratosSession(aal kratos.AuthenticatorAssuranceLevel, age time.Duration) kratos.Session {
+	active := true
+	authenticatedAt := time.Now().Add(-age)
+	expiresAt := time.Now().Add(time.Hour)
+	return kratos.Session{
+		Id:                          "session-" + string(aal),
+		Active:                      &active,
+		AuthenticatedAt:             &authenticatedAt,
+		AuthenticatorAssuranceLevel: &aal,
+		ExpiresAt:                   &expiresAt,
+		Identity:                    kratos.Identity{Id: "identity"},
+	}
+}
+
+// startKratos starts a fake Kratos whoami endpoint, returning the session named by the Kratos cookie
+func startKratos(t *testing.T, calls *int32) {
+	sessions := map[string]kratos.Session{
+		"aal1":     testKratosSession(kratos.AUTHENTICATORASSURANCELEVEL_AAL1, time.Minute),
+		"aal2":     testKratosSession(kratos.AUTHENTICATORASSURANCELEVEL_AAL2, time.Minute),
+		"old":      testKratosSession(kratos.AUTHENTICATORASSURANCELEVEL_AAL1, time.Hour),
+		"upgraded": testKratosSession(kratos.AUTHENTICATORASSURANCELEVEL_AAL2, time.Minute),
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(calls, 1)
+		cookie := strings.TrimPrefix(r.Header.Get("Cookie"), session.DefaultKratosCookieName+"=")
+		w.Header().Set("Content-Type", "application/json")
+		if cookie == "needs2fa" {
+			w.WriteHeader(code2FA)
+			w.Write([]byte(`{"error":{"code":403,"message":"aal2 required"}}`))
+			return
+		}
+		ks, ok := sessions[cookie]
+		if !ok {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":401,"message":"no session"}}`))
+			return
+		}
+		json.NewEncoder(w).Encode(ks)
+	}))
+	t.Cleanup(srv.Close)
+
+	opt := options.NewOptions()
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+	opt.KratosPublicURL = u
+	_, err = api_client.InitPublicClient(opt)
+	require.NoError(t, err)
+}
+
+func TestRequireAuth(t *testing.T) {
+	var calls int32
+	startKratos(t, &calls)
+	p := KratosAuthParams{
+		SessionStore: session.SessionStore{
+			Store: sessions.NewCookieStore(securecookie.GenerateRandomKey(32)),
+			Cache: session.NewCache(session.DefaultKratosCookieName, time.Minute),
+		},
+		RedirectUnauth: options.NewURLBuilder(&url.URL{Path: "/login"}),
+		LoginFlow:      options.NewURLBuilder(&url.URL{Scheme: "https", Host: "kratos.example.com", Path: "/self-service/login/browser"}),
+	}
+
+	serve := func(req AuthRequirement, cookie string) (*httptest.ResponseRecorder, bool) {
+		reached := false
+		h := p.RequireAuth(req)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			reached = true
+		}))
+		r := httptest.NewRequest(http.MethodGet, "http://app.example.com/settings?tab=password", nil)
+		r.AddCookie(&http.Cookie{Name: session.DefaultKratosCookieName, Value: cookie})
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w, reached
+	}
+	returnTo := url.QueryEscape("http://app.example.com/settings?tab=password")
+
+	w, reached := serve(AuthRequirement{}, "aal1")
+	assert.True(t, reached)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w, reached = serve(AuthRequirement{}, "none")
+	assert.False(t, reached, "chain stops after redirecting")
+	assert.Equal(t, http.StatusSeeOther, w.Code)
+	assert.Equal(t, "/login?return_to="+returnTo, w.Header().Get("Location"))
+
+	w, reached = serve(AuthRequirement{}, "needs2fa")
+	assert.False(t, reached)
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser?aal=aal2&return_to="+returnTo, w.Header().Get("Location"))
+
+	w, reached = serve(AuthRequirement{AAL: kratos.AUTHENTICATORASSURANCELEVEL_AAL2}, "aal1")
+	assert.False(t, reached)
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser?aal=aal2&return_to="+returnTo, w.Header().Get("Location"))
+
+	_, reached = serve(AuthRequirement{AAL: kratos.AUTHENTICATORASSURANCELEVEL_AAL2}, "aal2")
+	assert.True(t, reached)
+
+	w, reached = serve(AuthRequirement{MaxAge: 10 * time.Minute}, "old")
+	assert.False(t, reached)
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser?refresh=true&return_to="+returnTo, w.Header().Get("Location"))
+
+	// A cached session that doesn't meet the requirement is verified again with Kratos
+	cached := testKratosSession(kratos.AUTHENTICATORASSURANCELEVEL_AAL1, time.Minute)
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: session.DefaultKratosCookieName, Value: "upgraded"})
+	p.Cache.Put(r, &cached)
+	atomic.StoreInt32(&calls, 0)
+	_, reached = serve(AuthRequirement{}, "upgraded")
+	assert.True(t, reached)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls), "cached session used")
+	_, reached = serve(AuthRequirement{AAL: kratos.AUTHENTICATORASSURANCELEVEL_AAL2}, "upgraded")
+	assert.True(t, reached)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "stepped up session fetched from Kratos")
+}
+
+func TestReturnToUnderBasePath(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "http://internal:4455/settings?tab=password", nil)
+
+	p := KratosAuthParams{}
+	assert.Equal(t, "http://internal:4455/settings?tab=password", p.returnTo(r))
+
+	// The base path has been stripped from the request, and is added back
+	p.BaseURL, _ = url.Parse("https://example.com/account/")
+	assert.Equal(t, "https://example.com/account/settings?tab=password", p.returnTo(r))
+
+	p.BaseURL, _ = url.Parse("/account")
+	assert.Equal(t, "http://internal:4455/account/settings?tab=password", p.returnTo(r))
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// RecoverParams configure the panic recovery middleware
+type RecoverParams struct {
+	// PanicHandler renders the response after a handler panics, err describes the panic and stack is where it happened.
+	// If the response has already started, see ResponseStarted, it should only report the panic.
+	PanicHandler func(w http.ResponseWriter, r *http.Request, err error, stack []byte)
+}
+
+// RecoverMiddleware recovers from panics in the handlers it wraps, and hands them to the PanicHandler, so the
+// user sees an error page rather than a dropped connection or a stack trace. If the response had already started,
+// e.g. a download being streamed, the connection is aborted after the PanicHandler, so the client sees the
+// response fail rather than it appearing complete.
+func (p RecoverParams) RecoverMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tw := &responseTracker{ResponseWriter: w}
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if rec == http.ErrAbortHandler {
+				// Used to abort the response on purpose, the server handles it
+				panic(rec)
+			}
+			err, ok := rec.(error)
+			if !ok {
+				err = fmt.Errorf("%v", rec)
+			}
+			started := tw.started
+			p.PanicHandler(tw, r, err, debug.Stack())
+			if started {
+				panic(http.ErrAbortHandler)
+			}
+		}()
+		next.ServeHTTP(tw, r)
+	})
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecoverMiddleware(t *testing.T) {
+	var got error
+	var stack []byte
+	p := RecoverParams{PanicHandler: func(w http.ResponseWriter, r *http.Request, err error, s []byte) {
+		got, stack = err, s
+		w.WriteHeader(http.StatusInternalServerError)
+	}}
+
+	w := httptest.NewRecorder()
+	p.RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.EqualError(t, got, "boom")
+	assert.Contains(t, string(stack), "recover_test.go")
+
+	// Aborted handlers are left to the server
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		p.RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic(http.ErrAbortHandler)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+
+	// Once the response has started the panic is reported, but the partial response is aborted rather than
+	// followed by an error page
+	var started bool
+	p = RecoverParams{PanicHandler: func(w http.ResponseWriter, r *http.Request, err error, s []byte) {
+		got, started = err, ResponseStarted(w)
+	}}
+	w = httptest.NewRecorder()
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		p.RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte("id,email\n"))
+			panic("midway")
+		})).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	})
+	assert.EqualError(t, got, "midway")
+	assert.True(t, started)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "id,email\n", w.Body.String())
+}
+
+func TestResponseStarted(t *testing.T) {
+	var before, after bool
+	p := RecoverParams{}
+	p.RecoverMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		before = ResponseStarted(w)
+		w.WriteHeader(http.StatusNoContent)
+		after = ResponseStarted(w)
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.False(t, before)
+	assert.True(t, after)
+
+	// Unknown without the middleware
+	w := httptest.NewRecorder()
+	w.WriteHeader(http.StatusOK)
+	assert.False(t, ResponseStarted(w))
+}
@@ -0,0 +1,50 @@
+package middleware
+
+import "net/http"
+
+// responseTracker wraps a ResponseWriter, recording whether the response has started, i.e. whether the status
+// and headers have been sent so it can no longer be replaced by another response
+type responseTracker struct {
+	http.ResponseWriter
+	started bool
+}
+
+// WriteHeader sends the status, starting the response
+func (t *responseTracker) WriteHeader(status int) {
+	t.started = true
+	t.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes to the body, starting the response
+func (t *responseTracker) Write(b []byte) (int, error) {
+	t.started = true
+	return t.ResponseWriter.Write(b)
+}
+
+// Flush sends any buffered data to the client, if the wrapped ResponseWriter supports it
+func (t *responseTracker) Flush() {
+	if f, ok := t.ResponseWriter.(http.Flusher); ok {
+		t.started = true
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (t *responseTracker) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
+
+// ResponseStarted returns true if the response written to w has started, so an error page can no longer be
+// shown. It is only known for responses wrapped by the RecoverMiddleware, others are reported as not started.
+func ResponseStarted(w http.ResponseWriter) bool {
+	for {
+		switch rw := w.(type) {
+		case *responseTracker:
+			return rw.started
+		case interface{ Unwrap() http.ResponseWriter }:
+			w = rw.Unwrap()
+		default:
+			return false
+		}
+	}
+}
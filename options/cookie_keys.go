@@ -0,0 +1,57 @@
+package options
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// cookieStoreKeyLength is the length in bytes of generated authentication and encryption keys
+const cookieStoreKeyLength = 32
+
+// GenerateCookieStoreKeyPair returns a new authentication key and encryption key
+func GenerateCookieStoreKeyPair() [][]byte {
+	return [][]byte{
+		securecookie.GenerateRandomKey(cookieStoreKeyLength),
+		securecookie.GenerateRandomKey(cookieStoreKeyLength),
+	}
+}
+
+// DecodeCookieStoreKeyPairs decodes the 'cookie-store-key-pairs' value, base64 keys separated by spaces
+func DecodeCookieStoreKeyPairs(s string) ([][]byte, error) {
+	keys := make([][]byte, 0)
+	for _, field := range strings.Fields(s) {
+		decoded, err := base64.StdEncoding.DecodeString(field)
+		if err != nil {
+			return nil, fmt.Errorf("decoding 'cookie-store-key-pairs' value '%s', did you use the 'keys generate' command to generate them? %w", field, err)
+		}
+		keys = append(keys, decoded)
+	}
+	return keys, nil
+}
+
+// EncodeCookieStoreKeyPairs returns keys as a 'cookie-store-key-pairs' value
+func EncodeCookieStoreKeyPairs(keys [][]byte) string {
+	encoded := make([]string, len(keys))
+	for i, k := range keys {
+		encoded[i] = base64.StdEncoding.EncodeToString(k)
+	}
+	return strings.Join(encoded, " ")
+}
+
+// RotateCookieStoreKeyPairs returns a new key pair followed by the newest keep pairs of keys. Cookies are
+// written with the new pair, and those written with the pairs kept can still be read.
+func RotateCookieStoreKeyPairs(keys [][]byte, keep int) ([][]byte, error) {
+	if len(keys)%2 != 0 {
+		return nil, fmt.Errorf("'cookie-store-key-pairs' has %d values, only pairs of auth & encryption keys can be rotated", len(keys))
+	}
+	if keep < 0 {
+		return nil, fmt.Errorf("the number of key pairs to keep must not be negative, got %d", keep)
+	}
+	if keep*2 < len(keys) {
+		keys = keys[:keep*2]
+	}
+	return append(GenerateCookieStoreKeyPair(), keys...), nil
+}
@@ -0,0 +1,42 @@
+package options
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCookieStoreKeyPairs(t *testing.T) {
+	pair := GenerateCookieStoreKeyPair()
+	require.Len(t, pair, 2)
+	assert.Len(t, pair[0], cookieStoreKeyLength)
+	assert.NotEqual(t, pair[0], pair[1])
+
+	decoded, err := DecodeCookieStoreKeyPairs(" " + EncodeCookieStoreKeyPairs(pair) + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, pair, decoded)
+
+	_, err = DecodeCookieStoreKeyPairs("not-base64!")
+	assert.Error(t, err)
+}
+
+func TestRotateCookieStoreKeyPairs(t *testing.T) {
+	old := [][]byte{[]byte("a1"), []byte("e1"), []byte("a2"), []byte("e2")}
+
+	rotated, err := RotateCookieStoreKeyPairs(old, 1)
+	require.NoError(t, err)
+	require.Len(t, rotated, 4)
+	assert.Equal(t, old[:2], rotated[2:], "newest pair kept after the new pair")
+
+	rotated, err = RotateCookieStoreKeyPairs(old, 5)
+	require.NoError(t, err)
+	assert.Equal(t, old, rotated[2:])
+
+	rotated, err = RotateCookieStoreKeyPairs(old, 0)
+	require.NoError(t, err)
+	assert.Len(t, rotated, 2)
+
+	_, err = RotateCookieStoreKeyPairs(old[:3], 1)
+	assert.EqualError(t, err, "'cookie-store-key-pairs' has 3 values, only pairs of auth & encryption keys can be rotated")
+}
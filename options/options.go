@@ -1,18 +1,19 @@
 package options
 
 import (
-	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
-	"log"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/gorilla/securecookie"
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
+	"github.com/davidoram/kratos-selfservice-ui-go/clientip"
+	"github.com/davidoram/kratos-selfservice-ui-go/mailer"
 )
 
 // Options holds the application command line options
@@ -44,20 +45,116 @@ type Options struct {
 	// Duration to wait when asked to shutdown gracefully
 	ShutdownWait time.Duration
 
-	// TLSCertPath is an optional Path to certificate file.
-	// Should be set up together with TLSKeyPath and TLSCaPath to enable HTTPS.
-	TLSCertPath string
+	// ShutdownDrain is how long to keep serving after reporting not ready, before shutting down
+	ShutdownDrain time.Duration
 
-	// TLSKeyPath is an optional path to key file.
-	// Should be set up together with TLSCertPath and TLSCaPAth to enable HTTPS.
-	TLSKeyPath string
+	// KratosTimeout limits how long each call to Kratos may take, such as fetching a flow or the session
+	KratosTimeout time.Duration
 
-	// TLSCaPath is an option path to a certificate bundle.
-	// Should be set up together with TLSCertPath and TLSKeyPath to enable HTTPS.
-	TLSCaPath string
+	// KratosListTimeout limits how long each call to the Kratos admin API listing identities for an export may take
+	KratosListTimeout time.Duration
+
+	// KratosRetries is the number of times a failed call to Kratos that only reads data is retried
+	KratosRetries int
+
+	// SessionCacheTTL is how long a session verified with Kratos is used before verifying it again. Zero disables caching.
+	SessionCacheTTL time.Duration
+
+	// KratosSessionCookie is the name of Kratos' session cookie
+	KratosSessionCookie string
+
+	// PrivilegedMaxAge is how recently the user must have signed in to view their backup recovery codes
+	PrivilegedMaxAge time.Duration
+
+	// AdminAAL is the authenticator assurance level required for the admin pages, 'aal1' or 'aal2'
+	AdminAAL string
+
+	// ServerTLS configures HTTPS for this app
+	ServerTLS ServerTLSOptions
+
+	// KratosPublicTLS and KratosAdminTLS configure the TLS connections to Kratos' public and admin APIs
+	KratosPublicTLS UpstreamTLSOptions
+	KratosAdminTLS  UpstreamTLSOptions
 
 	// Pairs of authentication and encryption keys for Cookies
 	CookieStoreKeyPairs [][]byte
+
+	// IdentitySchemaID is the id of the identity schema that new identities are registered with.
+	// It is used to render the registration form.
+	IdentitySchemaID string
+
+	// Debug enables the developer welcome page, and traces calls to the Kratos API
+	Debug bool
+
+	// HookSecret is the shared secret that Kratos web hooks authenticate with. The '/hooks/{event}'
+	// endpoint is only served when it is set.
+	HookSecret string
+
+	// SMTPAddr is the host:port of a local SMTP relay used to send welcome emails. Optional.
+	SMTPAddr string
+
+	// SMTPFrom is the sender address of emails
+	SMTPFrom string
+
+	// SMTPTimeout limits how long sending each email may take
+	SMTPTimeout time.Duration
+
+	// AuditFile is the path of a JSON lines file that audit events are appended to. Optional.
+	AuditFile string
+
+	// AuditSQLDriver and AuditSQLDSN configure a SQL database that audit events are stored in. Optional.
+	// The driver must be linked into the binary.
+	AuditSQLDriver string
+	AuditSQLDSN    string
+
+	// AuditSyslog sends audit events to the local syslog daemon
+	AuditSyslog bool
+
+	// AdminIDs are the identity IDs allowed to view the audit log, import and export identities, and
+	// invite users
+	AdminIDs []string
+
+	// InviteFile is the path of a JSON lines file that invitations are kept in. If not set they are kept
+	// in memory, and lost when the app stops.
+	InviteFile string
+
+	// InviteTTL is how long an invitation can be accepted for, unless the admin gives another expiry
+	InviteTTL time.Duration
+
+	// OIDCProviders is the path of a YAML file of social sign in providers, how their buttons are presented.
+	// Optional, common providers are built in.
+	OIDCProviders string
+
+	// RegistrationPolicy is the path of a YAML file of the policies deciding who may register. Optional.
+	RegistrationPolicy string
+
+	// RegistrationDisabled turns registration off for everyone
+	RegistrationDisabled bool
+
+	// TrustedProxies are the IP addresses or CIDR ranges of the proxies in front of the app, whose
+	// X-Forwarded-For header gives the client's IP address
+	TrustedProxies []string
+
+	// IncidentFile is the path of a JSON lines file that incidents, such as panics, are appended to. Optional.
+	IncidentFile string
+
+	// IncidentWebhookURL is a URL that incidents are posted to as JSON. Optional.
+	IncidentWebhookURL string
+
+	// Challenge is the bot challenge shown on the registration and recovery forms, one of 'pow', 'hcaptcha'
+	// or 'turnstile'. Optional.
+	Challenge string
+
+	// ChallengeSiteKey and ChallengeSecret are the keys issued by the hCaptcha or Turnstile service
+	ChallengeSiteKey string
+	ChallengeSecret  string
+
+	// ChallengeAfter is the number of failed attempts a client can make in an hour before it must complete
+	// the challenge, 0 always requires it
+	ChallengeAfter int
+
+	// ChallengeDifficulty is the number of leading zero bits the proof of work challenge requires
+	ChallengeDifficulty int
 }
 
 func NewOptions() *Options {
@@ -66,76 +163,141 @@ func NewOptions() *Options {
 		KratosPublicURL:  &url.URL{},
 		KratosBrowserURL: &url.URL{},
 		BaseURL:          &url.URL{},
+		KratosPublicTLS:  UpstreamTLSOptions{Name: "kratos-public"},
+		KratosAdminTLS:   UpstreamTLSOptions{Name: "kratos-admin"},
 	}
 }
 
-// SetFromCommandLine will parse the command line, and populate the Options.
-// The special case is when the 'gen-cookie-store-key-pair' is detected, will genrate the keys and exit
-// Will also exit if key-pairs passed in are invalid
-func (o *Options) SetFromCommandLine() *Options {
+// Parse defines the options' flags in fs, parses args with them, and populates the Options.
+// Flags not given default to their envars. As with the flag package, errors are reported to
+// fs's output along with the usage.
+func (o *Options) Parse(fs *flag.FlagSet, args []string) error {
 
 	KratosAdminURL := MustMakeURLValue(os.Getenv("KRATOS_ADMIN_URL"))
-	flag.Var(&KratosAdminURL, "kratos-admin-url", "The URL where ORY Kratos's Admin API is located at. If this app and ORY Kratos are running in the same private network, this should be the private network address. Defaults to KRATOS_ADMIN_URL envar")
+	fs.Var(&KratosAdminURL, "kratos-admin-url", "The URL where ORY Kratos's Admin API is located at. If this app and ORY Kratos are running in the same private network, this should be the private network address. Defaults to KRATOS_ADMIN_URL envar")
 
 	KratosPublicURL := MustMakeURLValue(os.Getenv("KRATOS_PUBLIC_URL"))
-	flag.Var(&KratosPublicURL, "kratos-public-url", "The URL where ORY Kratos's Public API is located at. If this app and ORY Kratos are running in the same private network, this should be the private network address. Defaults to KRATOS_PUBLIC_URL envar")
+	fs.Var(&KratosPublicURL, "kratos-public-url", "The URL where ORY Kratos's Public API is located at. If this app and ORY Kratos are running in the same private network, this should be the private network address. Defaults to KRATOS_PUBLIC_URL envar")
 
 	KratosBrowserURL := MustMakeURLValue(os.Getenv("KRATOS_BROWSER_URL"))
-	flag.Var(&KratosBrowserURL, "kratos-browser-url", "The URL to build all of the kratos self service URLS. Defaults to KRATOS_BROWSER_URL envar")
+	fs.Var(&KratosBrowserURL, "kratos-browser-url", "The URL to build all of the kratos self service URLS. Defaults to KRATOS_BROWSER_URL envar")
 
 	BaseURL := MustMakeURLValue(os.Getenv("BASE_URL"))
-	flag.Var(&BaseURL, "base-url", "The base url of this app. If served e.g. behind a proxy or via GitHub pages this would be the path, e.g. https://mywebsite.com/kratos-selfservice-ui-go/. Must be absolute!. Defaults to BASE_URL envar")
+	fs.Var(&BaseURL, "base-url", "The base url of this app. If served e.g. behind a proxy or via GitHub pages this would be the path, e.g. https://mywebsite.com/kratos-selfservice-ui-go/. Must be absolute!. Defaults to BASE_URL envar")
+
+	fs.StringVar(&o.Host, "host", "0.0.0.0", "Optional host that app listens on.")
+
+	fs.IntVar(&o.Port, "port", parseInt(os.Getenv("PORT")), "Port for this app to listen on. Defaults to PORT envar")
+
+	fs.DurationVar(&o.ShutdownWait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
 
-	flag.StringVar(&o.Host, "host", "0.0.0.0", "Optional host that app listens on.")
+	fs.DurationVar(&o.ShutdownDrain, "shutdown-drain", parseDuration(os.Getenv("SHUTDOWN_DRAIN"), 0), "How long to keep serving once the readiness probe reports shutting down, before waiting for connections to finish, e.g. 10s. Set it to more than the probe period so traffic stops first. Defaults to SHUTDOWN_DRAIN envar, or 0s")
 
-	flag.IntVar(&o.Port, "port", parseInt(os.Getenv("PORT")), "Port for this app to listen on. Defaults to PORT envar")
+	fs.DurationVar(&o.KratosTimeout, "kratos-timeout", parseDuration(os.Getenv("KRATOS_TIMEOUT"), 3*time.Second), "How long each call to Kratos may take, e.g. 3s. Defaults to KRATOS_TIMEOUT envar, or 3s")
 
-	flag.DurationVar(&o.ShutdownWait, "graceful-timeout", time.Second*15, "the duration for which the server gracefully wait for existing connections to finish - e.g. 15s or 1m")
+	fs.DurationVar(&o.KratosListTimeout, "kratos-list-timeout", parseDuration(os.Getenv("KRATOS_LIST_TIMEOUT"), 30*time.Second), "How long each call to the Kratos admin API listing identities to export them may take, e.g. 30s. Defaults to KRATOS_LIST_TIMEOUT envar, or 30s")
 
-	tlsCertPath := os.Getenv("TLS_CERT_PATH")
-	flag.StringVar(&o.TLSCertPath, "tls-cert-path", "", "Optional path to the certificate file. Use in conjunction with tls-key-path and tls-ca-path to enable https.")
+	fs.IntVar(&o.KratosRetries, "kratos-retries", parseIntOrDefault(os.Getenv("KRATOS_RETRIES"), 2), "The number of times failed calls to Kratos that only read data are retried. Defaults to KRATOS_RETRIES envar, or 2")
 
-	tlsKeyPath := os.Getenv("TLS_KEY_PATH")
-	flag.StringVar(&o.TLSKeyPath, "tls-key-path", "", "Optional path to the key file. Use in conjunction with tls-cert-path and tls-ca-path to enable https.")
+	fs.DurationVar(&o.SessionCacheTTL, "session-cache-ttl", parseDuration(os.Getenv("SESSION_CACHE_TTL"), 30*time.Second), "How long a session verified with Kratos is used before verifying it again, 0 disables caching. Defaults to SESSION_CACHE_TTL envar, or 30s")
 
-	tlsCaPath := os.Getenv("TLS_CA_PATH")
-	flag.StringVar(&o.TLSCaPath, "tls-ca-path", "", "Optional path to the key file. Use in conjunction with tls-cert-path and tls-key-path to enable https.")
+	fs.StringVar(&o.KratosSessionCookie, "kratos-session-cookie", envOrDefault("KRATOS_SESSION_COOKIE", "ory_kratos_session"), "Name of the Kratos session cookie. Defaults to KRATOS_SESSION_COOKIE envar, or 'ory_kratos_session'")
+
+	fs.DurationVar(&o.PrivilegedMaxAge, "privileged-max-age", parseDuration(os.Getenv("PRIVILEGED_MAX_AGE"), 15*time.Minute), "How recently the user must have signed in to view their backup recovery codes, 0 for no limit. Defaults to PRIVILEGED_MAX_AGE envar, or 15m")
+
+	fs.StringVar(&o.AdminAAL, "admin-aal", envOrDefault("ADMIN_AAL", "aal1"), "Authenticator assurance level required for the admin pages, 'aal1' or 'aal2' to require a second factor. Defaults to ADMIN_AAL envar, or 'aal1'")
+
+	fs.StringVar(&o.ServerTLS.CertPath, "tls-cert-path", os.Getenv("TLS_CERT_PATH"), "Optional path to the server certificate file. Use in conjunction with tls-key-path to enable https. Defaults to TLS_CERT_PATH envar")
+
+	fs.StringVar(&o.ServerTLS.KeyPath, "tls-key-path", os.Getenv("TLS_KEY_PATH"), "Optional path to the server key file. Use in conjunction with tls-cert-path to enable https. Defaults to TLS_KEY_PATH envar")
+
+	fs.StringVar(&o.ServerTLS.MinVersion, "tls-min-version", envOrDefault("TLS_MIN_VERSION", "1.2"), "Lowest TLS version the server accepts, '1.2' or '1.3'. Defaults to TLS_MIN_VERSION envar, or '1.2'")
+
+	var tlsCipherSuites string
+	fs.StringVar(&tlsCipherSuites, "tls-cipher-suites", os.Getenv("TLS_CIPHER_SUITES"), "Optional comma separated names of the TLS 1.2 cipher suites the server accepts, Go's secure defaults are used if empty. Defaults to TLS_CIPHER_SUITES envar")
+
+	fs.StringVar(&o.ServerTLS.ClientCAPath, "tls-client-ca-path", os.Getenv("TLS_CLIENT_CA_PATH"), "Optional path to a bundle of CAs that client certificates are verified with. Defaults to TLS_CLIENT_CA_PATH envar")
+
+	fs.BoolVar(&o.ServerTLS.RequireClientCert, "tls-require-client-cert", parseBool(os.Getenv("TLS_REQUIRE_CLIENT_CERT")), "Reject clients without a certificate verified by tls-client-ca-path. Defaults to TLS_REQUIRE_CLIENT_CERT envar")
+
+	for _, u := range []*UpstreamTLSOptions{&o.KratosPublicTLS, &o.KratosAdminTLS} {
+		env := strings.ToUpper(strings.ReplaceAll(u.Name, "-", "_"))
+		fs.StringVar(&u.CAPath, u.Name+"-ca-path", os.Getenv(env+"_CA_PATH"), fmt.Sprintf("Optional path to a bundle of CAs that the %s API's certificate is verified with, instead of the system CAs. Defaults to %s_CA_PATH envar", u.Name, env))
+		fs.StringVar(&u.CertPath, u.Name+"-cert-path", os.Getenv(env+"_CERT_PATH"), fmt.Sprintf("Optional path to a client certificate presented to the %s API. Use in conjunction with %s-key-path. Defaults to %s_CERT_PATH envar", u.Name, u.Name, env))
+		fs.StringVar(&u.KeyPath, u.Name+"-key-path", os.Getenv(env+"_KEY_PATH"), fmt.Sprintf("Optional path to the key of the client certificate presented to the %s API. Defaults to %s_KEY_PATH envar", u.Name, env))
+		fs.BoolVar(&u.InsecureSkipVerify, u.Name+"-insecure-skip-verify", parseBool(os.Getenv(env+"_INSECURE_SKIP_VERIFY")), fmt.Sprintf("Accept any certificate from the %s API, for development only. Defaults to %s_INSECURE_SKIP_VERIFY envar", u.Name, env))
+	}
 
 	var allCookieStoreKeyPairs string
-	flag.StringVar(&allCookieStoreKeyPairs, "cookie-store-key-pairs", os.Getenv("COOKIE_STORE_KEY_PAIRS"), "Pairs of authentication and encryption keys, enclose then in quotes. See the gen-cookie-store-key-pair flag to generate")
+	fs.StringVar(&allCookieStoreKeyPairs, "cookie-store-key-pairs", os.Getenv("COOKIE_STORE_KEY_PAIRS"), "Pairs of authentication and encryption keys, enclose then in quotes. See the 'keys generate' command to generate. Defaults to COOKIE_STORE_KEY_PAIRS envar")
+
+	fs.StringVar(&o.IdentitySchemaID, "identity-schema-id", envOrDefault("IDENTITY_SCHEMA_ID", "default"), "The id of the identity schema new identities are registered with, used to render the registration form. Defaults to IDENTITY_SCHEMA_ID envar, or 'default'")
+
+	fs.BoolVar(&o.Debug, "debug", parseBool(os.Getenv("DEBUG")), "Enable debug mode, which serves the developer welcome page and traces Kratos API calls. Defaults to DEBUG envar")
+
+	fs.StringVar(&o.HookSecret, "hook-secret", os.Getenv("HOOK_SECRET"), "Shared secret that Kratos web hooks authenticate with, either as a bearer token or by signing the body. The hooks endpoint is disabled if empty. Defaults to HOOK_SECRET envar")
+
+	fs.StringVar(&o.SMTPAddr, "smtp-addr", os.Getenv("SMTP_ADDR"), "Optional host:port of an SMTP relay, used to send a welcome email after registration. Defaults to SMTP_ADDR envar")
+
+	fs.StringVar(&o.SMTPFrom, "smtp-from", envOrDefault("SMTP_FROM", "no-reply@example.com"), "Sender address of emails. Defaults to SMTP_FROM envar, or 'no-reply@example.com'")
+
+	fs.DurationVar(&o.SMTPTimeout, "smtp-timeout", parseDuration(os.Getenv("SMTP_TIMEOUT"), mailer.DefaultTimeout), "How long sending each email may take, e.g. 30s. Defaults to SMTP_TIMEOUT envar, or 30s")
+
+	fs.StringVar(&o.AuditFile, "audit-file", os.Getenv("AUDIT_FILE"), "Optional path of a JSON lines file to append audit events to. Defaults to AUDIT_FILE envar")
+
+	fs.StringVar(&o.AuditSQLDriver, "audit-sql-driver", os.Getenv("AUDIT_SQL_DRIVER"), "Optional name of the SQL driver used to store audit events, 'pgx' for PostgreSQL or 'sqlite', use with audit-sql-dsn. Defaults to AUDIT_SQL_DRIVER envar")
+
+	fs.StringVar(&o.AuditSQLDSN, "audit-sql-dsn", os.Getenv("AUDIT_SQL_DSN"), "Data source name of the SQL database used to store audit events. Defaults to AUDIT_SQL_DSN envar")
+
+	fs.BoolVar(&o.AuditSyslog, "audit-syslog", parseBool(os.Getenv("AUDIT_SYSLOG")), "Send audit events to the local syslog daemon. Defaults to AUDIT_SYSLOG envar")
+
+	var adminIDs string
+	fs.StringVar(&adminIDs, "admin-ids", os.Getenv("ADMIN_IDS"), "Comma separated identity IDs allowed to view the audit log, import and export identities, and invite users. Defaults to ADMIN_IDS envar")
+
+	fs.StringVar(&o.InviteFile, "invite-file", os.Getenv("INVITE_FILE"), "Optional path of a JSON lines file to keep invitations in, otherwise they are lost when the app stops. Defaults to INVITE_FILE envar")
+
+	fs.DurationVar(&o.InviteTTL, "invite-ttl", parseDuration(os.Getenv("INVITE_TTL"), 168*time.Hour), "How long an invitation can be accepted for, unless the admin gives another expiry, e.g. 72h. Defaults to INVITE_TTL envar, or 168h")
+
+	fs.StringVar(&o.OIDCProviders, "oidc-providers", os.Getenv("OIDC_PROVIDERS"), "Optional path of a YAML file of social sign in providers, giving the name, icon and colour of their buttons. Defaults to OIDC_PROVIDERS envar")
+
+	fs.StringVar(&o.RegistrationPolicy, "registration-policy", os.Getenv("REGISTRATION_POLICY"), "Optional path of a YAML file of registration policies, limiting who may register by email domain or invite code, per tenant. Defaults to REGISTRATION_POLICY envar")
+
+	fs.BoolVar(&o.RegistrationDisabled, "registration-disabled", parseBool(os.Getenv("REGISTRATION_DISABLED")), "Turn registration off for everyone, whatever the registration policies say. Defaults to REGISTRATION_DISABLED envar")
+
+	var trustedProxies string
+	fs.StringVar(&trustedProxies, "trusted-proxies", os.Getenv("TRUSTED_PROXIES"), "Comma separated IP addresses or CIDR ranges of the proxies in front of the app, whose X-Forwarded-For header gives the client's IP address. Defaults to TRUSTED_PROXIES envar")
+
+	fs.StringVar(&o.IncidentFile, "incident-file", os.Getenv("INCIDENT_FILE"), "Optional path of a JSON lines file to append incidents, such as panics and pages that fail to render, to. Defaults to INCIDENT_FILE envar")
+
+	fs.StringVar(&o.IncidentWebhookURL, "incident-webhook-url", os.Getenv("INCIDENT_WEBHOOK_URL"), "Optional URL to post incidents, such as panics and pages that fail to render, to as JSON. Defaults to INCIDENT_WEBHOOK_URL envar")
+
+	fs.StringVar(&o.Challenge, "challenge", os.Getenv("CHALLENGE"), "Optional bot challenge for the registration and recovery forms, one of 'pow' (proof of work, needs no external service), 'hcaptcha' or 'turnstile'. Defaults to CHALLENGE envar")
+
+	fs.StringVar(&o.ChallengeSiteKey, "challenge-site-key", os.Getenv("CHALLENGE_SITE_KEY"), "Site key issued by hCaptcha or Turnstile. Defaults to CHALLENGE_SITE_KEY envar")
 
-	genCookieStoreKeys := false
-	flag.BoolVar(&genCookieStoreKeys, "gen-cookie-store-key-pair", false, "Pass this flag to generate a pairs of authentication and encryption keys and exit")
+	fs.StringVar(&o.ChallengeSecret, "challenge-secret", os.Getenv("CHALLENGE_SECRET"), "Secret key issued by hCaptcha or Turnstile, used to verify responses. Defaults to CHALLENGE_SECRET envar")
 
-	flag.Parse()
+	fs.IntVar(&o.ChallengeAfter, "challenge-after", parseIntOrDefault(os.Getenv("CHALLENGE_AFTER"), 3), "Number of failed attempts a client can make in an hour before it must complete the challenge, 0 always requires it. Defaults to CHALLENGE_AFTER envar, or 3")
 
-	if genCookieStoreKeys {
-		authKey := securecookie.GenerateRandomKey(32)
-		encrKey := securecookie.GenerateRandomKey(32)
-		fmt.Printf("The following values are suitable for passing in 'cookie-store-key-pairs' flag:\n")
-		fmt.Printf("%s %s\n",
-			base64.StdEncoding.EncodeToString(authKey),
-			base64.StdEncoding.EncodeToString(encrKey))
-		os.Exit(0)
+	fs.IntVar(&o.ChallengeDifficulty, "challenge-difficulty", parseIntOrDefault(os.Getenv("CHALLENGE_DIFFICULTY"), 16), "Number of leading zero bits the proof of work challenge requires, each one doubles the work. Defaults to CHALLENGE_DIFFICULTY envar, or 16")
+
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
 
 	o.KratosAdminURL = KratosAdminURL.URL
 	o.KratosPublicURL = KratosPublicURL.URL
 	o.KratosBrowserURL = KratosBrowserURL.URL
 	o.BaseURL = BaseURL.URL
-	o.TLSCertPath = tlsCertPath
-	o.TLSKeyPath = tlsKeyPath
-	o.TLSCaPath = tlsCaPath
-	o.CookieStoreKeyPairs = make([][]byte, 0)
-	pairs := strings.Split(allCookieStoreKeyPairs, " ")
-	for _, s := range pairs {
-		decoded, err := base64.StdEncoding.DecodeString(s)
-		if err != nil {
-			log.Fatalf("Error decoding 'cookie-store-key-pairs' value: '%s' , did you use 'gen-cookie-store-key-pair' to generate them? Error: %v", s, err)
-		}
-		o.CookieStoreKeyPairs = append(o.CookieStoreKeyPairs, []byte(decoded))
+	o.ServerTLS.CipherSuites = splitList(tlsCipherSuites)
+	o.AdminIDs = splitList(adminIDs)
+	o.TrustedProxies = splitList(trustedProxies)
+	pairs, err := DecodeCookieStoreKeyPairs(allCookieStoreKeyPairs)
+	if err != nil {
+		return failf(fs, "%v", err)
 	}
-	return o
+	o.CookieStoreKeyPairs = pairs
+	return nil
 }
 
 // Validate checks that the options are valid and return nil, or returns an error
@@ -157,20 +319,71 @@ func (o *Options) Validate() error {
 		return errors.New("'base-url' URL missing")
 	}
 
-	if o.TLSCertPath != "" && !fileExists(o.TLSCertPath) {
-		return fmt.Errorf("'tls-cert-path' file '%s' invalid", o.TLSCertPath)
+	if err := o.ServerTLS.Validate(); err != nil {
+		return err
+	}
+
+	if err := o.KratosPublicTLS.Validate(); err != nil {
+		return err
+	}
+
+	if err := o.KratosAdminTLS.Validate(); err != nil {
+		return err
+	}
+
+	if o.KratosRetries < 0 {
+		return fmt.Errorf("'kratos-retries' must not be negative, got %d", o.KratosRetries)
+	}
+
+	if o.AdminAAL != "" && o.AdminAAL != "aal1" && o.AdminAAL != "aal2" {
+		return fmt.Errorf("'admin-aal' must be 'aal1' or 'aal2', got '%s'", o.AdminAAL)
+	}
+
+	if o.InviteTTL < 0 {
+		return fmt.Errorf("'invite-ttl' must not be negative, got %v", o.InviteTTL)
+	}
+
+	if _, err := clientip.ParseProxies(o.TrustedProxies); err != nil {
+		return fmt.Errorf("'trusted-proxies' %v", err)
 	}
 
-	if o.TLSKeyPath != "" && !fileExists(o.TLSKeyPath) {
-		return fmt.Errorf("'tls-key-path' file '%s' invalid", o.TLSKeyPath)
+	if o.IncidentWebhookURL != "" {
+		if u, err := url.Parse(o.IncidentWebhookURL); err != nil || !u.IsAbs() {
+			return fmt.Errorf("'incident-webhook-url' must be an absolute URL, got '%s'", o.IncidentWebhookURL)
+		}
+	}
+
+	switch o.Challenge {
+	case "":
+	case "pow":
+		if o.ChallengeDifficulty < 1 || o.ChallengeDifficulty > 32 {
+			return fmt.Errorf("'challenge-difficulty' must be between 1 and 32, got %d", o.ChallengeDifficulty)
+		}
+	case "hcaptcha", "turnstile":
+		if o.ChallengeSiteKey == "" || o.ChallengeSecret == "" {
+			return fmt.Errorf("to use the '%s' challenge, provide 'challenge-site-key' and 'challenge-secret'", o.Challenge)
+		}
+	default:
+		return fmt.Errorf("'challenge' must be 'pow', 'hcaptcha' or 'turnstile', got '%s'", o.Challenge)
+	}
+
+	if o.ShutdownDrain < 0 {
+		return fmt.Errorf("'shutdown-drain' must not be negative, got %v", o.ShutdownDrain)
+	}
+
+	if o.ChallengeAfter < 0 {
+		return fmt.Errorf("'challenge-after' must not be negative, got %d", o.ChallengeAfter)
 	}
 
-	if o.TLSCaPath != "" && !fileExists(o.TLSCaPath) {
-		return fmt.Errorf("'tls-ca-path' file '%s' invalid", o.TLSCaPath)
+	if (o.AuditSQLDriver == "") != (o.AuditSQLDSN == "") {
+		return errors.New("to store audit events in SQL, provide 'audit-sql-driver' and 'audit-sql-dsn'")
+	}
+	if o.AuditSQLDriver != "" && !contains(audit.SQLDrivers, o.AuditSQLDriver) {
+		return fmt.Errorf("'audit-sql-driver' must be one of %s, got '%s'", strings.Join(audit.SQLDrivers, ", "), o.AuditSQLDriver)
 	}
 
-	if !((o.TLSCertPath == "" && o.TLSKeyPath == "" && o.TLSCaPath == "") || (o.TLSCertPath != "" && o.TLSKeyPath != "" && o.TLSCaPath != "")) {
-		return fmt.Errorf("to enable HTTPS, provide 'tls-key-path', 'tls-cert-path' and 'tls-ca-path")
+	if len(o.CookieStoreKeyPairs) == 0 {
+		return errors.New("'cookie-store-key-pairs' missing, use the 'keys generate' command to create them")
 	}
 
 	if !(len(o.CookieStoreKeyPairs) == 1 || len(o.CookieStoreKeyPairs)%2 == 0) {
@@ -180,77 +393,85 @@ func (o *Options) Validate() error {
 	return nil
 }
 
-// TwoFAURL returns the URL to redirect to that will
-// start the 2FA login flow
-func (o *Options) TwoFAURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/login"
-	urlQ := url.Query()
-	urlQ.Add("aal", "aal2")
-	url.RawQuery = urlQ.Encode()
-	return url.String()
+// BasePath returns the path this app is served under without a trailing slash, e.g. "/account",
+// or "" if it is served at the root
+func (o *Options) BasePath() string {
+	if o.BaseURL == nil {
+		return ""
+	}
+	return strings.TrimSuffix(path.Clean("/"+o.BaseURL.Path), "/")
+}
+
+// Paths of the Kratos self service browser endpoints, relative to the KratosBrowserURL
+const (
+	registrationFlowPath = "self-service/registration/browser"
+	settingsFlowPath     = "self-service/settings/browser"
+	verificationFlowPath = "self-service/verification/browser"
+	loginFlowPath        = "self-service/login/browser"
+	recoveryFlowPath     = "self-service/recovery/browser"
+	logoutFlowPath       = "self-service/browser/flows/logout"
+)
+
+// App returns a builder for URLs of this app's pages, starting from the BaseURL
+func (o *Options) App() URLBuilder {
+	return NewURLBuilder(o.BaseURL)
+}
+
+// KratosBrowser returns a builder for URLs of Kratos' self service browser endpoints
+func (o *Options) KratosBrowser() URLBuilder {
+	return NewURLBuilder(o.KratosBrowserURL)
 }
 
 // GetBaseURL returns the URL to return to the base page
 func (o *Options) GetBaseURL() string {
-	url := o.BaseURL
-	url.Path = ""
-	return url.String()
+	return o.App().JoinPath("/").String()
 }
 
 // LoginURL returns the URL for the login page
 func (o *Options) LoginURL() string {
-	url := o.BaseURL
-	url.Path = "/login"
-	return url.String()
+	return o.App().JoinPath("login").String()
 }
 
 // RegistrationURL returns the URL to redirect to that will
 // start the registration flow
 func (o *Options) RegistrationURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/self-service/registration/browser"
-	return url.String()
+	return o.KratosBrowser().JoinPath(registrationFlowPath).String()
 }
 
 // SettingsURL returns the URL to redirect to that will
 // start the settings flow
 func (o *Options) SettingsURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/self-service/settings/browser"
-	return url.String()
+	return o.KratosBrowser().JoinPath(settingsFlowPath).String()
 }
 
 // VerificationURL returns the URL to redirect to that will
 // start the verification flow
 func (o *Options) VerificationURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/self-service/verification/browser"
-	return url.String()
+	return o.KratosBrowser().JoinPath(verificationFlowPath).String()
+}
+
+// LoginFlow returns a builder for the URL that will start the login flow,
+// to which the aal, refresh and return_to params can be added
+func (o *Options) LoginFlow() URLBuilder {
+	return o.KratosBrowser().JoinPath(loginFlowPath)
 }
 
 // LoginFlowURL returns the URL to redirect to that will
 // start the login flow
 func (o *Options) LoginFlowURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/self-service/login/browser"
-	return url.String()
+	return o.LoginFlow().String()
 }
 
 // RecoveryFlowURL returns the URL to redirect to that will
 // start the recovery flow
 func (o *Options) RecoveryFlowURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/self-service/recovery/browser"
-	return url.String()
+	return o.KratosBrowser().JoinPath(recoveryFlowPath).String()
 }
 
 // LogoutFlowURL returns the URL to redirect to that will
 // start the logout flow
 func (o *Options) LogoutFlowURL() string {
-	url := o.KratosBrowserURL
-	url.Path = "/self-service/browser/flows/logout"
-	return url.String()
+	return o.KratosBrowser().JoinPath(logoutFlowPath).String()
 }
 
 // Address that this application will listen on
@@ -258,6 +479,14 @@ func (o *Options) Address() string {
 	return fmt.Sprintf("%s:%d", o.Host, o.Port)
 }
 
+// failf reports an error to fs's output with the usage, as the flag package does for invalid flags
+func failf(fs *flag.FlagSet, format string, a ...interface{}) error {
+	err := fmt.Errorf(format, a...)
+	fmt.Fprintln(fs.Output(), err)
+	fs.Usage()
+	return err
+}
+
 func parseInt(s string) int {
 	i, err := strconv.Atoi(s)
 	if err != nil {
@@ -266,6 +495,48 @@ func parseInt(s string) int {
 	return i
 }
 
+func parseIntOrDefault(s string, def int) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return def
+	}
+	return i
+}
+
+func parseDuration(s string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+func envOrDefault(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func parseBool(s string) bool {
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return false
+	}
+	return b
+}
+
+// splitList splits a comma separated list, ignoring empty items
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func fileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -273,3 +544,13 @@ func fileExists(filename string) bool {
 	}
 	return !info.IsDir()
 }
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -20,8 +20,10 @@ func TestValidateOptions(t *testing.T) {
 	defer os.Remove(keyFile.Name())
 
 	o := Options{
-		TLSCertPath: certFile.Name(),
-		TLSKeyPath:  keyFile.Name(),
+		ServerTLS: ServerTLSOptions{
+			CertPath: certFile.Name(),
+			KeyPath:  keyFile.Name(),
+		},
 	}
 
 	// Check URLs must be supplied
@@ -37,11 +39,47 @@ func TestValidateOptions(t *testing.T) {
 	o.BaseURL = u
 
 	// If provide key or cert, must have both
-	o.TLSKeyPath = ""
+	o.ServerTLS.KeyPath = ""
 	assert.EqualError(t, o.Validate(), "To enable HTTPS, provide 'tls-key-path' and 'tls-cert-path'")
 
 	// File paths must be valid
-	o.TLSKeyPath = "/not/a/valid/path"
+	o.ServerTLS.KeyPath = "/not/a/valid/path"
 	assert.EqualError(t, o.Validate(), "'tls-key-path' file '/not/a/valid/path' invalid")
 
 }
+
+func TestValidateAuditSQLDriver(t *testing.T) {
+	o := NewOptions()
+	o.KratosAdminURL, o.KratosPublicURL, o.KratosBrowserURL, o.BaseURL = mustParse(t, "http://admin"), mustParse(t, "http://public"), mustParse(t, "http://browser"), mustParse(t, "http://app")
+	o.CookieStoreKeyPairs = [][]byte{[]byte("key")}
+
+	o.AuditSQLDriver, o.AuditSQLDSN = "mysql", "user@/audit"
+	assert.EqualError(t, o.Validate(), "'audit-sql-driver' must be one of pgx, sqlite, got 'mysql'")
+	o.AuditSQLDriver = "sqlite"
+	assert.NoError(t, o.Validate())
+}
+
+func TestAppURLs(t *testing.T) {
+	tests := []struct {
+		baseURL  string
+		basePath string
+		home     string
+		login    string
+	}{
+		{"/", "", "/", "/login"},
+		{"http://127.0.0.1", "", "http://127.0.0.1/", "http://127.0.0.1/login"},
+		{"https://example.com/account/", "/account", "https://example.com/account/", "https://example.com/account/login"},
+		{"https://example.com/account", "/account", "https://example.com/account/", "https://example.com/account/login"},
+		{"/a/b/", "/a/b", "/a/b/", "/a/b/login"},
+	}
+	for _, tt := range tests {
+		u, err := url.Parse(tt.baseURL)
+		assert.Nil(t, err)
+		o := Options{BaseURL: u}
+		assert.Equal(t, tt.basePath, o.BasePath(), tt.baseURL)
+		assert.Equal(t, tt.home, o.GetBaseURL(), tt.baseURL)
+		assert.Equal(t, tt.login, o.LoginURL(), tt.baseURL)
+		// The helpers don't change the BaseURL
+		assert.Equal(t, tt.baseURL, o.BaseURL.String())
+	}
+}
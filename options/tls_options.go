@@ -0,0 +1,226 @@
+package options
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+)
+
+// tlsVersions are the TLS versions the server can be limited to
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ServerTLSOptions configure HTTPS for this app
+type ServerTLSOptions struct {
+	// CertPath and KeyPath are the server's certificate and key files. HTTPS is enabled if they are set.
+	CertPath string
+	KeyPath  string
+
+	// MinVersion is the lowest TLS version accepted, "1.2" or "1.3". Defaults to 1.2.
+	MinVersion string
+
+	// CipherSuites are the names of the TLS 1.2 cipher suites accepted, e.g. "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".
+	// Go's secure defaults are used if empty. TLS 1.3 suites are not configurable.
+	CipherSuites []string
+
+	// ClientCAPath is an optional bundle of CAs, client certificates signed by them are verified
+	ClientCAPath string
+
+	// RequireClientCert rejects clients without a certificate signed by one of the ClientCAPath CAs
+	RequireClientCert bool
+}
+
+// Enabled reports if HTTPS is configured
+func (s ServerTLSOptions) Enabled() bool {
+	return s.CertPath != "" || s.KeyPath != ""
+}
+
+// Validate checks the options, naming the flags in errors
+func (s ServerTLSOptions) Validate() error {
+	if s.CertPath != "" && !fileExists(s.CertPath) {
+		return fmt.Errorf("'tls-cert-path' file '%s' invalid", s.CertPath)
+	}
+
+	if s.KeyPath != "" && !fileExists(s.KeyPath) {
+		return fmt.Errorf("'tls-key-path' file '%s' invalid", s.KeyPath)
+	}
+
+	if (s.CertPath == "") != (s.KeyPath == "") {
+		return errors.New("To enable HTTPS, provide 'tls-key-path' and 'tls-cert-path'")
+	}
+
+	if s.MinVersion != "" {
+		if _, ok := tlsVersions[s.MinVersion]; !ok {
+			return fmt.Errorf("'tls-min-version' must be '1.2' or '1.3', got '%s'", s.MinVersion)
+		}
+	}
+
+	if len(s.CipherSuites) > 0 && s.MinVersion == "1.3" {
+		return errors.New("'tls-cipher-suites' only apply to TLS 1.2, they can't be set when 'tls-min-version' is '1.3'")
+	}
+	if _, err := cipherSuiteIDs(s.CipherSuites); err != nil {
+		return err
+	}
+
+	if s.ClientCAPath != "" && !fileExists(s.ClientCAPath) {
+		return fmt.Errorf("'tls-client-ca-path' file '%s' invalid", s.ClientCAPath)
+	}
+
+	if s.RequireClientCert && s.ClientCAPath == "" {
+		return errors.New("'tls-require-client-cert' needs the CAs to verify client certificates with, provide 'tls-client-ca-path'")
+	}
+
+	if s.ClientCAPath != "" && !s.Enabled() {
+		return errors.New("'tls-client-ca-path' needs HTTPS, provide 'tls-key-path' and 'tls-cert-path'")
+	}
+
+	return nil
+}
+
+// Config returns the TLS config of the server, loading the certificates. It is nil if HTTPS is not enabled.
+func (s ServerTLSOptions) Config() (*tls.Config, error) {
+	if !s.Enabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if v, ok := tlsVersions[s.MinVersion]; ok {
+		cfg.MinVersion = v
+	}
+
+	suites, err := cipherSuiteIDs(s.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	cfg.CipherSuites = suites
+
+	cert, err := tls.LoadX509KeyPair(s.CertPath, s.KeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %w", err)
+	}
+	cfg.Certificates = []tls.Certificate{cert}
+
+	if s.ClientCAPath != "" {
+		pool, err := loadCertPool(s.ClientCAPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+		if s.RequireClientCert {
+			cfg.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return cfg, nil
+}
+
+// UpstreamTLSOptions configure the TLS connection to a Kratos API
+type UpstreamTLSOptions struct {
+	// Name prefixes the flags of these options in errors, e.g. "kratos-public"
+	Name string
+
+	// CAPath is an optional bundle of CAs to verify Kratos' certificate with, instead of the system CAs
+	CAPath string
+
+	// CertPath and KeyPath are an optional client certificate, presented to Kratos for mutual TLS
+	CertPath string
+	KeyPath  string
+
+	// InsecureSkipVerify accepts any certificate from Kratos. Only for development.
+	InsecureSkipVerify bool
+}
+
+// Enabled reports if anything other than the default TLS config is needed
+func (u UpstreamTLSOptions) Enabled() bool {
+	return u.CAPath != "" || u.CertPath != "" || u.KeyPath != "" || u.InsecureSkipVerify
+}
+
+// Validate checks the options, naming the flags in errors
+func (u UpstreamTLSOptions) Validate() error {
+	if u.CAPath != "" && !fileExists(u.CAPath) {
+		return fmt.Errorf("'%s-ca-path' file '%s' invalid", u.Name, u.CAPath)
+	}
+
+	if u.CertPath != "" && !fileExists(u.CertPath) {
+		return fmt.Errorf("'%s-cert-path' file '%s' invalid", u.Name, u.CertPath)
+	}
+
+	if u.KeyPath != "" && !fileExists(u.KeyPath) {
+		return fmt.Errorf("'%s-key-path' file '%s' invalid", u.Name, u.KeyPath)
+	}
+
+	if (u.CertPath == "") != (u.KeyPath == "") {
+		return fmt.Errorf("to present a client certificate, provide '%s-cert-path' and '%s-key-path'", u.Name, u.Name)
+	}
+
+	if u.InsecureSkipVerify && u.CAPath != "" {
+		return fmt.Errorf("'%s-insecure-skip-verify' doesn't verify certificates, so '%s-ca-path' can't also be set", u.Name, u.Name)
+	}
+
+	return nil
+}
+
+// Config returns the TLS config for connecting to Kratos, loading the certificates. It is nil if
+// the default config is used.
+func (u UpstreamTLSOptions) Config() (*tls.Config, error) {
+	if !u.Enabled() {
+		return nil, nil
+	}
+	cfg := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: u.InsecureSkipVerify,
+	}
+
+	if u.CAPath != "" {
+		pool, err := loadCertPool(u.CAPath)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = pool
+	}
+
+	if u.CertPath != "" {
+		cert, err := tls.LoadX509KeyPair(u.CertPath, u.KeyPath)
+		if err != nil {
+			return nil, fmt.Errorf("loading %s client certificate: %w", u.Name, err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// cipherSuiteIDs returns the IDs of the named cipher suites. Only Go's secure suites are allowed.
+func cipherSuiteIDs(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("'tls-cipher-suites' has unknown or insecure cipher suite '%s'", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadCertPool returns a pool holding the PEM certificates in a file
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pem, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in '%s'", path)
+	}
+	return pool, nil
+}
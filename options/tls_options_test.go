@@ -0,0 +1,138 @@
+package options
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCert writes a self signed certificate and its key to dir, returning their paths
+func writeTestCert(t *testing.T, dir string) (certPath, keyPath string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	certPath, keyPath = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+	return certPath, keyPath
+}
+
+func TestServerTLSOptions(t *testing.T) {
+	cert, key := writeTestCert(t, t.TempDir())
+
+	tests := []struct {
+		name string
+		opt  ServerTLSOptions
+		err  string
+	}{
+		{"disabled", ServerTLSOptions{}, ""},
+		{"enabled", ServerTLSOptions{CertPath: cert, KeyPath: key}, ""},
+		{"cert only", ServerTLSOptions{CertPath: cert}, "To enable HTTPS, provide 'tls-key-path' and 'tls-cert-path'"},
+		{"bad min version", ServerTLSOptions{CertPath: cert, KeyPath: key, MinVersion: "1.0"}, "'tls-min-version' must be '1.2' or '1.3', got '1.0'"},
+		{"cipher suites", ServerTLSOptions{CertPath: cert, KeyPath: key, CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, ""},
+		{"insecure cipher suite", ServerTLSOptions{CertPath: cert, KeyPath: key, CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}}, "'tls-cipher-suites' has unknown or insecure cipher suite 'TLS_RSA_WITH_RC4_128_SHA'"},
+		{"cipher suites with 1.3", ServerTLSOptions{CertPath: cert, KeyPath: key, MinVersion: "1.3", CipherSuites: []string{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"}}, "'tls-cipher-suites' only apply to TLS 1.2, they can't be set when 'tls-min-version' is '1.3'"},
+		{"client certs", ServerTLSOptions{CertPath: cert, KeyPath: key, ClientCAPath: cert, RequireClientCert: true}, ""},
+		{"client certs without CA", ServerTLSOptions{CertPath: cert, KeyPath: key, RequireClientCert: true}, "'tls-require-client-cert' needs the CAs to verify client certificates with, provide 'tls-client-ca-path'"},
+		{"client CA without HTTPS", ServerTLSOptions{ClientCAPath: cert}, "'tls-client-ca-path' needs HTTPS, provide 'tls-key-path' and 'tls-cert-path'"},
+		{"missing client CA", ServerTLSOptions{CertPath: cert, KeyPath: key, ClientCAPath: "/not/a/valid/path"}, "'tls-client-ca-path' file '/not/a/valid/path' invalid"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opt.Validate()
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			cfg, err := tt.opt.Config()
+			require.NoError(t, err)
+			assert.Equal(t, tt.opt.Enabled(), cfg != nil)
+		})
+	}
+
+	cfg, err := ServerTLSOptions{CertPath: cert, KeyPath: key, MinVersion: "1.3", ClientCAPath: cert, RequireClientCert: true}.Config()
+	require.NoError(t, err)
+	assert.Equal(t, uint16(tls.VersionTLS13), cfg.MinVersion)
+	assert.Equal(t, tls.RequireAndVerifyClientCert, cfg.ClientAuth)
+	assert.Len(t, cfg.Certificates, 1)
+}
+
+func TestUpstreamTLSOptions(t *testing.T) {
+	cert, key := writeTestCert(t, t.TempDir())
+
+	tests := []struct {
+		name string
+		opt  UpstreamTLSOptions
+		err  string
+	}{
+		{"default", UpstreamTLSOptions{Name: "kratos-public"}, ""},
+		{"CA only", UpstreamTLSOptions{Name: "kratos-public", CAPath: cert}, ""},
+		{"mTLS", UpstreamTLSOptions{Name: "kratos-admin", CAPath: cert, CertPath: cert, KeyPath: key}, ""},
+		{"skip verify", UpstreamTLSOptions{Name: "kratos-admin", InsecureSkipVerify: true}, ""},
+		{"key only", UpstreamTLSOptions{Name: "kratos-admin", KeyPath: key}, "to present a client certificate, provide 'kratos-admin-cert-path' and 'kratos-admin-key-path'"},
+		{"missing CA", UpstreamTLSOptions{Name: "kratos-public", CAPath: "/not/a/valid/path"}, "'kratos-public-ca-path' file '/not/a/valid/path' invalid"},
+		{"skip verify with CA", UpstreamTLSOptions{Name: "kratos-public", CAPath: cert, InsecureSkipVerify: true}, "'kratos-public-insecure-skip-verify' doesn't verify certificates, so 'kratos-public-ca-path' can't also be set"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opt.Validate()
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			cfg, err := tt.opt.Config()
+			require.NoError(t, err)
+			if !tt.opt.Enabled() {
+				assert.Nil(t, cfg)
+				return
+			}
+			assert.Equal(t, tt.opt.CAPath != "", cfg.RootCAs != nil)
+			assert.Equal(t, tt.opt.CertPath != "", len(cfg.Certificates) == 1)
+			assert.Equal(t, tt.opt.InsecureSkipVerify, cfg.InsecureSkipVerify)
+		})
+	}
+
+	// The public and admin APIs are validated independently
+	o := NewOptions()
+	o.KratosAdminURL, o.KratosPublicURL, o.KratosBrowserURL, o.BaseURL = mustParse(t, "http://admin"), mustParse(t, "http://public"), mustParse(t, "http://browser"), mustParse(t, "http://app")
+	o.CookieStoreKeyPairs = [][]byte{[]byte("key")}
+	o.KratosPublicTLS.CAPath = cert
+	o.KratosAdminTLS.CertPath = cert
+	assert.EqualError(t, o.Validate(), "to present a client certificate, provide 'kratos-admin-cert-path' and 'kratos-admin-key-path'")
+	o.KratosAdminTLS.KeyPath = key
+	assert.NoError(t, o.Validate())
+}
+
+func mustParse(t *testing.T, s string) *url.URL {
+	u, err := url.Parse(s)
+	require.NoError(t, err)
+	return u
+}
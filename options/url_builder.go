@@ -0,0 +1,92 @@
+package options
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// URLBuilder builds URLs from a base URL. It is a value, each method returns a new builder and
+// leaves the base URL, and any builder it was made from, unchanged.
+type URLBuilder struct {
+	u url.URL
+}
+
+// NewURLBuilder returns a builder starting from a copy of base
+func NewURLBuilder(base *url.URL) URLBuilder {
+	if base == nil {
+		return URLBuilder{}
+	}
+	b := URLBuilder{u: *base}
+	if base.User != nil {
+		user := *base.User
+		b.u.User = &user
+	}
+	return b
+}
+
+// JoinPath returns a builder with elem joined to the path, like url.JoinPath. The elements are escaped
+// paths, "." and ".." are resolved, and a trailing slash on the last element is kept.
+func (b URLBuilder) JoinPath(elem ...string) URLBuilder {
+	elem = append([]string{b.u.EscapedPath()}, elem...)
+	var p string
+	if !strings.HasPrefix(elem[0], "/") {
+		// A relative path stays relative, but can't climb above its start
+		elem[0] = "/" + elem[0]
+		p = path.Join(elem...)[1:]
+	} else {
+		p = path.Join(elem...)
+	}
+	if strings.HasSuffix(elem[len(elem)-1], "/") && !strings.HasSuffix(p, "/") {
+		p += "/"
+	}
+	b.setPath(p)
+	return b
+}
+
+// Query returns a builder with the query param key set to value, replacing any existing values
+func (b URLBuilder) Query(key, value string) URLBuilder {
+	q := b.u.Query()
+	q.Set(key, value)
+	b.u.RawQuery = q.Encode()
+	return b
+}
+
+// ReturnTo returns a builder asking Kratos to send the browser to returnTo once the flow completes
+func (b URLBuilder) ReturnTo(returnTo string) URLBuilder {
+	return b.Query("return_to", returnTo)
+}
+
+// Refresh returns a builder asking Kratos to make the user sign in again, even if they have a session
+func (b URLBuilder) Refresh() URLBuilder {
+	return b.Query("refresh", "true")
+}
+
+// AAL returns a builder asking Kratos to sign the user in at an authenticator assurance level, e.g. "aal2"
+func (b URLBuilder) AAL(aal string) URLBuilder {
+	return b.Query("aal", aal)
+}
+
+// URL returns a copy of the URL built
+func (b URLBuilder) URL() *url.URL {
+	u := b.u
+	return &u
+}
+
+// String returns the URL built
+func (b URLBuilder) String() string {
+	return b.u.String()
+}
+
+// setPath sets the path from its escaped form p
+func (b *URLBuilder) setPath(p string) {
+	unescaped, err := url.PathUnescape(p)
+	if err != nil {
+		// Not validly escaped, so use it as is
+		unescaped = p
+	}
+	b.u.Path, b.u.RawPath = unescaped, ""
+	if b.u.EscapedPath() != p {
+		b.u.RawPath = p
+	}
+}
@@ -0,0 +1,84 @@
+package options
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestURLBuilderJoinPath(t *testing.T) {
+	tests := []struct {
+		base string
+		elem []string
+		want string
+	}{
+		{"https://kratos.example.com", []string{"self-service/login/browser"}, "https://kratos.example.com/self-service/login/browser"},
+		{"https://kratos.example.com/", []string{"self-service/login/browser"}, "https://kratos.example.com/self-service/login/browser"},
+		{"https://example.com/.ory/kratos/public", []string{"self-service", "login/browser"}, "https://example.com/.ory/kratos/public/self-service/login/browser"},
+		{"https://example.com/account/", []string{"/login"}, "https://example.com/account/login"},
+		{"https://example.com/account", []string{"/"}, "https://example.com/account/"},
+		{"https://example.com/account/", nil, "https://example.com/account/"},
+		{"https://example.com/a/b", []string{"../c"}, "https://example.com/a/c"},
+		{"https://example.com/a", []string{"../../.."}, "https://example.com/"},
+		{"https://example.com/a", []string{"b%2Fc"}, "https://example.com/a/b%2Fc"},
+		{"https://example.com/a", []string{"b c"}, "https://example.com/a/b%20c"},
+		{"/", []string{"dashboard"}, "/dashboard"},
+		{"a", []string{"../../b"}, "b"},
+		{"https://example.com/a?x=1#top", []string{"b"}, "https://example.com/a/b?x=1#top"},
+	}
+	for _, tt := range tests {
+		base, err := url.Parse(tt.base)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, NewURLBuilder(base).JoinPath(tt.elem...).String(), "%s + %v", tt.base, tt.elem)
+		assert.Equal(t, tt.base, base.String(), "base unchanged")
+	}
+}
+
+func TestURLBuilderQuery(t *testing.T) {
+	base, err := url.Parse("https://kratos.example.com/self-service/login/browser")
+	require.NoError(t, err)
+	login := NewURLBuilder(base)
+
+	tests := []struct {
+		name string
+		b    URLBuilder
+		want string
+	}{
+		{"none", login, "https://kratos.example.com/self-service/login/browser"},
+		{"aal", login.AAL("aal2"), "https://kratos.example.com/self-service/login/browser?aal=aal2"},
+		{"refresh", login.Refresh(), "https://kratos.example.com/self-service/login/browser?refresh=true"},
+		{"return_to", login.ReturnTo("https://example.com/settings?tab=1"), "https://kratos.example.com/self-service/login/browser?return_to=https%3A%2F%2Fexample.com%2Fsettings%3Ftab%3D1"},
+		{"all", login.ReturnTo("https://example.com/").Refresh().AAL("aal2"), "https://kratos.example.com/self-service/login/browser?aal=aal2&refresh=true&return_to=https%3A%2F%2Fexample.com%2F"},
+		{"replaced", login.AAL("aal1").AAL("aal2"), "https://kratos.example.com/self-service/login/browser?aal=aal2"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.b.String(), tt.name)
+	}
+
+	// Builders derived from one another don't share state
+	aal2 := login.AAL("aal2")
+	_ = aal2.Refresh()
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser?aal=aal2", aal2.String())
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser", login.String())
+	u := login.URL()
+	u.Path = "/changed"
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser", login.String())
+	assert.Equal(t, "https://kratos.example.com/self-service/login/browser", base.String())
+}
+
+func TestKratosURLsDontLeak(t *testing.T) {
+	browser, err := url.Parse("http://127.0.0.1:4433/")
+	require.NoError(t, err)
+	o := Options{KratosBrowserURL: browser}
+
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/login/browser?aal=aal2", o.LoginFlow().AAL("aal2").String())
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/login/browser", o.LoginFlowURL())
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/registration/browser", o.RegistrationURL())
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/settings/browser", o.SettingsURL())
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/verification/browser", o.VerificationURL())
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/recovery/browser", o.RecoveryFlowURL())
+	assert.Equal(t, "http://127.0.0.1:4433/self-service/browser/flows/logout", o.LogoutFlowURL())
+	assert.Equal(t, "http://127.0.0.1:4433/", o.KratosBrowserURL.String())
+}
@@ -0,0 +1,149 @@
+package registration
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidCode is returned when an invite code is unknown, expired, used up, or not for the tenant
+var ErrInvalidCode = errors.New("invalid invite code")
+
+// CodeStore validates invite codes
+type CodeStore interface {
+	// Redeem uses the code for the registrant, their email or the registration flow if it isn't known, to
+	// register at tenant, or returns ErrInvalidCode. Redeeming a code again for the same registrant, e.g.
+	// when a registration is retried, doesn't use it again.
+	Redeem(code, tenant, registrant string) error
+
+	Close() error
+}
+
+// redemption is a use of an invite code, as recorded in the redemptions file
+type redemption struct {
+	Code       string    `json:"code"`
+	Registrant string    `json:"registrant"`
+	Time       time.Time `json:"time"`
+}
+
+// Codes is a CodeStore of a fixed set of codes, whose uses are counted in memory and optionally
+// appended to a JSON lines file
+type Codes struct {
+	codes map[string]Code
+
+	// Now returns the current time, time.Now if not set
+	Now func() time.Time
+
+	mu   sync.Mutex
+	used map[string]map[string]bool
+	f    *os.File
+}
+
+// NewCodes returns a store of codes. If path is set, uses are recorded in the JSON lines file there, and the
+// uses already in it are counted.
+func NewCodes(codes []Code, path string) (*Codes, error) {
+	c := &Codes{codes: make(map[string]Code), used: make(map[string]map[string]bool)}
+	for _, code := range codes {
+		c.codes[strings.TrimSpace(code.Code)] = code
+	}
+	if path == "" {
+		return c, nil
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		var r redemption
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("%s line %d: %w", path, line, err)
+		}
+		c.markUsed(r.Code, r.Registrant)
+	}
+	if err := scanner.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.f = f
+	return c, nil
+}
+
+// Redeem uses the code for the registrant, if it is valid for the tenant
+func (c *Codes) Redeem(code, tenant, registrant string) error {
+	code = strings.TrimSpace(code)
+	registrant = strings.ToLower(strings.TrimSpace(registrant))
+	def, ok := c.codes[code]
+	if !ok || code == "" {
+		return ErrInvalidCode
+	}
+	now := c.now()
+	if !def.ExpiresAt.IsZero() && !now.Before(def.ExpiresAt) {
+		return ErrInvalidCode
+	}
+	if len(def.Tenants) > 0 && !containsTenant(def.Tenants, tenant) {
+		return ErrInvalidCode
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.used[code][registrant] {
+		return nil
+	}
+	if def.MaxUses > 0 && len(c.used[code]) >= def.MaxUses {
+		return ErrInvalidCode
+	}
+	if c.f != nil {
+		line, err := json.Marshal(redemption{Code: code, Registrant: registrant, Time: now})
+		if err != nil {
+			return err
+		}
+		if _, err := c.f.Write(append(line, '\n')); err != nil {
+			return fmt.Errorf("recording invite code use: %w", err)
+		}
+	}
+	c.markUsed(code, registrant)
+	return nil
+}
+
+// markUsed counts a use of the code by the registrant
+func (c *Codes) markUsed(code, registrant string) {
+	if c.used[code] == nil {
+		c.used[code] = make(map[string]bool)
+	}
+	c.used[code][registrant] = true
+}
+
+// Close closes the redemptions file
+func (c *Codes) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f == nil {
+		return nil
+	}
+	return c.f.Close()
+}
+
+// now returns the current time
+func (c *Codes) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// containsTenant reports if tenant is one of tenants
+func containsTenant(tenants []string, tenant string) bool {
+	for _, t := range tenants {
+		if tenantOf(t) == tenant {
+			return true
+		}
+	}
+	return false
+}
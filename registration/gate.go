@@ -0,0 +1,166 @@
+package registration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Problem ids, the ids of the messages shown on the registration form
+const (
+	ProblemDisabled     = 4190001
+	ProblemEmailDomain  = 4190002
+	ProblemCodeRequired = 4190003
+	ProblemCodeInvalid  = 4190004
+)
+
+// approvalTTL is how long Kratos has to complete a registration after the gate admits it
+const approvalTTL = time.Hour
+
+// defaultDisabledMessage is shown when registration is disabled without a message of its own
+const defaultDisabledMessage = "New accounts can't be created here at the moment. If you already have an account, sign in instead."
+
+// Problem is why a registration isn't allowed, shown to the user
+type Problem struct {
+	ID   int64
+	Text string
+}
+
+// Submission is the registration form posted to the app, before it is submitted to Kratos
+type Submission struct {
+	// Email is the 'email' trait, it is empty if the method doesn't send the traits, e.g. OIDC
+	Email string
+
+	// InviteCode is the code entered, if one is required
+	InviteCode string
+}
+
+// Gate checks registrations against the policies before they are submitted to Kratos, and remembers the
+// flows it admitted. Kratos' registration hook then checks with Verify that each registration was admitted,
+// so the checks can't be skipped by submitting the form to Kratos directly.
+type Gate struct {
+	Config Config
+
+	// Codes validates the invite codes
+	Codes CodeStore
+
+	// Disabled turns registration off for all tenants, whatever the policies say
+	Disabled bool
+
+	// Now returns the current time, time.Now if not set
+	Now func() time.Time
+
+	mu        sync.Mutex
+	approvals map[string]approval
+}
+
+// approval is a registration flow the gate admitted
+type approval struct {
+	tenant string
+	at     time.Time
+}
+
+// Policy returns the policy for the tenant reached at host
+func (g *Gate) Policy(host string) Policy {
+	p := g.Config.For(host)
+	if g.Disabled {
+		p.Disabled = true
+	}
+	return p
+}
+
+// DisabledMessage returns the message shown when registration is disabled by p
+func DisabledMessage(p Policy) string {
+	if p.DisabledMessage != "" {
+		return p.DisabledMessage
+	}
+	return defaultDisabledMessage
+}
+
+// Admit checks a submission of the registration flow at host, returning the problems to show the user. If there
+// are none the invite code is redeemed, and the flow may be submitted to Kratos.
+func (g *Gate) Admit(host, flowID string, s Submission) ([]Problem, error) {
+	tenant := tenantOf(host)
+	p := g.Policy(host)
+	if p.Disabled {
+		return []Problem{{ID: ProblemDisabled, Text: DisabledMessage(p)}}, nil
+	}
+
+	var problems []Problem
+	// Emails that aren't valid are left for Kratos to report
+	if strings.Contains(s.Email, "@") && !p.AllowsEmail(s.Email) {
+		problems = append(problems, Problem{ID: ProblemEmailDomain, Text: domainProblem(p, s.Email)})
+	}
+	if p.InviteCodeRequired {
+		switch {
+		case strings.TrimSpace(s.InviteCode) == "":
+			problems = append(problems, Problem{ID: ProblemCodeRequired, Text: "Enter the invite code you were given to create an account."})
+		case len(problems) == 0:
+			registrant := s.Email
+			if registrant == "" {
+				registrant = "flow:" + flowID
+			}
+			err := g.Codes.Redeem(s.InviteCode, tenant, registrant)
+			if err == ErrInvalidCode {
+				problems = append(problems, Problem{ID: ProblemCodeInvalid, Text: "The invite code isn't valid. It may have expired or been used up, check it or ask for a new one."})
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := g.now()
+	if g.approvals == nil {
+		g.approvals = make(map[string]approval)
+	}
+	for id, a := range g.approvals {
+		if now.Sub(a.at) > approvalTTL {
+			delete(g.approvals, id)
+		}
+	}
+	g.approvals[flowID] = approval{tenant: tenant, at: now}
+	return nil, nil
+}
+
+// Verify checks that a registration Kratos completed was admitted by the gate, and that the identity's email,
+// which may differ from the one submitted, e.g. for OIDC, is allowed
+func (g *Gate) Verify(flowID, email string) error {
+	g.mu.Lock()
+	a, ok := g.approvals[flowID]
+	delete(g.approvals, flowID)
+	g.mu.Unlock()
+	if !ok || g.now().Sub(a.at) > approvalTTL {
+		return errors.New("the registration was not submitted through this app")
+	}
+	p := g.Policy(a.tenant)
+	if p.Disabled {
+		return errors.New("registration is disabled")
+	}
+	if email != "" && !p.AllowsEmail(email) {
+		return fmt.Errorf("the email domain of '%s' is not allowed to register", email)
+	}
+	return nil
+}
+
+// domainProblem explains why the email's domain can't register
+func domainProblem(p Policy, email string) string {
+	if len(p.AllowedDomains) > 0 {
+		return fmt.Sprintf("Accounts can only be created with an email address at %s.", strings.Join(p.AllowedDomains, ", "))
+	}
+	return fmt.Sprintf("Accounts can't be created with an email address at %s.", email[strings.LastIndex(email, "@")+1:])
+}
+
+// now returns the current time
+func (g *Gate) now() time.Time {
+	if g.Now != nil {
+		return g.Now()
+	}
+	return time.Now()
+}
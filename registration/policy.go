@@ -0,0 +1,155 @@
+// Package registration decides who may register. Policies can turn registration off, limit it to email
+// domains, or require an invite code, with overrides for each tenant, the host the app is reached on.
+package registration
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Policy decides who may register
+type Policy struct {
+	// Disabled turns registration off
+	Disabled bool `yaml:"disabled"`
+
+	// DisabledMessage is shown instead of the registration form when it is disabled. Optional.
+	DisabledMessage string `yaml:"disabled_message"`
+
+	// AllowedDomains are the email domains that may register, including their subdomains. All domains
+	// may register if there are none.
+	AllowedDomains []string `yaml:"allowed_domains"`
+
+	// DeniedDomains are the email domains, and their subdomains, that may not register. They take
+	// precedence over AllowedDomains.
+	DeniedDomains []string `yaml:"denied_domains"`
+
+	// InviteCodeRequired requires a valid invite code to register
+	InviteCodeRequired bool `yaml:"invite_code_required"`
+}
+
+// AllowsEmail reports if the email's domain may register
+func (p Policy) AllowsEmail(email string) bool {
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := strings.ToLower(strings.TrimSpace(email[at+1:]))
+	if matchesDomain(p.DeniedDomains, domain) {
+		return false
+	}
+	return len(p.AllowedDomains) == 0 || matchesDomain(p.AllowedDomains, domain)
+}
+
+// matchesDomain reports if domain is one of domains, or a subdomain of one
+func matchesDomain(domains []string, domain string) bool {
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimPrefix(d, "."))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// Override changes the default policy for a tenant, fields that aren't set are inherited
+type Override struct {
+	Disabled           *bool    `yaml:"disabled"`
+	DisabledMessage    *string  `yaml:"disabled_message"`
+	AllowedDomains     []string `yaml:"allowed_domains"`
+	DeniedDomains      []string `yaml:"denied_domains"`
+	InviteCodeRequired *bool    `yaml:"invite_code_required"`
+}
+
+// apply returns p changed by the override
+func (o Override) apply(p Policy) Policy {
+	if o.Disabled != nil {
+		p.Disabled = *o.Disabled
+	}
+	if o.DisabledMessage != nil {
+		p.DisabledMessage = *o.DisabledMessage
+	}
+	if o.AllowedDomains != nil {
+		p.AllowedDomains = o.AllowedDomains
+	}
+	if o.DeniedDomains != nil {
+		p.DeniedDomains = o.DeniedDomains
+	}
+	if o.InviteCodeRequired != nil {
+		p.InviteCodeRequired = *o.InviteCodeRequired
+	}
+	return p
+}
+
+// Code is an invite code that lets users register
+type Code struct {
+	Code string `yaml:"code"`
+
+	// Tenants limits the tenants the code can be used for. Optional.
+	Tenants []string `yaml:"tenants"`
+
+	// MaxUses is the number of identities that can register with the code, unlimited if 0
+	MaxUses int `yaml:"max_uses"`
+
+	// ExpiresAt is when the code stops working, never if not set
+	ExpiresAt time.Time `yaml:"expires_at"`
+}
+
+// Config is the registration policy file
+type Config struct {
+	// Policy is the default policy
+	Policy `yaml:",inline"`
+
+	// Tenants override the default policy for the hosts the app is reached on, e.g. "partners.example.com"
+	Tenants map[string]Override `yaml:"tenants"`
+
+	// InviteCodes are the codes accepted when an invite code is required
+	InviteCodes []Code `yaml:"invite_codes"`
+
+	// RedemptionsFile is the path of a JSON lines file recording the invite codes used. If not set the uses
+	// are counted in memory, and start again when the app restarts.
+	RedemptionsFile string `yaml:"redemptions_file"`
+}
+
+// LoadConfig reads the YAML policy file at path
+func LoadConfig(path string) (Config, error) {
+	var cfg Config
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return cfg, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	tenants := make(map[string]Override, len(cfg.Tenants))
+	for host, o := range cfg.Tenants {
+		tenants[tenantOf(host)] = o
+	}
+	cfg.Tenants = tenants
+	for i, c := range cfg.InviteCodes {
+		if strings.TrimSpace(c.Code) == "" {
+			return cfg, fmt.Errorf("%s: invite code %d has no 'code'", path, i+1)
+		}
+	}
+	return cfg, nil
+}
+
+// For returns the policy for a tenant, the host the app was reached on. Ports are ignored.
+func (c Config) For(host string) Policy {
+	if o, ok := c.Tenants[tenantOf(host)]; ok {
+		return o.apply(c.Policy)
+	}
+	return c.Policy
+}
+
+// tenantOf returns the tenant for a host, its lower case name without the port
+func tenantOf(host string) string {
+	host = strings.ToLower(host)
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.HasSuffix(host, "]") {
+		host = host[:i]
+	}
+	return host
+}
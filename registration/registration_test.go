@@ -0,0 +1,124 @@
+package registration
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfig = `
+allowed_domains: [example.com]
+denied_domains: [contractors.example.com]
+invite_code_required: true
+tenants:
+  Partners.Example.com:
+    allowed_domains: []
+    invite_code_required: false
+  closed.example.com:
+    disabled: true
+    disabled_message: Registration opens in June.
+invite_codes:
+  - code: STAFF
+    max_uses: 2
+  - code: OLD
+    expires_at: 2021-01-01T00:00:00Z
+  - code: PARTNER
+    tenants: [partners.example.com]
+`
+
+func loadTestConfig(t *testing.T) Config {
+	path := filepath.Join(t.TempDir(), "registration.yml")
+	require.NoError(t, os.WriteFile(path, []byte(testConfig), 0600))
+	cfg, err := LoadConfig(path)
+	require.NoError(t, err)
+	return cfg
+}
+
+func TestPolicies(t *testing.T) {
+	cfg := loadTestConfig(t)
+
+	p := cfg.For("app.example.com:4455")
+	assert.True(t, p.InviteCodeRequired)
+	assert.True(t, p.AllowsEmail("ann@example.com"))
+	assert.True(t, p.AllowsEmail("ann@Staff.Example.com"))
+	assert.False(t, p.AllowsEmail("ann@contractors.example.com"))
+	assert.False(t, p.AllowsEmail("ann@notexample.com"))
+	assert.False(t, p.AllowsEmail("ann"))
+
+	p = cfg.For("partners.example.com")
+	assert.False(t, p.InviteCodeRequired)
+	assert.True(t, p.AllowsEmail("bob@partner.org"))
+	assert.False(t, p.AllowsEmail("bob@contractors.example.com"))
+
+	p = cfg.For("closed.example.com")
+	assert.True(t, p.Disabled)
+	assert.Equal(t, "Registration opens in June.", DisabledMessage(p))
+}
+
+func TestCodes(t *testing.T) {
+	cfg := loadTestConfig(t)
+	path := filepath.Join(t.TempDir(), "redemptions.jsonl")
+	codes, err := NewCodes(cfg.InviteCodes, path)
+	require.NoError(t, err)
+	codes.Now = func() time.Time { return time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC) }
+
+	assert.NoError(t, codes.Redeem("STAFF", "app.example.com", "ann@example.com"))
+	assert.NoError(t, codes.Redeem(" STAFF ", "app.example.com", "Ann@example.com"), "retries don't use the code again")
+	assert.NoError(t, codes.Redeem("STAFF", "app.example.com", "bob@example.com"))
+	assert.Equal(t, ErrInvalidCode, codes.Redeem("STAFF", "app.example.com", "cat@example.com"), "used up")
+	assert.Equal(t, ErrInvalidCode, codes.Redeem("OLD", "app.example.com", "cat@example.com"), "expired")
+	assert.Equal(t, ErrInvalidCode, codes.Redeem("PARTNER", "app.example.com", "cat@example.com"), "other tenant")
+	assert.NoError(t, codes.Redeem("PARTNER", "partners.example.com", "cat@example.com"))
+	assert.Equal(t, ErrInvalidCode, codes.Redeem("nope", "app.example.com", "cat@example.com"))
+	require.NoError(t, codes.Close())
+
+	// Uses are counted again from the redemptions file
+	codes, err = NewCodes(cfg.InviteCodes, path)
+	require.NoError(t, err)
+	defer codes.Close()
+	assert.Equal(t, ErrInvalidCode, codes.Redeem("STAFF", "app.example.com", "cat@example.com"))
+	assert.NoError(t, codes.Redeem("STAFF", "app.example.com", "bob@example.com"))
+}
+
+func TestGate(t *testing.T) {
+	cfg := loadTestConfig(t)
+	codes, err := NewCodes(cfg.InviteCodes, "")
+	require.NoError(t, err)
+	g := &Gate{Config: cfg, Codes: codes}
+
+	problems, err := g.Admit("app.example.com", "f1", Submission{Email: "ann@other.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []Problem{
+		{ID: ProblemEmailDomain, Text: "Accounts can only be created with an email address at example.com."},
+		{ID: ProblemCodeRequired, Text: "Enter the invite code you were given to create an account."},
+	}, problems)
+
+	problems, err = g.Admit("app.example.com", "f1", Submission{Email: "ann@example.com", InviteCode: "nope"})
+	require.NoError(t, err)
+	assert.Equal(t, []Problem{{ID: ProblemCodeInvalid, Text: "The invite code isn't valid. It may have expired or been used up, check it or ask for a new one."}}, problems)
+
+	// Registrations are only verified if the gate admitted them
+	assert.EqualError(t, g.Verify("f1", "ann@example.com"), "the registration was not submitted through this app")
+	problems, err = g.Admit("app.example.com", "f1", Submission{Email: "ann@example.com", InviteCode: "STAFF"})
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	assert.NoError(t, g.Verify("f1", "ann@example.com"))
+	assert.Error(t, g.Verify("f1", "ann@example.com"), "each admission is verified once")
+
+	// Emails not known when the form is posted, e.g. with OIDC, are checked when the registration completes
+	problems, err = g.Admit("partners.example.com", "f2", Submission{})
+	require.NoError(t, err)
+	assert.Empty(t, problems)
+	assert.EqualError(t, g.Verify("f2", "eve@contractors.example.com"), "the email domain of 'eve@contractors.example.com' is not allowed to register")
+
+	problems, err = g.Admit("closed.example.com", "f3", Submission{Email: "ann@example.com"})
+	require.NoError(t, err)
+	assert.Equal(t, []Problem{{ID: ProblemDisabled, Text: "Registration opens in June."}}, problems)
+
+	g.Disabled = true
+	assert.True(t, g.Policy("partners.example.com").Disabled)
+}
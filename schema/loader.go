@@ -0,0 +1,58 @@
+package schema
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// FetchFunc returns the raw JSON document of the identity schema with the given id
+type FetchFunc func(ctx context.Context, id string) ([]byte, error)
+
+// Loader fetches identity schemas and caches the parsed result, as schemas
+// rarely change but are needed on most page loads
+type Loader struct {
+	fetch FetchFunc
+	ttl   time.Duration
+
+	mu    sync.Mutex
+	cache map[string]cachedSchema
+}
+
+type cachedSchema struct {
+	schema    *Schema
+	fetchedAt time.Time
+}
+
+// NewLoader returns a Loader that uses fetch to retrieve schemas, and caches them for ttl
+func NewLoader(fetch FetchFunc, ttl time.Duration) *Loader {
+	return &Loader{
+		fetch: fetch,
+		ttl:   ttl,
+		cache: make(map[string]cachedSchema),
+	}
+}
+
+// Get returns the schema with the given id, from the cache if it is fresh enough
+func (l *Loader) Get(ctx context.Context, id string) (*Schema, error) {
+	l.mu.Lock()
+	cached, ok := l.cache[id]
+	l.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < l.ttl {
+		return cached.schema, nil
+	}
+
+	raw, err := l.fetch(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	s, err := Parse(id, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.cache[id] = cachedSchema{schema: s, fetchedAt: time.Now()}
+	l.mu.Unlock()
+	return s, nil
+}
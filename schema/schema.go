@@ -0,0 +1,358 @@
+// schema package loads and interprets Kratos identity JSON schemas, so that
+// identity traits can be displayed and edited using the titles, types and
+// ordering defined by the schema
+package schema
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Schema is a parsed identity schema
+type Schema struct {
+	// ID of the schema, as known to Kratos
+	ID string
+
+	// Title of the schema, e.g. "Person"
+	Title string
+
+	// Traits holds the properties of the identity traits, in document order
+	Traits []Property
+}
+
+// Property describes a single (possibly nested) trait in the schema
+type Property struct {
+	// Name is the property name within its parent object, e.g. "first"
+	Name string
+
+	// Path is the dotted path of the property below the traits object, e.g. "name.first"
+	Path string
+
+	// Title is the human readable title from the schema, or a title derived from Name
+	Title string
+
+	// Type is the JSON schema type, e.g. "string" or "object"
+	Type string
+
+	// Format is the optional JSON schema format, e.g. "email"
+	Format string
+
+	// Description is optional help text for the property
+	Description string
+
+	// Required is true if the parent object lists the property as required
+	Required bool
+
+	// Enum holds the allowed values, if the property is restricted to a fixed set
+	Enum []interface{}
+
+	// MinLength and MaxLength are the optional length limits of a string property
+	MinLength *int
+	MaxLength *int
+
+	// Minimum and Maximum are the optional limits of a numeric property
+	Minimum *float64
+	Maximum *float64
+
+	// Pattern is the optional regular expression a string property must match
+	Pattern string
+
+	// UI holds the rendering hints from the schema's "x-ui" extension
+	UI UIHints
+
+	// Properties holds the child properties of an "object" property, in document order,
+	// or in the order given by the "x-ui" order hints
+	Properties []Property
+}
+
+// UIHints are optional rendering hints, given in the "x-ui" extension of a property, e.g.
+//
+//	"phone": {"type": "string", "x-ui": {"order": 1, "group": "Contact details", "placeholder": "+64 21 123 4567"}}
+type UIHints struct {
+	// Order sorts properties within their parent, lower values first. Properties
+	// without an order keep their document order, after those with one.
+	Order *int `json:"order"`
+
+	// Group collects properties with the same group into a titled fieldset
+	Group string `json:"group"`
+
+	// Placeholder replaces the property title as the input placeholder
+	Placeholder string `json:"placeholder"`
+}
+
+// Field is a trait value paired with the schema property that describes it
+type Field struct {
+	Property
+
+	// Section is the title of the object containing a nested property, e.g. "Name" for "name.first"
+	Section string
+
+	Value interface{}
+}
+
+// rawProperty is the subset of a JSON schema property that we are interested in
+type rawProperty struct {
+	Type        json.RawMessage `json:"type"`
+	Title       string          `json:"title"`
+	Format      string          `json:"format"`
+	Description string          `json:"description"`
+	Enum        []interface{}   `json:"enum"`
+	MinLength   *int            `json:"minLength"`
+	MaxLength   *int            `json:"maxLength"`
+	Minimum     *float64        `json:"minimum"`
+	Maximum     *float64        `json:"maximum"`
+	Pattern     string          `json:"pattern"`
+	Required    []string        `json:"required"`
+	UI          UIHints         `json:"x-ui"`
+	Properties  orderedObject   `json:"properties"`
+}
+
+// Parse decodes an identity schema document, keeping the trait properties in
+// the order in which they appear in the document
+func Parse(id string, raw []byte) (*Schema, error) {
+	var root struct {
+		Title      string        `json:"title"`
+		Properties orderedObject `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("decoding identity schema '%s': %w", id, err)
+	}
+	traitsRaw, ok := root.Properties.values["traits"]
+	if !ok {
+		return nil, fmt.Errorf("identity schema '%s' has no 'traits' property", id)
+	}
+	traits, err := parseProperty("traits", "", false, traitsRaw)
+	if err != nil {
+		return nil, fmt.Errorf("identity schema '%s': %w", id, err)
+	}
+	return &Schema{
+		ID:     id,
+		Title:  root.Title,
+		Traits: traits.Properties,
+	}, nil
+}
+
+func parseProperty(name, path string, required bool, raw json.RawMessage) (Property, error) {
+	var rp rawProperty
+	if err := json.Unmarshal(raw, &rp); err != nil {
+		return Property{}, fmt.Errorf("decoding property '%s': %w", name, err)
+	}
+	p := Property{
+		Name:        name,
+		Path:        path,
+		Title:       rp.Title,
+		Type:        schemaType(rp.Type),
+		Format:      rp.Format,
+		Description: rp.Description,
+		Required:    required,
+		Enum:        rp.Enum,
+		MinLength:   rp.MinLength,
+		MaxLength:   rp.MaxLength,
+		Minimum:     rp.Minimum,
+		Maximum:     rp.Maximum,
+		Pattern:     rp.Pattern,
+		UI:          rp.UI,
+	}
+	if p.Title == "" {
+		p.Title = titleFromName(name)
+	}
+	requiredChildren := make(map[string]bool)
+	for _, r := range rp.Required {
+		requiredChildren[r] = true
+	}
+	for _, key := range rp.Properties.keys {
+		childPath := key
+		if path != "" {
+			childPath = path + "." + key
+		}
+		child, err := parseProperty(key, childPath, requiredChildren[key], rp.Properties.values[key])
+		if err != nil {
+			return Property{}, err
+		}
+		p.Properties = append(p.Properties, child)
+	}
+	sort.SliceStable(p.Properties, func(i, j int) bool {
+		a, b := p.Properties[i].UI.Order, p.Properties[j].UI.Order
+		if a == nil || b == nil {
+			return a != nil && b == nil
+		}
+		return *a < *b
+	})
+	return p, nil
+}
+
+// Property returns the property at a dotted path below the traits object, or nil if there is none
+func (s *Schema) Property(path string) *Property {
+	props := s.Traits
+	parts := strings.Split(path, ".")
+	for i, part := range parts {
+		var found *Property
+		for j := range props {
+			if props[j].Name == part {
+				found = &props[j]
+				break
+			}
+		}
+		if found == nil {
+			return nil
+		}
+		if i == len(parts)-1 {
+			return found
+		}
+		props = found.Properties
+	}
+	return nil
+}
+
+// schemaType returns the first non-null type of a property, which may be
+// declared as a string or an array of strings
+func schemaType(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s
+	}
+	var types []string
+	if err := json.Unmarshal(raw, &types); err == nil {
+		for _, t := range types {
+			if t != "null" {
+				return t
+			}
+		}
+	}
+	return ""
+}
+
+// titleFromName turns a property name like "first_name" into "First name"
+func titleFromName(name string) string {
+	s := strings.NewReplacer("_", " ", "-", " ").Replace(name)
+	if s == "" {
+		return s
+	}
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Fields flattens the traits into a list of leaf fields, in schema order.
+// Traits that are present but not described by the schema are appended at the end
+// so that nothing is hidden from the user.
+func (s *Schema) Fields(traits interface{}) []Field {
+	fields := leafFields(s.Traits, "", traits)
+	known := make(map[string]bool)
+	for _, f := range fields {
+		known[f.Path] = true
+	}
+	for _, f := range FieldsWithoutSchema(traits) {
+		if !known[f.Path] && !hasKnownPrefix(known, f.Path) {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+func leafFields(props []Property, section string, traits interface{}) []Field {
+	var fields []Field
+	for _, p := range props {
+		if len(p.Properties) > 0 {
+			fields = append(fields, leafFields(p.Properties, p.Title, traits)...)
+			continue
+		}
+		fields = append(fields, Field{Property: p, Section: section, Value: Lookup(traits, p.Path)})
+	}
+	return fields
+}
+
+func hasKnownPrefix(known map[string]bool, path string) bool {
+	for k := range known {
+		if strings.HasPrefix(path, k+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldsWithoutSchema flattens the traits into a list of leaf fields sorted by path.
+// It is used when the identity schema cannot be loaded.
+func FieldsWithoutSchema(traits interface{}) []Field {
+	var fields []Field
+	var walk func(prefix string, v interface{})
+	walk = func(prefix string, v interface{}) {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			if prefix != "" {
+				name := prefix[strings.LastIndex(prefix, ".")+1:]
+				fields = append(fields, Field{
+					Property: Property{Name: name, Path: prefix, Title: titleFromName(name)},
+					Value:    v,
+				})
+			}
+			return
+		}
+		for k, child := range m {
+			path := k
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+			walk(path, child)
+		}
+	}
+	walk("", traits)
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Path < fields[j].Path })
+	return fields
+}
+
+// Lookup returns the value at a dotted path within the traits, or nil if it is not set
+func Lookup(traits interface{}, path string) interface{} {
+	v := traits
+	for _, part := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[part]
+	}
+	return v
+}
+
+// orderedObject decodes a JSON object while remembering the order of its keys
+type orderedObject struct {
+	keys   []string
+	values map[string]json.RawMessage
+}
+
+func (o *orderedObject) UnmarshalJSON(b []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(b))
+	tok, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := tok.(json.Delim); !ok || delim != '{' {
+		return errors.New("expected a JSON object")
+	}
+	o.keys = nil
+	o.values = make(map[string]json.RawMessage)
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := tok.(string)
+		if !ok {
+			return errors.New("expected a JSON object key")
+		}
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return err
+		}
+		if _, exists := o.values[key]; !exists {
+			o.keys = append(o.keys, key)
+		}
+		o.values[key] = raw
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package schema
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const personSchema = `{
+  "title": "Person",
+  "properties": {
+    "traits": {
+      "type": "object",
+      "properties": {
+        "email": {"type": "string", "format": "email", "title": "E-Mail"},
+        "name": {
+          "type": "object",
+          "properties": {
+            "last": {"type": "string"},
+            "first": {"type": "string"}
+          }
+        }
+      }
+    }
+  }
+}`
+
+func TestParseKeepsDocumentOrder(t *testing.T) {
+	s, err := Parse("default", []byte(personSchema))
+	assert.Nil(t, err)
+	assert.Equal(t, "Person", s.Title)
+	assert.Len(t, s.Traits, 2)
+	assert.Equal(t, "E-Mail", s.Traits[0].Title)
+	assert.Equal(t, "email", s.Traits[0].Format)
+	assert.Equal(t, "name.last", s.Traits[1].Properties[0].Path)
+	assert.Equal(t, "First", s.Traits[1].Properties[1].Title)
+
+	_, err = Parse("broken", []byte(`{"properties": {}}`))
+	assert.EqualError(t, err, "identity schema 'broken' has no 'traits' property")
+}
+
+func TestFields(t *testing.T) {
+	s, err := Parse("default", []byte(personSchema))
+	assert.Nil(t, err)
+
+	traits := map[string]interface{}{
+		"email":   "user@example.com",
+		"name":    map[string]interface{}{"first": "Ada"},
+		"website": "https://example.com",
+	}
+	fields := s.Fields(traits)
+	var paths []string
+	for _, f := range fields {
+		paths = append(paths, f.Path)
+	}
+	assert.Equal(t, []string{"email", "name.last", "name.first", "website"}, paths)
+	assert.Equal(t, "user@example.com", fields[0].Value)
+	assert.Nil(t, fields[1].Value)
+	assert.Equal(t, "Name", fields[2].Section)
+	assert.Equal(t, "Ada", fields[2].Value)
+}
+
+func TestParseValidationAndHints(t *testing.T) {
+	s, err := Parse("default", []byte(`{
+  "properties": {
+    "traits": {
+      "required": ["email"],
+      "properties": {
+        "email": {"type": "string", "minLength": 3, "description": "Used to sign in", "x-ui": {"order": 2}},
+        "plan": {"type": ["string", "null"], "enum": ["free", "pro"]},
+        "phone": {"type": "string", "pattern": "^\\+[0-9]+$", "x-ui": {"order": 1, "group": "Contact"}}
+      }
+    }
+  }
+}`))
+	assert.Nil(t, err)
+
+	var names []string
+	for _, p := range s.Traits {
+		names = append(names, p.Name)
+	}
+	assert.Equal(t, []string{"phone", "email", "plan"}, names)
+
+	email := s.Property("email")
+	assert.True(t, email.Required)
+	assert.Equal(t, 3, *email.MinLength)
+	assert.Equal(t, "Used to sign in", email.Description)
+
+	plan := s.Property("plan")
+	assert.False(t, plan.Required)
+	assert.Equal(t, "string", plan.Type)
+	assert.Equal(t, []interface{}{"free", "pro"}, plan.Enum)
+
+	phone := s.Property("phone")
+	assert.Equal(t, `^\+[0-9]+$`, phone.Pattern)
+	assert.Equal(t, "Contact", phone.UI.Group)
+
+	assert.Nil(t, s.Property("missing"))
+}
+
+func TestValidate(t *testing.T) {
+	s, err := Parse("default", []byte(`{
+  "properties": {
+    "traits": {
+      "type": "object",
+      "required": ["email"],
+      "properties": {
+        "email": {"type": "string", "format": "email"},
+        "name": {
+          "type": "object",
+          "required": ["first"],
+          "properties": {
+            "first": {"type": "string", "minLength": 1, "maxLength": 5}
+          }
+        },
+        "age": {"type": "integer", "minimum": 0},
+        "plan": {"type": "string", "enum": ["free", "paid"]},
+        "code": {"type": "string", "pattern": "^[A-Z]+$"},
+        "tags": {"type": "array"},
+        "newsletter": {"type": "boolean"}
+      }
+    }
+  }
+}`))
+	assert.Nil(t, err)
+
+	assert.Empty(t, s.Validate(map[string]interface{}{
+		"email":      "a@example.com",
+		"name":       map[string]interface{}{"first": "Ann"},
+		"age":        float64(30),
+		"plan":       "free",
+		"code":       "ABC",
+		"tags":       []interface{}{"x"},
+		"newsletter": true,
+	}))
+	assert.Equal(t, []string{"'email' is required"}, s.Validate(map[string]interface{}{}))
+	assert.Equal(t, []string{
+		"'email' must be an email address",
+		"'name.first' is required",
+		"'age' must be a whole number",
+		"'plan' must be one of [free paid]",
+		"'code' doesn't match the pattern ^[A-Z]+$",
+		"'tags' must be a list",
+		"'newsletter' must be true or false",
+	}, s.Validate(map[string]interface{}{
+		"email":      "Ann <a@example.com>",
+		"name":       map[string]interface{}{},
+		"age":        1.5,
+		"plan":       "gold",
+		"code":       "abc",
+		"tags":       "x",
+		"newsletter": "yes",
+	}))
+	assert.Equal(t, []string{"'name.first' must be at most 5 characters", "'age' must be at least 0"}, s.Validate(map[string]interface{}{
+		"email": "a@example.com",
+		"name":  map[string]interface{}{"first": "Annabel"},
+		"age":   float64(-1),
+	}))
+}
@@ -0,0 +1,108 @@
+package schema
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"unicode/utf8"
+)
+
+// Validate checks traits against the schema's required properties, types and limits, returning a
+// message for each problem found. It checks what the schema package parses, which catches most bad
+// data before Kratos is asked to store it, Kratos still validates the full schema.
+func (s *Schema) Validate(traits map[string]interface{}) []string {
+	return validateObject(s.Traits, traits)
+}
+
+func validateObject(props []Property, values map[string]interface{}) []string {
+	var problems []string
+	for _, p := range props {
+		v, ok := values[p.Name]
+		if !ok || v == nil {
+			if p.Required {
+				problems = append(problems, fmt.Sprintf("'%s' is required", p.Path))
+			}
+			continue
+		}
+		problems = append(problems, validateValue(p, v)...)
+	}
+	return problems
+}
+
+func validateValue(p Property, v interface{}) []string {
+	switch p.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return []string{fmt.Sprintf("'%s' must be an object", p.Path)}
+		}
+		return validateObject(p.Properties, m)
+	case "array":
+		if _, ok := v.([]interface{}); !ok {
+			return []string{fmt.Sprintf("'%s' must be a list", p.Path)}
+		}
+		return nil
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return []string{fmt.Sprintf("'%s' must be true or false", p.Path)}
+		}
+		return nil
+	case "number", "integer":
+		n, ok := v.(float64)
+		if !ok {
+			return []string{fmt.Sprintf("'%s' must be a number", p.Path)}
+		}
+		if p.Type == "integer" && n != math.Trunc(n) {
+			return []string{fmt.Sprintf("'%s' must be a whole number", p.Path)}
+		}
+		if p.Minimum != nil && n < *p.Minimum {
+			return []string{fmt.Sprintf("'%s' must be at least %v", p.Path, *p.Minimum)}
+		}
+		if p.Maximum != nil && n > *p.Maximum {
+			return []string{fmt.Sprintf("'%s' must be at most %v", p.Path, *p.Maximum)}
+		}
+		return validateEnum(p, v)
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return []string{fmt.Sprintf("'%s' must be text", p.Path)}
+		}
+		return validateString(p, str)
+	}
+	return validateEnum(p, v)
+}
+
+func validateString(p Property, s string) []string {
+	n := utf8.RuneCountInString(s)
+	if p.MinLength != nil && n < *p.MinLength {
+		return []string{fmt.Sprintf("'%s' must be at least %d characters", p.Path, *p.MinLength)}
+	}
+	if p.MaxLength != nil && n > *p.MaxLength {
+		return []string{fmt.Sprintf("'%s' must be at most %d characters", p.Path, *p.MaxLength)}
+	}
+	if p.Pattern != "" {
+		re, err := regexp.Compile(p.Pattern)
+		if err == nil && !re.MatchString(s) {
+			return []string{fmt.Sprintf("'%s' doesn't match the pattern %s", p.Path, p.Pattern)}
+		}
+	}
+	if p.Format == "email" {
+		if a, err := mail.ParseAddress(s); err != nil || a.Address != s {
+			return []string{fmt.Sprintf("'%s' must be an email address", p.Path)}
+		}
+	}
+	return validateEnum(p, s)
+}
+
+func validateEnum(p Property, v interface{}) []string {
+	if len(p.Enum) == 0 {
+		return nil
+	}
+	for _, e := range p.Enum {
+		if e == v {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("'%s' must be one of %v", p.Path, p.Enum)}
+}
@@ -0,0 +1,140 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	client "github.com/ory/kratos-client-go"
+)
+
+const (
+	// DefaultKratosCookieName is the name of Kratos' session cookie, unless configured otherwise
+	DefaultKratosCookieName = "ory_kratos_session"
+
+	// maxCacheEntries bounds the memory used by the cache
+	maxCacheEntries = 10000
+)
+
+// Cache holds the Kratos sessions recently returned by ToSession, so that each page load doesn't have
+// to ask Kratos again. Entries are keyed by a hash of the Kratos session cookie, and are used until the
+// TTL passes or the session expires, whichever is first.
+type Cache struct {
+	// CookieName is the name of the Kratos session cookie
+	CookieName string
+
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+type cacheEntry struct {
+	session client.Session
+	until   time.Time
+}
+
+// NewCache returns a cache keeping sessions for ttl. A ttl of zero disables caching.
+func NewCache(cookieName string, ttl time.Duration) *Cache {
+	return &Cache{
+		CookieName: cookieName,
+		ttl:        ttl,
+		entries:    make(map[string]cacheEntry),
+	}
+}
+
+// Get returns the cached session for the request's Kratos cookie, or nil if there is none or it is stale
+func (c *Cache) Get(r *http.Request) *client.Session {
+	key := c.key(r)
+	if key == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.until) {
+		delete(c.entries, key)
+		return nil
+	}
+	ks := e.session
+	return &ks
+}
+
+// Put caches ks, which Kratos has just returned for the request's cookie. Inactive or expired sessions are not cached.
+func (c *Cache) Put(r *http.Request, ks *client.Session) {
+	key := c.key(r)
+	if key == "" || ks == nil || !ks.GetActive() {
+		return
+	}
+	until := time.Now().Add(c.ttl)
+	if ks.ExpiresAt != nil && ks.ExpiresAt.Before(until) {
+		until = *ks.ExpiresAt
+	}
+	if !until.After(time.Now()) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.entries) >= maxCacheEntries {
+		c.purge()
+	}
+	c.entries[key] = cacheEntry{session: *ks, until: until}
+}
+
+// Invalidate removes the session for the request's Kratos cookie
+func (c *Cache) Invalidate(r *http.Request) {
+	key := c.key(r)
+	if key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
+
+// InvalidateIdentity removes all the sessions of an identity, e.g. after its traits or credentials change
+func (c *Cache) InvalidateIdentity(identityID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, e := range c.entries {
+		if e.session.Identity.Id == identityID {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// purge removes stale entries, or all of them if the cache is still full. Callers must hold the lock.
+func (c *Cache) purge() {
+	now := time.Now()
+	for key, e := range c.entries {
+		if now.After(e.until) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) >= maxCacheEntries {
+		c.entries = make(map[string]cacheEntry)
+	}
+}
+
+// key returns the hash of the request's Kratos cookie, or "" if caching is disabled or there is no cookie.
+// The cookie is a bearer credential, so only its hash is kept.
+func (c *Cache) key(r *http.Request) string {
+	if c == nil || c.ttl <= 0 {
+		return ""
+	}
+	cookie, err := r.Cookie(c.CookieName)
+	if err != nil || cookie.Value == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(cookie.Value))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,62 @@
+package session
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	client "github.com/ory/kratos-client-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func requestWithCookie(value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if value != "" {
+		r.AddCookie(&http.Cookie{Name: DefaultKratosCookieName, Value: value})
+	}
+	return r
+}
+
+func testSession(id, identityID string, expiresIn time.Duration) *client.Session {
+	expiresAt := time.Now().Add(expiresIn)
+	active := true
+	return &client.Session{Id: id, Active: &active, ExpiresAt: &expiresAt, Identity: client.Identity{Id: identityID}}
+}
+
+func TestCache(t *testing.T) {
+	c := NewCache(DefaultKratosCookieName, time.Minute)
+	r := requestWithCookie("cookie-a")
+
+	assert.Nil(t, c.Get(r))
+	c.Put(r, testSession("s1", "i1", time.Hour))
+	if ks := c.Get(r); assert.NotNil(t, ks) {
+		assert.Equal(t, "s1", ks.Id)
+	}
+	assert.Nil(t, c.Get(requestWithCookie("cookie-b")), "keyed by cookie")
+	assert.Nil(t, c.Get(requestWithCookie("")), "no cookie, no session")
+
+	c.Invalidate(r)
+	assert.Nil(t, c.Get(r))
+
+	c.Put(r, testSession("s1", "i1", time.Hour))
+	c.InvalidateIdentity("i1")
+	assert.Nil(t, c.Get(r))
+}
+
+func TestCacheRespectsExpiry(t *testing.T) {
+	c := NewCache(DefaultKratosCookieName, time.Minute)
+	r := requestWithCookie("cookie-a")
+
+	c.Put(r, testSession("s1", "i1", 20*time.Millisecond))
+	assert.NotNil(t, c.Get(r))
+	time.Sleep(30 * time.Millisecond)
+	assert.Nil(t, c.Get(r), "not used after the session expires")
+
+	c.Put(r, testSession("s2", "i1", -time.Minute))
+	assert.Nil(t, c.Get(r), "expired sessions are not cached")
+
+	disabled := NewCache(DefaultKratosCookieName, 0)
+	disabled.Put(r, testSession("s3", "i1", time.Hour))
+	assert.Nil(t, disabled.Get(r))
+}
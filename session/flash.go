@@ -0,0 +1,61 @@
+package session
+
+import (
+	"encoding/gob"
+	"log"
+	"net/http"
+)
+
+// FlashType is the kind of a flash message, matching the Kratos message types
+type FlashType string
+
+const (
+	FlashInfo    FlashType = "info"
+	FlashWarning FlashType = "warning"
+	FlashError   FlashType = "error"
+)
+
+// Flash is a message shown once, on the next page the app renders
+type Flash struct {
+	Type FlashType
+	Text string
+}
+
+// keyFlashes holds the flash messages waiting to be shown
+const keyFlashes = "flashes"
+
+func init() {
+	// Flashes are stored in the session cookie
+	gob.Register([]Flash{})
+}
+
+// AddFlash stores a message to show on the next page rendered, e.g. after a redirect
+func (s SessionStore) AddFlash(w http.ResponseWriter, r *http.Request, t FlashType, text string) error {
+	session, err := s.Store.Get(r, SessionCookieName)
+	if err != nil {
+		log.Printf("Error decoding session, %v", err)
+		return err
+	}
+	flashes, _ := session.Values[keyFlashes].([]Flash)
+	session.Values[keyFlashes] = append(flashes, Flash{Type: t, Text: text})
+	return session.Save(r, w)
+}
+
+// ConsumeFlashes returns the messages waiting to be shown, and removes them from the session so they are
+// only shown once
+func (s SessionStore) ConsumeFlashes(w http.ResponseWriter, r *http.Request) []Flash {
+	session, err := s.Store.Get(r, SessionCookieName)
+	if err != nil {
+		log.Printf("Error decoding session, %v", err)
+		return nil
+	}
+	flashes, _ := session.Values[keyFlashes].([]Flash)
+	if len(flashes) == 0 {
+		return nil
+	}
+	delete(session.Values, keyFlashes)
+	if err := session.Save(r, w); err != nil {
+		log.Printf("Error saving session, %v", err)
+	}
+	return flashes
+}
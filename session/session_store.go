@@ -4,8 +4,10 @@ package session
 import (
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/davidoram/kratos-selfservice-ui-go/audit"
 	"github.com/gorilla/sessions"
 	client "github.com/ory/kratos-client-go"
 )
@@ -14,6 +16,9 @@ import (
 type SessionStore struct {
 	// Session store
 	Store *sessions.CookieStore
+
+	// Cache holds recently verified Kratos sessions, it is optional
+	Cache *Cache
 }
 
 const (
@@ -21,10 +26,13 @@ const (
 	SessionCookieName = "kgc-sess"
 
 	// Keys we store in our application session
-	keyKratosSession = "kratosSession"
+	keyKratosSession          = "kratosSession"
+	keyLookupSecretsSavedFlow = "lookupSecretsSavedFlow"
+	keyCSRFToken              = "csrfToken"
 )
 
 // SaveKratosSession stores a kratos session in the session store.
+// Storing a different session to the one already stored is audited as the session being established.
 func (s SessionStore) SaveKratosSession(w http.ResponseWriter, r *http.Request, ks *client.Session) error {
 	// Get a session. We're ignoring the error resulted from decoding an
 	// existing session: Get() always returns a session, even if empty.
@@ -33,6 +41,9 @@ func (s SessionStore) SaveKratosSession(w http.ResponseWriter, r *http.Request,
 		log.Printf("Error decoding session, %v", err)
 		return err
 	}
+	if prev, ok := session.Values[keyKratosSession].(client.Session); !ok || prev.Id != ks.Id {
+		audit.Record(r, audit.SessionEstablished, ks.Identity.Id, audit.Success, sessionDetail(ks))
+	}
 
 	// Add the value into the session store and set the expiry
 	session.Values[keyKratosSession] = *ks
@@ -81,8 +92,73 @@ func (s SessionStore) ClearKratosSession(w http.ResponseWriter, r *http.Request)
 		return err
 	}
 
-	// Clear the value stored in the session store
+	// Clear the value stored in the session store, and the cached Kratos session
+	s.Cache.Invalidate(r)
+	if prev, ok := session.Values[keyKratosSession].(client.Session); ok {
+		audit.Record(r, audit.SessionCleared, prev.Identity.Id, audit.Success, "")
+	}
 	delete(session.Values, keyKratosSession)
 	session.Options.MaxAge = -1
 	return session.Save(r, w)
 }
+
+// SetLookupSecretsSaved records that the user has downloaded or printed the lookup secrets
+// (backup recovery codes) revealed in the settings flow with flowID.
+func (s SessionStore) SetLookupSecretsSaved(w http.ResponseWriter, r *http.Request, flowID string) error {
+	session, err := s.Store.Get(r, SessionCookieName)
+	if err != nil {
+		log.Printf("Error decoding session, %v", err)
+		return err
+	}
+	session.Values[keyLookupSecretsSavedFlow] = flowID
+	return session.Save(r, w)
+}
+
+// LookupSecretsSaved checks if the user has downloaded or printed the lookup secrets
+// revealed in the settings flow with flowID.
+func (s SessionStore) LookupSecretsSaved(r *http.Request, flowID string) bool {
+	session, err := s.Store.Get(r, SessionCookieName)
+	if err != nil {
+		log.Printf("Error decoding session, %v", err)
+		return false
+	}
+	saved, _ := session.Values[keyLookupSecretsSavedFlow].(string)
+	return flowID != "" && saved == flowID
+}
+
+// SetCSRFToken stores the token that forms posted to this application must include
+func (s SessionStore) SetCSRFToken(w http.ResponseWriter, r *http.Request, token string) error {
+	session, err := s.Store.Get(r, SessionCookieName)
+	if err != nil {
+		log.Printf("Error decoding session, %v", err)
+		return err
+	}
+	session.Values[keyCSRFToken] = token
+	return session.Save(r, w)
+}
+
+// GetCSRFToken returns the token that forms posted to this application must include, or "" if none has been set
+func (s SessionStore) GetCSRFToken(r *http.Request) string {
+	session, err := s.Store.Get(r, SessionCookieName)
+	if err != nil {
+		log.Printf("Error decoding session, %v", err)
+		return ""
+	}
+	token, _ := session.Values[keyCSRFToken].(string)
+	return token
+}
+
+// sessionDetail describes how a session was authenticated, e.g. "aal2 password,totp"
+func sessionDetail(ks *client.Session) string {
+	var methods []string
+	for _, m := range ks.AuthenticationMethods {
+		if m.Method != nil {
+			methods = append(methods, *m.Method)
+		}
+	}
+	aal := ""
+	if ks.AuthenticatorAssuranceLevel != nil {
+		aal = string(*ks.AuthenticatorAssuranceLevel)
+	}
+	return strings.TrimSpace(aal + " " + strings.Join(methods, ","))
+}
@@ -0,0 +1,69 @@
+// Solves the proof of work challenge rendered by the 'pow' challenge provider. The search starts when the page
+// loads, and if the form is submitted before the solution is found it is sent once it is.
+(() => {
+  const challenge = document.querySelector('input[name="pow_challenge"]')
+  if (!challenge || !challenge.form) {
+    return
+  }
+  const form = challenge.form
+  const solution = form.querySelector('input[name="pow_solution"]')
+  const status = form.querySelector('[data-pow-status]')
+  const difficulty = parseInt(challenge.dataset.powDifficulty, 10)
+  const setStatus = (text) => {
+    if (status) {
+      status.textContent = text
+    }
+  }
+
+  if (!window.crypto || !window.crypto.subtle) {
+    setStatus('Your browser can\'t complete the check needed to send this form, try another browser.')
+    return
+  }
+
+  const leadingZeroBits = (bytes) => {
+    let count = 0
+    for (const b of bytes) {
+      if (b !== 0) {
+        return count + Math.clz32(b) - 24
+      }
+      count += 8
+    }
+    return count
+  }
+
+  const encoder = new TextEncoder()
+  const solve = async () => {
+    for (let n = 0; ; n++) {
+      const digest = await crypto.subtle.digest('SHA-256', encoder.encode(challenge.value + ':' + n))
+      if (leadingZeroBits(new Uint8Array(digest)) >= difficulty) {
+        return String(n)
+      }
+    }
+  }
+
+  // The button clicked is kept, as Kratos needs its 'method' value
+  let waiting = null
+  form.addEventListener('submit', (event) => {
+    if (solution.value) {
+      return
+    }
+    event.preventDefault()
+    waiting = { submitter: event.submitter }
+    setStatus('Checking your browser, the form will be sent in a moment.')
+  })
+
+  solve().then((n) => {
+    solution.value = n
+    setStatus('')
+    if (!waiting) {
+      return
+    }
+    if (form.requestSubmit) {
+      form.requestSubmit(waiting.submitter || undefined)
+    } else if (waiting.submitter) {
+      waiting.submitter.click()
+    } else {
+      form.submit()
+    }
+  })
+})()
@@ -0,0 +1,64 @@
+// Enhances the TOTP forms rendered by the 'totp' partial, and the TOTP code input on the login page.
+// Everything here is progressive enhancement, the forms work without it.
+(() => {
+  // Copy the secret key to the clipboard
+  for (const button of document.querySelectorAll('[data-totp-copy]')) {
+    if (!navigator.clipboard) {
+      button.hidden = true
+      continue
+    }
+    button.addEventListener('click', () => {
+      navigator.clipboard.writeText(button.dataset.totpCopy).then(() => {
+        const label = button.textContent
+        button.textContent = 'Copied'
+        setTimeout(() => { button.textContent = label }, 2000)
+      })
+    })
+  }
+
+  // Submit the form once 6 digits have been entered
+  const codeInputs = document.querySelectorAll('[data-totp-code], input[name="totp_code"]')
+  for (const input of codeInputs) {
+    input.setAttribute('inputmode', 'numeric')
+    input.setAttribute('autocomplete', 'one-time-code')
+    input.addEventListener('input', () => {
+      const digits = input.value.replace(/\D/g, '').slice(0, 6)
+      if (digits !== input.value) {
+        input.value = digits
+      }
+      if (digits.length === 6 && input.form && !input.form.dataset.totpSubmitted) {
+        input.form.dataset.totpSubmitted = 'true'
+        const submit = input.form.querySelector('button[type="submit"][name="method"][value="totp"]')
+        if (submit && input.form.requestSubmit) {
+          input.form.requestSubmit(submit)
+        } else if (submit) {
+          submit.click()
+        } else {
+          input.form.submit()
+        }
+      }
+    })
+  }
+
+  // Ask for confirmation before unlinking the authenticator app
+  const dialog = document.querySelector('[data-totp-unlink-dialog]')
+  const unlink = document.querySelector('[data-totp-unlink]')
+  if (dialog && unlink && dialog.showModal) {
+    unlink.addEventListener('click', (event) => {
+      if (unlink.dataset.confirmed) {
+        return
+      }
+      event.preventDefault()
+      dialog.showModal()
+    })
+    dialog.querySelectorAll('[data-totp-dialog-close]').forEach((button) => {
+      button.addEventListener('click', () => dialog.close(button.dataset.totpDialogClose))
+    })
+    dialog.addEventListener('close', () => {
+      if (dialog.returnValue === 'confirm') {
+        unlink.dataset.confirmed = 'true'
+        unlink.click()
+      }
+    })
+  }
+})()
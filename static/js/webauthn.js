@@ -0,0 +1,139 @@
+// Improves the WebAuthn (security key and passkey) experience on top of Kratos' own webauthn.js:
+//
+// - browsers without WebAuthn get an explanation instead of buttons that can't work
+// - a new key must be named before registering it
+// - errors from the browser are shown as form messages rather than alerts
+// - if the login flow carries credential request options, the browser may offer them in the
+//   username autofill (conditional UI)
+(() => {
+  const supported = !!(window.PublicKeyCredential && navigator.credentials)
+  const triggers = document.querySelectorAll(
+    'button[name="webauthn_login_trigger"], button[name="webauthn_register_trigger"], input[name="webauthn_login_trigger"], input[name="webauthn_register_trigger"]')
+
+  if (!supported) {
+    for (const el of document.querySelectorAll('[data-webauthn-requires]')) {
+      el.hidden = true
+    }
+    for (const trigger of triggers) {
+      trigger.disabled = true
+      showMessage(trigger, 'This browser does not support security keys or passkeys.')
+    }
+    for (const el of document.querySelectorAll('[data-webauthn-unsupported]')) {
+      el.hidden = false
+    }
+    return
+  }
+
+  // Error names are defined in https://www.w3.org/TR/webauthn-2/#sctn-createCredential
+  const errorMessages = {
+    NotAllowedError: 'The request was cancelled, or timed out. Please try again.',
+    InvalidStateError: 'This security key is already registered with your account.',
+    NotSupportedError: 'This security key is not supported.',
+    SecurityError: 'Security keys cannot be used on this site address.',
+    AbortError: 'The request was cancelled.',
+    ConstraintError: 'This security key cannot be used, it does not support the required checks.',
+  }
+
+  function showMessage(near, text) {
+    const form = near.closest('form') || document
+    let messages = form.querySelector('[data-webauthn-messages]') || form.querySelector('.messages')
+    if (!messages) {
+      messages = document.createElement('div')
+      messages.className = 'messages'
+      form.prepend(messages)
+    }
+    const message = document.createElement('div')
+    message.className = 'message'
+    message.dataset.testid = 'ui/message/webauthn'
+    message.textContent = text
+    messages.replaceChildren(message)
+  }
+
+  let lastTrigger = triggers[0]
+  for (const trigger of triggers) {
+    trigger.addEventListener('click', () => { lastTrigger = trigger }, { capture: true })
+  }
+
+  // Kratos' script reports failures with alert(), so catch them first and show them on the form instead
+  const originalGet = navigator.credentials.get.bind(navigator.credentials)
+  const originalCreate = navigator.credentials.create.bind(navigator.credentials)
+  const reportErrors = (call) => (options) => call(options).catch((err) => {
+    if (lastTrigger) {
+      showMessage(lastTrigger, errorMessages[err.name] || ('Your security key could not be used: ' + err.message))
+    }
+    // Never settles, so Kratos' script doesn't also report the error
+    return new Promise(() => {})
+  })
+  navigator.credentials.get = reportErrors(originalGet)
+  navigator.credentials.create = reportErrors(originalCreate)
+
+  // A new key must be named before it is registered
+  const displayName = document.querySelector('[data-webauthn-displayname]')
+  const register = document.querySelector('[name="webauthn_register_trigger"]')
+  if (displayName && register && register.form) {
+    register.form.addEventListener('click', (event) => {
+      if (event.target.closest('[name="webauthn_register_trigger"]') && displayName.value.trim() === '') {
+        event.preventDefault()
+        event.stopPropagation()
+        showMessage(register, 'Please give your new key a name first.')
+        displayName.focus()
+      }
+    }, { capture: true })
+  }
+
+  // Conditional UI: offer the user's passkeys in the username autofill
+  const optionsEl = document.querySelector('[data-webauthn-login-options]')
+  const loginTrigger = document.querySelector('[name="webauthn_login_trigger"]')
+  if (!optionsEl || !loginTrigger || !PublicKeyCredential.isConditionalMediationAvailable) {
+    return
+  }
+  PublicKeyCredential.isConditionalMediationAvailable().then((available) => {
+    if (!available) {
+      return
+    }
+    const options = JSON.parse(optionsEl.dataset.webauthnLoginOptions)
+    const publicKey = Object.assign({}, options.publicKey, {
+      challenge: decode(options.publicKey.challenge),
+      allowCredentials: (options.publicKey.allowCredentials || []).map((c) => Object.assign({}, c, { id: decode(c.id) })),
+    })
+    const identifier = document.querySelector('input[name="identifier"]:not([type="hidden"])')
+    if (identifier) {
+      identifier.setAttribute('autocomplete', 'username webauthn')
+    }
+
+    // Clicking the trigger starts Kratos' own (modal) request, which replaces this one
+    const abort = new AbortController()
+    loginTrigger.addEventListener('click', () => abort.abort(), { capture: true })
+    originalGet({ mediation: 'conditional', signal: abort.signal, publicKey: publicKey }).then((credential) => {
+      document.querySelector('*[name="webauthn_login"]').value = JSON.stringify({
+        id: credential.id,
+        rawId: encode(credential.rawId),
+        type: credential.type,
+        response: {
+          authenticatorData: encode(credential.response.authenticatorData),
+          clientDataJSON: encode(credential.response.clientDataJSON),
+          signature: encode(credential.response.signature),
+          userHandle: encode(credential.response.userHandle),
+        },
+      })
+      loginTrigger.closest('form').submit()
+    }).catch((err) => {
+      if (err.name !== 'AbortError') {
+        showMessage(loginTrigger, errorMessages[err.name] || ('Your passkey could not be used: ' + err.message))
+      }
+    })
+  })
+
+  // base64url helpers, matching the encoding Kratos uses
+  function decode(value) {
+    return Uint8Array.from(atob(value.replace(/-/g, '+').replace(/_/g, '/')), (c) => c.charCodeAt(0))
+  }
+
+  function encode(value) {
+    if (!value) {
+      return ''
+    }
+    return btoa(String.fromCharCode.apply(null, new Uint8Array(value)))
+      .replace(/\+/g, '-').replace(/\//g, '_').replace(/=/g, '')
+  }
+})()